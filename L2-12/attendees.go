@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Участники событий: владелец приглашает других пользователей, приглашенные видят событие
+// в своем календаре и отвечают на приглашение. Менять и удалять событие может только владелец.
+
+// maxAttendees — сколько пользователей можно пригласить на одно событие
+const maxAttendees = 100
+
+// Ответы на приглашение
+const (
+	RSVPNeedsAction = "needs-action"
+	RSVPAccepted    = "accepted"
+	RSVPDeclined    = "declined"
+	RSVPTentative   = "tentative"
+)
+
+// ErrNotInvited возвращается, если пользователь отвечает на событие, на которое не приглашен
+var ErrNotInvited = newForbiddenError("user is not invited to the event")
+
+// Attendee — приглашенный пользователь и его ответ
+type Attendee struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status"`
+	// RespondedAt — время последнего ответа; nil, пока приглашенный не ответил
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+// RSVPSummary — число участников с каждым ответом
+type RSVPSummary struct {
+	Accepted    int `json:"accepted"`
+	Tentative   int `json:"tentative"`
+	Declined    int `json:"declined"`
+	NeedsAction int `json:"needs_action"`
+}
+
+// parseAttendees разбирает список ID приглашенных пользователей вида "2,3"
+func parseAttendees(value string) ([]Attendee, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var attendees []Attendee
+	for _, part := range strings.Split(value, ",") {
+		userID, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || userID <= 0 {
+			return nil, newValidationError(fmt.Sprintf("invalid attendee %q", part))
+		}
+		attendees = append(attendees, Attendee{UserID: userID, Status: RSVPNeedsAction})
+	}
+	return attendees, nil
+}
+
+// validateAttendees проверяет участников события; повторные приглашения удаляются
+func validateAttendees(ownerID int, attendees []Attendee) ([]Attendee, error) {
+	if len(attendees) > maxAttendees {
+		return nil, newValidationError(fmt.Sprintf("at most %d attendees per event", maxAttendees))
+	}
+	seen := make(map[int]bool, len(attendees))
+	var result []Attendee
+	for _, attendee := range attendees {
+		if attendee.UserID == ownerID {
+			return nil, newValidationError("the owner cannot be an attendee")
+		}
+		if attendee.Status == "" {
+			attendee.Status = RSVPNeedsAction
+		}
+		if !validRSVP(attendee.Status) && attendee.Status != RSVPNeedsAction {
+			return nil, newValidationError(fmt.Sprintf("invalid attendee status %q", attendee.Status))
+		}
+		if !seen[attendee.UserID] {
+			seen[attendee.UserID] = true
+			result = append(result, attendee)
+		}
+	}
+	return result, nil
+}
+
+// validRSVP сообщает, может ли приглашенный ответить status
+func validRSVP(status string) bool {
+	return status == RSVPAccepted || status == RSVPDeclined || status == RSVPTentative
+}
+
+// keepResponses переносит ответы участников, оставшихся в обновленном событии. Если изменилось
+// время или правило повторения, ответы сбрасываются: участники соглашались на другое время.
+func keepResponses(current, updated Event) []Attendee {
+	if !sameSchedule(current, updated) {
+		return updated.Attendees
+	}
+	attendees := make([]Attendee, len(updated.Attendees))
+	for i, attendee := range updated.Attendees {
+		if previous, ok := current.attendee(attendee.UserID); ok {
+			attendee = previous
+		}
+		attendees[i] = attendee
+	}
+	return attendees
+}
+
+// sameSchedule сообщает, совпадают ли время и повторения двух версий события
+func sameSchedule(a, b Event) bool {
+	if !a.Start.Equal(b.Start) || !a.End.Equal(b.End) || a.AllDay != b.AllDay || a.TimeZone != b.TimeZone {
+		return false
+	}
+	if a.Recurrence == nil || b.Recurrence == nil {
+		return a.Recurrence == nil && b.Recurrence == nil
+	}
+	if a.Recurrence.String() != b.Recurrence.String() || len(a.Recurrence.Exceptions) != len(b.Recurrence.Exceptions) {
+		return false
+	}
+	for i, ex := range a.Recurrence.Exceptions {
+		if !ex.Equal(b.Recurrence.Exceptions[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// attendee возвращает участника события с ID userID
+func (e Event) attendee(userID int) (Attendee, bool) {
+	for _, attendee := range e.Attendees {
+		if attendee.UserID == userID {
+			return attendee, true
+		}
+	}
+	return Attendee{}, false
+}
+
+// withAttendee возвращает копию события, в которой участник с тем же UserID заменен на attendee
+func (e Event) withAttendee(attendee Attendee) (Event, error) {
+	attendees := append([]Attendee(nil), e.Attendees...)
+	for i := range attendees {
+		if attendees[i].UserID == attendee.UserID {
+			attendees[i] = attendee
+			e.Attendees = attendees
+			return e, nil
+		}
+	}
+	return Event{}, ErrNotInvited
+}
+
+// participants возвращает владельца и всех приглашенных
+func (e Event) participants() []int {
+	users := make([]int, 0, len(e.Attendees)+1)
+	users = append(users, e.UserID)
+	for _, attendee := range e.Attendees {
+		users = append(users, attendee.UserID)
+	}
+	return users
+}
+
+// involves сообщает, видит ли пользователь событие в своем календаре: как владелец или приглашенный
+func (e Event) involves(userID int) bool {
+	_, invited := e.attendee(userID)
+	return e.UserID == userID || invited
+}
+
+// summarizeResponses подсчитывает ответы участников; nil, если приглашенных нет
+func summarizeResponses(attendees []Attendee) *RSVPSummary {
+	if len(attendees) == 0 {
+		return nil
+	}
+	summary := &RSVPSummary{}
+	for _, attendee := range attendees {
+		switch attendee.Status {
+		case RSVPAccepted:
+			summary.Accepted++
+		case RSVPTentative:
+			summary.Tentative++
+		case RSVPDeclined:
+			summary.Declined++
+		default:
+			summary.NeedsAction++
+		}
+	}
+	return summary
+}
+
+//HTTP-обработчики
+
+// Ответ приглашенного на событие: status — accepted, declined или tentative
+func (s *server) rsvpEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	params, err := readParams(w, r, s.maxBodyBytes, "id", "user_id", "status")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, userID, err := validateIDParams(params.Get("id"), params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := params.Get("status")
+	if !validRSVP(status) {
+		writeError(w, newValidationError("status must be accepted, declined or tentative"))
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	event, err := s.service.RespondToEvent(id, userID, status)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, event)
+}
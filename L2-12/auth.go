@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Аутентификация по API-токенам. Токен привязан к пользователю: с ним можно работать
+// только с календарем этого пользователя. Токены администратора дают доступ ко всем
+// календарям и к выдаче новых токенов. В файле хранятся только SHA-256 хэши токенов.
+
+// tokenPrefix помогает узнать токен календаря в логах и менеджерах секретов
+const tokenPrefix = "cal_"
+
+var (
+	// ErrUnauthorized возвращается, если токен не передан или не найден
+	ErrUnauthorized = newStatusError(http.StatusUnauthorized, "missing or invalid bearer token")
+	// ErrForbidden возвращается при обращении к чужому календарю
+	ErrForbidden = newForbiddenError("access to another user's calendar is forbidden")
+	// ErrAdminRequired возвращается при вызове административного метода без прав администратора
+	ErrAdminRequired = newForbiddenError("admin token required")
+)
+
+// Token — выданный API-токен. Сам секрет не хранится, только его хэш.
+type Token struct {
+	ID string `json:"id"`
+	// Hash — SHA-256 от секрета в hex
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	Admin     bool      `json:"admin,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// tokenFile — формат файла токенов
+type tokenFile struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// tokenStore хранит токены в JSON-файле. Файл перечитывается при изменении, поэтому
+// токены, выданные из командной строки, начинают действовать без перезапуска сервера.
+type tokenStore struct {
+	mu      sync.Mutex
+	path    string
+	byHash  map[string]Token
+	modTime time.Time
+	size    int64
+}
+
+// openTokenStore загружает токены из файла; отсутствующий файл означает пустой список
+func openTokenStore(path string) (*tokenStore, error) {
+	t := &tokenStore{path: path, byHash: make(map[string]Token)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Lookup находит токен по секрету
+func (t *tokenStore) Lookup(secret string) (Token, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.reloadLocked(); err != nil {
+		return Token{}, false, err
+	}
+	token, ok := t.byHash[hashToken(secret)]
+	return token, ok, nil
+}
+
+// Issue выдает новый токен и возвращает его секрет; секрет больше нигде не сохраняется
+func (t *tokenStore) Issue(userID int, admin bool) (string, Token, error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", Token{}, err
+	}
+	secret = tokenPrefix + secret
+	id, err := randomString(8)
+	if err != nil {
+		return "", Token{}, err
+	}
+	token := Token{ID: id, Hash: hashToken(secret), UserID: userID, Admin: admin, CreatedAt: time.Now().UTC()}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.reloadLocked(); err != nil {
+		return "", Token{}, err
+	}
+	t.byHash[token.Hash] = token
+	if err := t.saveLocked(); err != nil {
+		delete(t.byHash, token.Hash)
+		return "", Token{}, err
+	}
+	return secret, token, nil
+}
+
+// Revoke отзывает токен по ID
+func (t *tokenStore) Revoke(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.reloadLocked(); err != nil {
+		return err
+	}
+	for hash, token := range t.byHash {
+		if token.ID == id {
+			delete(t.byHash, hash)
+			if err := t.saveLocked(); err != nil {
+				t.byHash[hash] = token
+				return err
+			}
+			return nil
+		}
+	}
+	return newDomainError("token not found")
+}
+
+// reloadLocked перечитывает файл, если он изменился с прошлой загрузки
+func (t *tokenStore) reloadLocked() error {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.byHash, t.modTime, t.size = make(map[string]Token), time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat tokens file: %w", err)
+	}
+	if info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return fmt.Errorf("read tokens file: %w", err)
+	}
+	var file tokenFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse tokens file %s: %w", t.path, err)
+	}
+	t.byHash = make(map[string]Token, len(file.Tokens))
+	for _, token := range file.Tokens {
+		t.byHash[token.Hash] = token
+	}
+	t.modTime, t.size = info.ModTime(), info.Size()
+	return nil
+}
+
+func (t *tokenStore) saveLocked() error {
+	file := tokenFile{Tokens: make([]Token, 0, len(t.byHash))}
+	for _, token := range t.byHash {
+		file.Tokens = append(file.Tokens, token)
+	}
+	sort.Slice(file.Tokens, func(i, j int) bool {
+		return file.Tokens[i].CreatedAt.Before(file.Tokens[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode tokens: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return fmt.Errorf("create tokens dir: %w", err)
+	}
+	if err := writeFileSync(t.path, data, 0o600); err != nil {
+		return fmt.Errorf("write tokens file: %w", err)
+	}
+	if info, err := os.Stat(t.path); err == nil {
+		t.modTime, t.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// tokenFromContext возвращает токен, которым аутентифицирован запрос
+func tokenFromContext(ctx context.Context) (Token, bool) {
+	token, ok := ctx.Value(tokenKey).(Token)
+	return token, ok
+}
+
+// publicPaths — пути, доступные без токена. /metrics сюда не входит: метрики раскрывают
+// нагрузку и число событий всех пользователей, поэтому Prometheus опрашивает их с токеном.
+var publicPaths = map[string]bool{
+	"/":             true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/openapi.json": true,
+}
+
+// AuthMiddleware проверяет заголовок Authorization: Bearer <token> и кладет найденный
+// токен в контекст запроса. Без валидного токена отвечает 401.
+func AuthMiddleware(tokens *tokenStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, secret, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || secret == "" {
+			unauthorized(w)
+			return
+		}
+		token, ok, err := tokens.Lookup(strings.TrimSpace(secret))
+		if err != nil {
+			writeError(w, internalError(err))
+			return
+		}
+		if !ok {
+			unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey, token)))
+	})
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="calendar"`)
+	writeError(w, ErrUnauthorized)
+}
+
+// authorize проверяет, что запрос может работать с календарем пользователя userID.
+// Без аутентификации (auth выключена) разрешено все.
+func authorize(r *http.Request, userID int) error {
+	token, ok := tokenFromContext(r.Context())
+	if !ok || token.Admin || token.UserID == userID {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// issueTokenCommand — подкоманда issue-token: выдает токен без запущенного сервера.
+// Так создается первый токен администратора. Секрет печатается в stdout один раз.
+func issueTokenCommand(args []string) error {
+	fs := flag.NewFlagSet("calendar issue-token", flag.ContinueOnError)
+	userID := fs.Int("user-id", 0, "User the token is bound to")
+	admin := fs.Bool("admin", false, "Issue an admin token with access to all calendars")
+	cfg, err := loadConfigFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *userID <= 0 && !*admin {
+		return errors.New("-user-id is required")
+	}
+
+	tokens, err := openTokenStore(cfg.Auth.TokensFile)
+	if err != nil {
+		return err
+	}
+	secret, token, err := tokens.Issue(*userID, *admin)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Токен %s выдан пользователю %d (admin=%t), файл %s\n",
+		token.ID, token.UserID, token.Admin, cfg.Auth.TokensFile)
+	fmt.Println(secret)
+	return nil
+}
+
+//HTTP-обработчики
+
+// Выдача токена пользователю. Доступна только с токеном администратора.
+func (s *server) issueTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if token, _ := tokenFromContext(r.Context()); !token.Admin {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	params, err := readParams(w, r, s.maxBodyBytes, "user_id", "admin")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := parseUserID(params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	admin := params.Get("admin") == "true"
+
+	secret, token, err := s.tokens.Issue(userID, admin)
+	if err != nil {
+		writeError(w, internalError(err))
+		return
+	}
+	respond(w, r, map[string]interface{}{
+		"id":         token.ID,
+		"token":      secret,
+		"user_id":    token.UserID,
+		"admin":      token.Admin,
+		"created_at": token.CreatedAt,
+	})
+}
+
+// Отзыв токена по ID. Доступен только с токеном администратора.
+func (s *server) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if token, _ := tokenFromContext(r.Context()); !token.Admin {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	params, err := readParams(w, r, s.maxBodyBytes, "id")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if params.Get("id") == "" {
+		writeError(w, newValidationError("id is required"))
+		return
+	}
+	if err := s.tokens.Revoke(params.Get("id")); err != nil {
+		writeError(w, internalError(err))
+		return
+	}
+	respond(w, r, "token revoked")
+}
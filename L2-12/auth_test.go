@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", "tokens.json")
+	tokens, err := openTokenStore(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии файла токенов: %v", err)
+	}
+
+	secret, issued, err := tokens.Issue(7, false)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при выдаче токена: %v", err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) || issued.Hash != hashToken(secret) || issued.UserID != 7 {
+		t.Errorf("Неожиданный токен %+v с секретом %q", issued, secret)
+	}
+
+	// В файле лежит только хэш, и читать файл может только владелец
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при чтении файла токенов: %v", err)
+	}
+	if strings.Contains(string(data), secret) || !strings.Contains(string(data), issued.Hash) {
+		t.Errorf("Файл должен хранить хэш вместо секрета: %s", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Ожидаются права 0600, получено %o", perm)
+	}
+
+	// Токен, выданный другим процессом (командой issue-token), подхватывается без перезапуска
+	other, err := openTokenStore(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии файла токенов: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond) // чтобы у файла сменилось время изменения
+	adminSecret, admin, err := other.Issue(1, true)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при выдаче токена: %v", err)
+	}
+	if token, ok, err := tokens.Lookup(adminSecret); err != nil || !ok || !token.Admin {
+		t.Errorf("Токен из другого процесса должен находиться, получено %+v, %t, %v", token, ok, err)
+	}
+	if _, ok, _ := tokens.Lookup(issued.Hash); ok {
+		t.Error("Хэш не должен приниматься вместо секрета")
+	}
+
+	if err := tokens.Revoke(admin.ID); err != nil {
+		t.Fatalf("Неожиданная ошибка при отзыве токена: %v", err)
+	}
+	if _, ok, _ := other.Lookup(adminSecret); ok {
+		t.Error("Отозванный токен не должен находиться")
+	}
+	if err := tokens.Revoke(admin.ID); errorStatus(err) != http.StatusServiceUnavailable {
+		t.Errorf("Ожидается ошибка бизнес-логики при повторном отзыве, получено %v", err)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tokens, err := openTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии файла токенов: %v", err)
+	}
+	userSecret, _, _ := tokens.Issue(1, false)
+	adminSecret, _, _ := tokens.Issue(1, true)
+
+	// Обработчик пускает только к календарю пользователя 2
+	handler := AuthMiddleware(tokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authorize(r, 2); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+	}{
+		{"public path", "/", "", http.StatusOK},
+		{"missing token", "/events_for_day", "", http.StatusUnauthorized},
+		{"wrong scheme", "/events_for_day", "Basic " + userSecret, http.StatusUnauthorized},
+		{"unknown token", "/events_for_day", "Bearer " + tokenPrefix + "unknown", http.StatusUnauthorized},
+		{"another user's calendar", "/events_for_day", "Bearer " + userSecret, http.StatusForbidden},
+		{"admin", "/events_for_day", "bearer " + adminSecret, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("Ожидается код %d, получено %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Ответ 401 должен содержать WWW-Authenticate")
+			}
+		})
+	}
+
+	// Выдавать токены может только администратор
+	s := &server{tokens: tokens, maxBodyBytes: 1 << 20}
+	issue := AuthMiddleware(tokens, http.HandlerFunc(s.issueTokenHandler))
+	for secret, status := range map[string]int{userSecret: http.StatusForbidden, adminSecret: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/issue_token", strings.NewReader("user_id=5"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rec := httptest.NewRecorder()
+		issue.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("Ожидается код %d при выдаче токена, получено %d: %s", status, rec.Code, rec.Body)
+		}
+	}
+}
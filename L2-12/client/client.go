@@ -0,0 +1,479 @@
+// Package client — типизированный клиент HTTP API календаря (L2-12).
+// Методы соответствуют операциям с тегом events в описании API openapi.json, кроме потока SSE;
+// запросы отправляются в JSON.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event — событие календаря
+type Event struct {
+	ID     int       `json:"id"`
+	UserID int       `json:"user_id"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// TimeZone — часовой пояс события; Start и End приходят в нем
+	TimeZone   string      `json:"time_zone"`
+	AllDay     bool        `json:"all_day,omitempty"`
+	Note       string      `json:"note"`
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+	// Reminders — за сколько до начала события отправляются напоминания
+	Reminders []Duration `json:"reminders,omitempty"`
+	// Attendees — приглашенные пользователи и их ответы
+	Attendees []Attendee `json:"attendees,omitempty"`
+	// Responses — сводка ответов приглашенных
+	Responses *RSVPSummary `json:"responses,omitempty"`
+	// Conflicts — ID пересекающихся событий того же пользователя
+	Conflicts []int `json:"conflicts,omitempty"`
+	// UID — идентификатор события из импортированного .ics
+	UID string `json:"uid,omitempty"`
+}
+
+// Recurrence — правило повторения события
+type Recurrence struct {
+	// Freq — DAILY, WEEKLY, MONTHLY или YEARLY
+	Freq       string      `json:"freq"`
+	Interval   int         `json:"interval,omitempty"`
+	ByDay      []string    `json:"by_day,omitempty"`
+	Count      int         `json:"count,omitempty"`
+	Until      *time.Time  `json:"until,omitempty"`
+	Exceptions []time.Time `json:"exceptions,omitempty"`
+}
+
+// Ответы на приглашение
+const (
+	RSVPNeedsAction = "needs-action"
+	RSVPAccepted    = "accepted"
+	RSVPDeclined    = "declined"
+	RSVPTentative   = "tentative"
+)
+
+// Attendee — приглашенный пользователь и его ответ
+type Attendee struct {
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+// RSVPSummary — число участников с каждым ответом
+type RSVPSummary struct {
+	Accepted    int `json:"accepted"`
+	Tentative   int `json:"tentative"`
+	Declined    int `json:"declined"`
+	NeedsAction int `json:"needs_action"`
+}
+
+// Типы изменений в истории события
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// HistoryEntry — версия события
+type HistoryEntry struct {
+	EventID int    `json:"event_id"`
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	// ChangedBy — владелец или приглашенный, ответивший на приглашение
+	ChangedBy int       `json:"changed_by"`
+	At        time.Time `json:"at"`
+	// Before — событие до изменения, nil при создании
+	Before *Event `json:"before,omitempty"`
+	// After — событие после изменения, nil при удалении
+	After *Event `json:"after,omitempty"`
+	// RevertedTo — версия, к которой откатили событие; 0, если это не откат
+	RevertedTo int `json:"reverted_to,omitempty"`
+}
+
+// Duration — time.Duration, который в JSON записывается строкой вида "15m0s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// EventParams — параметры создания и изменения события
+type EventParams struct {
+	UserID int
+	// AllDay — событие на весь день Start; End не используется
+	AllDay     bool
+	Start, End time.Time
+	// TimeZone — часовой пояс события (IANA); пустой — пояс сервера
+	TimeZone string
+	Note     string
+	// RRule — правило повторения в синтаксисе RFC 5545, например "FREQ=WEEKLY;BYDAY=MO"
+	RRule string
+	// ExDates — даты, в которые повторение пропускается
+	ExDates   []time.Time
+	Reminders []time.Duration
+	// Attendees — ID приглашенных пользователей. При изменении события ответы сохраняются,
+	// если не изменились время и повторения.
+	Attendees []int
+}
+
+// SearchParams — параметры поиска событий
+type SearchParams struct {
+	UserID int
+	// Text — слова, которые должны встретиться в заметке
+	Text string
+	// From и To ограничивают диапазон [From, To); нулевое время — без ограничения
+	From, To time.Time
+	// Descending — от поздних событий к ранним
+	Descending bool
+	// Limit — размер страницы; 0 — по умолчанию сервера
+	Limit int
+	// Cursor — NextCursor предыдущей страницы
+	Cursor string
+}
+
+// SearchResult — страница результатов поиска
+type SearchResult struct {
+	Events []Event `json:"events"`
+	// NextCursor пуст на последней странице
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// TimeSlot — полуинтервал времени [Start, End)
+type TimeSlot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// UserBusy — занятое время пользователя
+type UserBusy struct {
+	UserID int        `json:"user_id"`
+	Busy   []TimeSlot `json:"busy"`
+}
+
+// SlotParams — параметры поиска общего свободного времени
+type SlotParams struct {
+	UserIDs []int
+	// From и To — диапазон поиска [From, To); рабочие часы считаются в поясе From
+	From, To time.Time
+	// Duration — длина встречи
+	Duration time.Duration
+	// WorkStart и WorkEnd — рабочие часы вида "09:00"; пустые — по умолчанию сервера (09:00–18:00)
+	WorkStart, WorkEnd string
+	// Weekends — искать и в выходные
+	Weekends bool
+	// Limit — сколько окон вернуть; 0 — по умолчанию сервера
+	Limit int
+}
+
+// APIError — ошибка, которую вернул сервер
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("calendar API: %d %s", e.StatusCode, e.Message)
+}
+
+// Client вызывает методы API календаря. Безопасен для использования из нескольких горутин.
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+// Option настраивает Client
+type Option func(*Client)
+
+// WithToken задает API-токен, который передается в заголовке Authorization
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient задает HTTP-клиент (таймауты, транспорт)
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// New создает клиент для сервера с адресом baseURL, например "http://localhost:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+	c := &Client{baseURL: u, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// CreateEvent создает событие
+func (c *Client) CreateEvent(ctx context.Context, params EventParams) (Event, error) {
+	var event Event
+	err := c.post(ctx, "/create_event", params.body(), &event)
+	return event, err
+}
+
+// UpdateEvent заменяет событие с ID id
+func (c *Client) UpdateEvent(ctx context.Context, id int, params EventParams) (Event, error) {
+	body := params.body()
+	body["id"] = id
+	var event Event
+	err := c.post(ctx, "/update_event", body, &event)
+	return event, err
+}
+
+// DeleteEvent удаляет событие пользователя
+func (c *Client) DeleteEvent(ctx context.Context, id, userID int) error {
+	return c.post(ctx, "/delete_event", map[string]interface{}{"id": id, "user_id": userID}, nil)
+}
+
+// RespondToEvent сохраняет ответ приглашенного пользователя: RSVPAccepted, RSVPDeclined или RSVPTentative
+func (c *Client) RespondToEvent(ctx context.Context, id, userID int, status string) (Event, error) {
+	var event Event
+	err := c.post(ctx, "/rsvp_event", map[string]interface{}{"id": id, "user_id": userID, "status": status}, &event)
+	return event, err
+}
+
+// EventHistory возвращает версии события по возрастанию номера; доступна только владельцу
+func (c *Client) EventHistory(ctx context.Context, id, userID int) ([]HistoryEntry, error) {
+	query := url.Values{"id": {strconv.Itoa(id)}, "user_id": {strconv.Itoa(userID)}}
+	var history []HistoryEntry
+	err := c.get(ctx, "/event_history", query, &history)
+	return history, err
+}
+
+// RevertEvent возвращает событию состояние после версии version; удаленное событие восстанавливается
+func (c *Client) RevertEvent(ctx context.Context, id, userID, version int) (Event, error) {
+	var event Event
+	err := c.post(ctx, "/revert_event", map[string]interface{}{"id": id, "user_id": userID, "version": version}, &event)
+	return event, err
+}
+
+// EventsForDay возвращает повторения событий пользователя за день, в который попадает date.
+// Границы дня считаются в часовом поясе date, для time.Local — в поясе сервера.
+func (c *Client) EventsForDay(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_day", userID, date)
+}
+
+// EventsForWeek возвращает повторения событий пользователя за неделю (с понедельника), в которую попадает date
+func (c *Client) EventsForWeek(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_week", userID, date)
+}
+
+// EventsForMonth возвращает повторения событий пользователя за месяц, в который попадает date
+func (c *Client) EventsForMonth(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_month", userID, date)
+}
+
+// SearchEvents ищет события пользователя; серия повторений возвращается одним событием
+func (c *Client) SearchEvents(ctx context.Context, params SearchParams) (SearchResult, error) {
+	query := url.Values{"user_id": {strconv.Itoa(params.UserID)}}
+	if params.Text != "" {
+		query.Set("q", params.Text)
+	}
+	if !params.From.IsZero() {
+		query.Set("from", params.From.Format(time.RFC3339))
+	}
+	if !params.To.IsZero() {
+		query.Set("to", params.To.Format(time.RFC3339))
+	}
+	if params.Descending {
+		query.Set("sort", "-start")
+	}
+	if params.Limit > 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Cursor != "" {
+		query.Set("cursor", params.Cursor)
+	}
+	var result SearchResult
+	err := c.get(ctx, "/events/search", query, &result)
+	return result, err
+}
+
+// FreeBusy возвращает занятое время пользователей в [from, to) без заметок событий
+func (c *Client) FreeBusy(ctx context.Context, userIDs []int, from, to time.Time) ([]UserBusy, error) {
+	var busy []UserBusy
+	err := c.get(ctx, "/free_busy", rangeQuery(userIDs, from, to), &busy)
+	return busy, err
+}
+
+// FindSlots ищет рабочее время, свободное у всех пользователей
+func (c *Client) FindSlots(ctx context.Context, params SlotParams) ([]TimeSlot, error) {
+	query := rangeQuery(params.UserIDs, params.From, params.To)
+	query.Set("duration", params.Duration.String())
+	if params.WorkStart != "" {
+		query.Set("work_start", params.WorkStart)
+	}
+	if params.WorkEnd != "" {
+		query.Set("work_end", params.WorkEnd)
+	}
+	if params.Weekends {
+		query.Set("weekends", "true")
+	}
+	if params.Limit > 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	var slots []TimeSlot
+	err := c.get(ctx, "/find_slots", query, &slots)
+	return slots, err
+}
+
+// rangeQuery кодирует общие параметры запросов занятости
+func rangeQuery(userIDs []int, from, to time.Time) url.Values {
+	ids := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		ids[i] = strconv.Itoa(userID)
+	}
+	query := url.Values{
+		"user_id": {strings.Join(ids, ",")},
+		"from":    {from.Format(time.RFC3339)},
+		"to":      {to.Format(time.RFC3339)},
+	}
+	if loc := from.Location(); loc != time.Local {
+		query.Set("tz", loc.String())
+	}
+	return query
+}
+
+func (c *Client) eventsFor(ctx context.Context, path string, userID int, date time.Time) ([]Event, error) {
+	query := url.Values{
+		"user_id": {strconv.Itoa(userID)},
+		"date":    {date.Format(time.DateOnly)},
+	}
+	if loc := date.Location(); loc != time.Local {
+		query.Set("tz", loc.String())
+	}
+	var events []Event
+	err := c.get(ctx, path, query, &events)
+	return events, err
+}
+
+// body кодирует параметры события в поля тела запроса
+func (p EventParams) body() map[string]interface{} {
+	body := map[string]interface{}{"user_id": p.UserID}
+	switch {
+	case p.AllDay:
+		body["date"] = p.Start.Format(time.DateOnly)
+	case !p.Start.IsZero():
+		body["start"] = p.Start.Format(time.RFC3339Nano)
+		if !p.End.IsZero() {
+			body["end"] = p.End.Format(time.RFC3339Nano)
+		}
+	}
+	if p.TimeZone != "" {
+		body["tz"] = p.TimeZone
+	}
+	if p.Note != "" {
+		body["note"] = p.Note
+	}
+	if p.RRule != "" {
+		body["rrule"] = p.RRule
+	}
+	if len(p.ExDates) > 0 {
+		dates := make([]string, len(p.ExDates))
+		for i, d := range p.ExDates {
+			dates[i] = d.Format(time.DateOnly)
+		}
+		body["exdate"] = dates
+	}
+	if len(p.Reminders) > 0 {
+		reminders := make([]string, len(p.Reminders))
+		for i, d := range p.Reminders {
+			reminders[i] = d.String()
+		}
+		body["reminders"] = reminders
+	}
+	if len(p.Attendees) > 0 {
+		body["attendees"] = p.Attendees
+	}
+	return body
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, result)
+}
+
+func (c *Client) post(ctx context.Context, path string, body interface{}, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL.JoinPath(path).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, result)
+}
+
+// do выполняет запрос и разбирает ответ {"result": ...} в result (nil — ответ не нужен)
+func (c *Client) do(req *http.Request, result interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var envelope struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &envelope) != nil || envelope.Error == "" {
+			envelope.Error = strings.TrimSpace(string(data))
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: envelope.Error}
+	}
+	if result == nil {
+		return nil
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if len(envelope.Result) == 0 {
+		return errors.New("decode response: result is missing")
+	}
+	if err := json.Unmarshal(envelope.Result, result); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
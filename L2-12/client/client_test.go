@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// recorded — запрос, который получил тестовый сервер
+type recorded struct {
+	method, path, auth, accept, contentType string
+	query                                   url.Values
+	body                                    map[string]interface{}
+}
+
+// newTestServer отвечает на любой запрос status и телом body и запоминает последний запрос
+func newTestServer(t *testing.T, status int, body string) (*Client, *recorded) {
+	t.Helper()
+	last := &recorded{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = recorded{method: r.Method, path: r.URL.Path, auth: r.Header.Get("Authorization"),
+			accept: r.Header.Get("Accept"), contentType: r.Header.Get("Content-Type"), query: r.URL.Query()}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &last.body); err != nil {
+				t.Errorf("Тело запроса должно быть JSON: %s", data)
+			}
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, WithToken("cal_secret"), WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при создании клиента: %v", err)
+	}
+	return c, last
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:8080", "ftp://example.com", "http://"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("Адрес %q должен быть отклонен", baseURL)
+		}
+	}
+}
+
+func TestCreateEvent(t *testing.T) {
+	c, last := newTestServer(t, http.StatusOK,
+		`{"result":{"id":3,"user_id":1,"start":"2024-03-01T09:00:00+03:00","end":"2024-03-01T10:00:00+03:00","time_zone":"Europe/Moscow","note":"standup","reminders":["15m0s"]}}`)
+	moscow := time.FixedZone("MSK", 3*60*60)
+	event, err := c.CreateEvent(context.Background(), EventParams{UserID: 1, Start: time.Date(2024, 3, 1, 9, 0, 0, 0, moscow),
+		End: time.Date(2024, 3, 1, 10, 0, 0, 0, moscow), TimeZone: "Europe/Moscow", Note: "standup",
+		RRule: "FREQ=WEEKLY", Reminders: []time.Duration{15 * time.Minute}})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if event.ID != 3 || event.Note != "standup" || len(event.Reminders) != 1 || time.Duration(event.Reminders[0]) != 15*time.Minute {
+		t.Errorf("Неожиданное событие %+v", event)
+	}
+
+	if last.method != http.MethodPost || last.path != "/create_event" || last.auth != "Bearer cal_secret" ||
+		last.accept != "application/json" || last.contentType != "application/json" {
+		t.Errorf("Неожиданный запрос %+v", last)
+	}
+	want := map[string]interface{}{"user_id": float64(1), "start": "2024-03-01T09:00:00+03:00",
+		"end": "2024-03-01T10:00:00+03:00", "tz": "Europe/Moscow", "note": "standup", "rrule": "FREQ=WEEKLY"}
+	for field, value := range want {
+		if last.body[field] != value {
+			t.Errorf("Поле %s: ожидается %v, получено %v", field, value, last.body[field])
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	c, last := newTestServer(t, http.StatusOK, `{"result":[]}`)
+	ctx := context.Background()
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Нет базы часовых поясов: %v", err)
+	}
+
+	if _, err := c.EventsForWeek(ctx, 4, time.Date(2024, 3, 10, 0, 0, 0, 0, newYork)); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if last.method != http.MethodGet || last.path != "/events_for_week" || last.query.Get("user_id") != "4" ||
+		last.query.Get("date") != "2024-03-10" || last.query.Get("tz") != "America/New_York" {
+		t.Errorf("Неожиданный запрос %+v", last)
+	}
+
+	// В поясе time.Local дата разбирается в поясе сервера, tz не передается
+	if _, err := c.EventsForDay(ctx, 4, time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if _, ok := last.query["tz"]; ok {
+		t.Errorf("Для time.Local пояс не передается, получено %v", last.query)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+		api     bool
+	}{
+		{"json envelope", http.StatusServiceUnavailable, `{"error":"event not found"}`, "calendar API: 503 event not found", true},
+		{"plain text", http.StatusBadGateway, "bad gateway\n", "calendar API: 502 bad gateway", true},
+		{"missing result", http.StatusOK, `{"status":"ok"}`, "decode response: result is missing", false},
+		{"broken json", http.StatusOK, `{"result":`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestServer(t, tt.status, tt.body)
+			_, err := c.EventsForMonth(context.Background(), 1, time.Now())
+			if err == nil {
+				t.Fatal("Ожидается ошибка")
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) != tt.api || (apiErr != nil && apiErr.StatusCode != tt.status) {
+				t.Errorf("Неожиданный тип ошибки %T: %v", err, err)
+			}
+			if tt.wantErr != "" && err.Error() != tt.wantErr {
+				t.Errorf("Ожидается %q, получено %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+
+	// Ответ без результата для DeleteEvent не разбирается
+	c, last := newTestServer(t, http.StatusOK, `{"result":"event deleted"}`)
+	if err := c.DeleteEvent(context.Background(), 3, 1); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if last.path != "/delete_event" || last.body["id"] != float64(3) || last.body["user_id"] != float64(1) {
+		t.Errorf("Неожиданный запрос %+v", last)
+	}
+}
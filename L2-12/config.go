@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config — настройки сервера.
+// Значения применяются по возрастанию приоритета: умолчания, конфигурационный файл,
+// переменные окружения CALENDAR_*, флаги командной строки.
+type Config struct {
+	// Addr — адрес, на котором слушает сервер, например ":8080"
+	Addr string `json:"addr"`
+	// TimeZone — часовой пояс IANA, в котором интерпретируются даты запросов
+	TimeZone string `json:"time_zone"`
+	// ConflictPolicy — что делать с пересекающимися событиями: ignore, flag или reject
+	ConflictPolicy string         `json:"conflict_policy"`
+	Log            LogConfig      `json:"log"`
+	Timeouts       TimeoutsConfig `json:"timeouts"`
+	// MaxHeaderBytes — максимальный размер заголовков запроса
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// MaxBodyBytes — максимальный размер тела POST-запроса
+	MaxBodyBytes int             `json:"max_body_bytes"`
+	Storage      StorageConfig   `json:"storage"`
+	Auth         AuthConfig      `json:"auth"`
+	RateLimit    RateLimitConfig `json:"rate_limit"`
+	Reminders    RemindersConfig `json:"reminders"`
+	Webhooks     WebhooksConfig  `json:"webhooks"`
+	Stream       StreamConfig    `json:"stream"`
+}
+
+// LogConfig описывает формат логов
+type LogConfig struct {
+	// Format — text или json
+	Format string `json:"format"`
+}
+
+// TimeoutsConfig описывает таймауты HTTP-сервера
+type TimeoutsConfig struct {
+	Read       Duration `json:"read"`
+	ReadHeader Duration `json:"read_header"`
+	Write      Duration `json:"write"`
+	Idle       Duration `json:"idle"`
+	// Shutdown — сколько ждать завершения текущих запросов при остановке
+	Shutdown Duration `json:"shutdown"`
+	// ShutdownDelay — сколько после сигнала остановки отвечать «не готов» на /readyz,
+	// продолжая обслуживать запросы, прежде чем закрыть соединения
+	ShutdownDelay Duration `json:"shutdown_delay"`
+}
+
+// StorageConfig описывает хранилище событий
+type StorageConfig struct {
+	// Type — memory, file или sqlite
+	Type string `json:"type"`
+	// Path — каталог для file или файл базы для sqlite
+	Path string `json:"path"`
+	// CompactInterval — период уплотнения журнала для file
+	CompactInterval Duration `json:"compact_interval"`
+}
+
+// AuthConfig описывает аутентификацию по API-токенам
+type AuthConfig struct {
+	// Enabled включает обязательную аутентификацию всех методов API
+	Enabled bool `json:"enabled"`
+	// TokensFile — JSON-файл с хэшами выданных токенов
+	TokensFile string `json:"tokens_file"`
+}
+
+// RemindersConfig описывает планировщик напоминаний
+type RemindersConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interval — как часто планировщик проверяет наступившие напоминания
+	Interval Duration `json:"interval"`
+	// Notifier — log или webhook
+	Notifier string `json:"notifier"`
+	// WebhookURL — адрес, на который notifier webhook отправляет напоминания
+	WebhookURL     string   `json:"webhook_url"`
+	WebhookTimeout Duration `json:"webhook_timeout"`
+}
+
+// WebhooksConfig описывает исходящие вебхуки об изменениях событий
+type WebhooksConfig struct {
+	Enabled bool `json:"enabled"`
+	// File — JSON-файл с подписками и их секретами
+	File string `json:"file"`
+	// Workers — число одновременных доставок
+	Workers int `json:"workers"`
+	// QueueSize — сколько доставок может ждать отправки
+	QueueSize int      `json:"queue_size"`
+	Timeout   Duration `json:"timeout"`
+	// MaxAttempts — число попыток доставки, включая первую
+	MaxAttempts int `json:"max_attempts"`
+	// InitialBackoff — задержка перед первым повтором, дальше удваивается до MaxBackoff
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	// LogSize — сколько последних доставок хранит журнал
+	LogSize int `json:"log_size"`
+	// AllowedNetworks — доверенные внутренние сети (CIDR или IP), куда можно отправлять вебхуки.
+	// Остальные loopback, частные, link-local и неуказанные адреса запрещены.
+	AllowedNetworks []string `json:"allowed_networks"`
+}
+
+// StreamConfig описывает поток изменений /events/stream
+type StreamConfig struct {
+	Enabled bool `json:"enabled"`
+	// ChangeLogSize — сколько последних изменений хранится для продолжения потока по Last-Event-ID
+	ChangeLogSize int `json:"change_log_size"`
+	// Heartbeat — период пустых сообщений, не дающих прокси закрыть простаивающее соединение
+	Heartbeat Duration `json:"heartbeat"`
+}
+
+// RateLimitConfig описывает ограничение частоты запросов одного клиента
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// Read — лимит для GET и HEAD
+	Read RateLimit `json:"read"`
+	// Write — лимит для остальных методов
+	Write RateLimit `json:"write"`
+	// Address — общий лимит всех запросов с одного IP-адреса. Проверяется до аутентификации,
+	// поэтому ограничивает и запросы с неверными токенами.
+	Address RateLimit `json:"address"`
+}
+
+// RateLimit — параметры ведра токенов: средняя скорость и допустимый всплеск
+type RateLimit struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// Duration — time.Duration, который в JSON записывается строкой вида "1m30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m30s\"")
+	}
+	return d.Set(s)
+}
+
+// Set разбирает длительность из строки вида "1m30s"
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// defaultConfig возвращает настройки, используемые без конфигурационного файла
+func defaultConfig() Config {
+	return Config{
+		Addr:           ":8080",
+		TimeZone:       "UTC",
+		ConflictPolicy: ConflictFlag,
+		Log:            LogConfig{Format: "text"},
+		Timeouts: TimeoutsConfig{
+			Read:          Duration(10 * time.Second),
+			ReadHeader:    Duration(5 * time.Second),
+			Write:         Duration(10 * time.Second),
+			Idle:          Duration(time.Minute),
+			Shutdown:      Duration(15 * time.Second),
+			ShutdownDelay: Duration(5 * time.Second),
+		},
+		MaxHeaderBytes: 64 << 10,
+		MaxBodyBytes:   defaultMaxBodyBytes,
+		Storage: StorageConfig{
+			Type:            "memory",
+			Path:            "data",
+			CompactInterval: Duration(time.Minute),
+		},
+		Auth: AuthConfig{TokensFile: "data/tokens.json"},
+		Reminders: RemindersConfig{
+			Enabled:        true,
+			Interval:       Duration(15 * time.Second),
+			Notifier:       "log",
+			WebhookTimeout: Duration(5 * time.Second),
+		},
+		Webhooks: WebhooksConfig{
+			Enabled:        true,
+			File:           "data/webhooks.json",
+			Workers:        4,
+			QueueSize:      1000,
+			Timeout:        Duration(5 * time.Second),
+			MaxAttempts:    5,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(time.Minute),
+			LogSize:        1000,
+		},
+		Stream: StreamConfig{
+			Enabled:       true,
+			ChangeLogSize: 1000,
+			Heartbeat:     Duration(15 * time.Second),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Read:    RateLimit{RPS: 50, Burst: 100},
+			Write:   RateLimit{RPS: 10, Burst: 20},
+			Address: RateLimit{RPS: 100, Burst: 200},
+		},
+	}
+}
+
+// configOverride описывает настройку, которую можно переопределить
+// переменной окружения и флагом командной строки
+type configOverride struct {
+	flag  string
+	env   string
+	usage string
+	set   setter
+}
+
+// setter разбирает строковое значение настройки и записывает его в конфигурацию
+type setter struct {
+	apply func(cfg *Config, value string) error
+	// isBool разрешает писать флаг без значения: -auth вместо -auth=true
+	isBool bool
+}
+
+var configOverrides = []configOverride{
+	{"addr", "CALENDAR_ADDR", "Listen address, e.g. :8080", setString(func(c *Config) *string { return &c.Addr })},
+	{"tz", "CALENDAR_TIME_ZONE", "IANA time zone for request dates", setString(func(c *Config) *string { return &c.TimeZone })},
+	{"conflict-policy", "CALENDAR_CONFLICT_POLICY", "Overlapping events policy: ignore, flag or reject", setString(func(c *Config) *string { return &c.ConflictPolicy })},
+	{"log-format", "CALENDAR_LOG_FORMAT", "Log format: text or json", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"storage", "CALENDAR_STORAGE", "Storage backend: memory, file or sqlite", setString(func(c *Config) *string { return &c.Storage.Type })},
+	{"data-path", "CALENDAR_DATA_PATH", "Data directory for file storage or database file for sqlite", setString(func(c *Config) *string { return &c.Storage.Path })},
+	{"compact-interval", "CALENDAR_COMPACT_INTERVAL", "How often the file storage journal is compacted", setDuration(func(c *Config) *Duration { return &c.Storage.CompactInterval })},
+	{"read-timeout", "CALENDAR_READ_TIMEOUT", "HTTP read timeout", setDuration(func(c *Config) *Duration { return &c.Timeouts.Read })},
+	{"read-header-timeout", "CALENDAR_READ_HEADER_TIMEOUT", "HTTP request headers read timeout", setDuration(func(c *Config) *Duration { return &c.Timeouts.ReadHeader })},
+	{"write-timeout", "CALENDAR_WRITE_TIMEOUT", "HTTP write timeout", setDuration(func(c *Config) *Duration { return &c.Timeouts.Write })},
+	{"idle-timeout", "CALENDAR_IDLE_TIMEOUT", "HTTP keep-alive idle timeout", setDuration(func(c *Config) *Duration { return &c.Timeouts.Idle })},
+	{"shutdown-timeout", "CALENDAR_SHUTDOWN_TIMEOUT", "How long to drain in-flight requests on shutdown", setDuration(func(c *Config) *Duration { return &c.Timeouts.Shutdown })},
+	{"shutdown-delay", "CALENDAR_SHUTDOWN_DELAY", "How long to report not ready before closing connections on shutdown", setDuration(func(c *Config) *Duration { return &c.Timeouts.ShutdownDelay })},
+	{"max-header-bytes", "CALENDAR_MAX_HEADER_BYTES", "Maximum size of request headers in bytes", setInt(func(c *Config) *int { return &c.MaxHeaderBytes })},
+	{"auth", "CALENDAR_AUTH", "Require bearer tokens for API requests (true or false)", setBool(func(c *Config) *bool { return &c.Auth.Enabled })},
+	{"tokens-file", "CALENDAR_TOKENS_FILE", "JSON file with hashed API tokens", setString(func(c *Config) *string { return &c.Auth.TokensFile })},
+	{"rate-limit", "CALENDAR_RATE_LIMIT", "Limit request rate per client (true or false)", setBool(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"read-rps", "CALENDAR_READ_RPS", "Allowed GET requests per second per client", setFloat(func(c *Config) *float64 { return &c.RateLimit.Read.RPS })},
+	{"read-burst", "CALENDAR_READ_BURST", "Allowed burst of GET requests per client", setInt(func(c *Config) *int { return &c.RateLimit.Read.Burst })},
+	{"write-rps", "CALENDAR_WRITE_RPS", "Allowed POST requests per second per client", setFloat(func(c *Config) *float64 { return &c.RateLimit.Write.RPS })},
+	{"write-burst", "CALENDAR_WRITE_BURST", "Allowed burst of POST requests per client", setInt(func(c *Config) *int { return &c.RateLimit.Write.Burst })},
+	{"address-rps", "CALENDAR_ADDRESS_RPS", "Allowed requests per second per IP address", setFloat(func(c *Config) *float64 { return &c.RateLimit.Address.RPS })},
+	{"address-burst", "CALENDAR_ADDRESS_BURST", "Allowed burst of requests per IP address", setInt(func(c *Config) *int { return &c.RateLimit.Address.Burst })},
+	{"reminders", "CALENDAR_REMINDERS", "Run the reminder scheduler (true or false)", setBool(func(c *Config) *bool { return &c.Reminders.Enabled })},
+	{"reminder-interval", "CALENDAR_REMINDER_INTERVAL", "How often due reminders are checked", setDuration(func(c *Config) *Duration { return &c.Reminders.Interval })},
+	{"reminder-notifier", "CALENDAR_REMINDER_NOTIFIER", "Reminder delivery: log or webhook", setString(func(c *Config) *string { return &c.Reminders.Notifier })},
+	{"reminder-webhook-url", "CALENDAR_REMINDER_WEBHOOK_URL", "URL that receives reminders as JSON POST requests", setString(func(c *Config) *string { return &c.Reminders.WebhookURL })},
+	{"webhooks", "CALENDAR_WEBHOOKS", "Send webhooks on event changes (true or false)", setBool(func(c *Config) *bool { return &c.Webhooks.Enabled })},
+	{"webhooks-file", "CALENDAR_WEBHOOKS_FILE", "JSON file with webhook subscriptions", setString(func(c *Config) *string { return &c.Webhooks.File })},
+	{"webhook-max-attempts", "CALENDAR_WEBHOOK_MAX_ATTEMPTS", "Delivery attempts per webhook, including the first one", setInt(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{"webhook-initial-backoff", "CALENDAR_WEBHOOK_INITIAL_BACKOFF", "Delay before the first webhook retry, doubled after each failure", setDuration(func(c *Config) *Duration { return &c.Webhooks.InitialBackoff })},
+	{"webhook-max-backoff", "CALENDAR_WEBHOOK_MAX_BACKOFF", "Upper bound for the delay between webhook retries", setDuration(func(c *Config) *Duration { return &c.Webhooks.MaxBackoff })},
+	{"webhook-allowed-networks", "CALENDAR_WEBHOOK_ALLOWED_NETWORKS", "Comma-separated internal networks (CIDR or IP) that webhooks may target", setList(func(c *Config) *[]string { return &c.Webhooks.AllowedNetworks })},
+	{"stream", "CALENDAR_STREAM", "Serve the /events/stream change feed (true or false)", setBool(func(c *Config) *bool { return &c.Stream.Enabled })},
+	{"change-log-size", "CALENDAR_CHANGE_LOG_SIZE", "How many recent changes are kept for stream resumption", setInt(func(c *Config) *int { return &c.Stream.ChangeLogSize })},
+	{"stream-heartbeat", "CALENDAR_STREAM_HEARTBEAT", "Interval of keep-alive comments in the change stream", setDuration(func(c *Config) *Duration { return &c.Stream.Heartbeat })},
+	{"max-body-bytes", "CALENDAR_MAX_BODY_BYTES", "Maximum size of POST request bodies in bytes", setInt(func(c *Config) *int { return &c.MaxBodyBytes })},
+}
+
+// overrideFlag — значение флага настройки; применяется к конфигурации после файла и окружения
+type overrideFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *overrideFlag) String() string     { return f.value }
+func (f *overrideFlag) Set(s string) error { f.value = s; return nil }
+func (f *overrideFlag) IsBoolFlag() bool   { return f.isBool }
+
+func setString(field func(*Config) *string) setter {
+	return setter{apply: func(cfg *Config, value string) error {
+		*field(cfg) = value
+		return nil
+	}}
+}
+
+func setInt(field func(*Config) *int) setter {
+	return setter{apply: func(cfg *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = n
+		return nil
+	}}
+}
+
+func setFloat(field func(*Config) *float64) setter {
+	return setter{apply: func(cfg *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = f
+		return nil
+	}}
+}
+
+func setBool(field func(*Config) *bool) setter {
+	return setter{isBool: true, apply: func(cfg *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = b
+		return nil
+	}}
+}
+
+// setList разбирает список через запятую; пустая строка очищает список
+func setList(field func(*Config) *[]string) setter {
+	return setter{apply: func(cfg *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(cfg) = list
+		return nil
+	}}
+}
+
+func setDuration(field func(*Config) *Duration) setter {
+	return setter{apply: func(cfg *Config, value string) error {
+		return field(cfg).Set(value)
+	}}
+}
+
+// loadConfig собирает конфигурацию из файла, окружения и аргументов командной строки
+func loadConfig(args []string) (Config, error) {
+	return loadConfigFlags(flag.NewFlagSet("calendar", flag.ContinueOnError), args)
+}
+
+// loadConfigFlags — то же, что loadConfig, но флаги настроек добавляются к уже
+// объявленным в fs флагам подкоманды
+func loadConfigFlags(fs *flag.FlagSet, args []string) (Config, error) {
+	configPath := fs.String("config", os.Getenv("CALENDAR_CONFIG"), "Path to the JSON config file")
+	flagValues := make(map[string]*overrideFlag, len(configOverrides))
+	for _, o := range configOverrides {
+		flagValues[o.flag] = &overrideFlag{isBool: o.set.isBool}
+		fs.Var(flagValues[o.flag], o.flag, o.usage+" (env "+o.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := readConfigFile(*configPath, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, o := range configOverrides {
+		if value, ok := os.LookupEnv(o.env); ok {
+			if err := o.set.apply(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", o.env, err)
+			}
+		}
+	}
+
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	for _, o := range configOverrides {
+		if setFlags[o.flag] {
+			if err := o.set.apply(&cfg, flagValues[o.flag].value); err != nil {
+				return Config{}, fmt.Errorf("-%s: %w", o.flag, err)
+			}
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// readConfigFile читает JSON-файл поверх уже заполненной конфигурации
+func readConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки разом
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q: %w", c.Addr, err))
+	}
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("time_zone %q: %w", c.TimeZone, err))
+	}
+	switch c.ConflictPolicy {
+	case ConflictIgnore, ConflictFlag, ConflictReject:
+	default:
+		errs = append(errs, fmt.Errorf("conflict_policy %q: must be ignore, flag or reject", c.ConflictPolicy))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format %q: must be text or json", c.Log.Format))
+	}
+	if c.Timeouts.Read < 0 || c.Timeouts.ReadHeader < 0 || c.Timeouts.Write < 0 || c.Timeouts.Idle < 0 || c.Timeouts.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("timeouts: must not be negative"))
+	}
+	if c.Timeouts.Shutdown <= 0 {
+		errs = append(errs, errors.New("timeouts.shutdown: must be positive"))
+	}
+	if c.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("max_header_bytes: must be positive"))
+	}
+	if c.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("max_body_bytes: must be positive"))
+	}
+	switch c.Storage.Type {
+	case "memory":
+	case "file", "sqlite":
+		if c.Storage.Path == "" {
+			errs = append(errs, fmt.Errorf("storage.path: required for %s storage", c.Storage.Type))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.type %q: must be memory, file or sqlite", c.Storage.Type))
+	}
+	if c.Storage.CompactInterval < 0 {
+		errs = append(errs, errors.New("storage.compact_interval: must not be negative"))
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.Read.RPS <= 0 || c.RateLimit.Read.Burst < 1 {
+			errs = append(errs, errors.New("rate_limit.read: rps must be positive and burst at least 1"))
+		}
+		if c.RateLimit.Write.RPS <= 0 || c.RateLimit.Write.Burst < 1 {
+			errs = append(errs, errors.New("rate_limit.write: rps must be positive and burst at least 1"))
+		}
+		if c.RateLimit.Address.RPS <= 0 || c.RateLimit.Address.Burst < 1 {
+			errs = append(errs, errors.New("rate_limit.address: rps must be positive and burst at least 1"))
+		}
+	}
+	if c.Reminders.Enabled {
+		if c.Reminders.Interval <= 0 {
+			errs = append(errs, errors.New("reminders.interval: must be positive"))
+		}
+		switch c.Reminders.Notifier {
+		case "log":
+		case "webhook":
+			if !validWebhookURL(c.Reminders.WebhookURL) {
+				errs = append(errs, fmt.Errorf("reminders.webhook_url %q: must be an http(s) URL", c.Reminders.WebhookURL))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("reminders.notifier %q: must be log or webhook", c.Reminders.Notifier))
+		}
+	}
+	if c.Webhooks.Enabled {
+		if c.Webhooks.File == "" {
+			errs = append(errs, errors.New("webhooks.file: required when webhooks are enabled"))
+		}
+		if c.Webhooks.Workers <= 0 {
+			errs = append(errs, errors.New("webhooks.workers: must be positive"))
+		}
+		if c.Webhooks.QueueSize <= 0 {
+			errs = append(errs, errors.New("webhooks.queue_size: must be positive"))
+		}
+		if c.Webhooks.Timeout <= 0 {
+			errs = append(errs, errors.New("webhooks.timeout: must be positive"))
+		}
+		if c.Webhooks.MaxAttempts <= 0 {
+			errs = append(errs, errors.New("webhooks.max_attempts: must be positive"))
+		}
+		if c.Webhooks.InitialBackoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.InitialBackoff {
+			errs = append(errs, errors.New("webhooks: initial_backoff must be positive and not greater than max_backoff"))
+		}
+		if c.Webhooks.LogSize <= 0 {
+			errs = append(errs, errors.New("webhooks.log_size: must be positive"))
+		}
+		if _, err := parseAllowedNetworks(c.Webhooks.AllowedNetworks); err != nil {
+			errs = append(errs, fmt.Errorf("webhooks.allowed_networks: %w", err))
+		}
+	}
+	if c.Stream.Enabled {
+		if c.Stream.ChangeLogSize <= 0 {
+			errs = append(errs, errors.New("stream.change_log_size: must be positive"))
+		}
+		if c.Stream.Heartbeat <= 0 {
+			errs = append(errs, errors.New("stream.heartbeat: must be positive"))
+		}
+	}
+	if c.Auth.Enabled && c.Auth.TokensFile == "" {
+		errs = append(errs, errors.New("auth.tokens_file: required when auth is enabled"))
+	}
+	return errors.Join(errs...)
+}
+
+// Location возвращает часовой пояс из конфигурации (конфигурация должна быть проверена)
+func (c Config) Location() *time.Location {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile записывает конфигурационный файл во временный каталог теста
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"addr": ":9000",
+		"time_zone": "Europe/Moscow",
+		"log": {"format": "json"},
+		"timeouts": {"read": "20s", "read_header": "2s"},
+		"webhooks": {"max_backoff": "2m"}
+	}`)
+	t.Setenv("CALENDAR_CONFIG", path)
+	t.Setenv("CALENDAR_TIME_ZONE", "Asia/Tokyo")
+	t.Setenv("CALENDAR_LOG_FORMAT", "text")
+	t.Setenv("CALENDAR_READ_HEADER_TIMEOUT", "3s")
+	t.Setenv("CALENDAR_WEBHOOK_MAX_BACKOFF", "3m")
+
+	cfg, err := loadConfig([]string{"-tz", "UTC", "-webhook-max-backoff", "4m", "-auth"})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"умолчание", cfg.Timeouts.Write, Duration(10 * time.Second)},
+		{"файл", cfg.Addr, ":9000"},
+		{"файл", cfg.Timeouts.Read, Duration(20 * time.Second)},
+		{"окружение поверх файла", cfg.Log.Format, "text"},
+		{"окружение поверх файла", cfg.Timeouts.ReadHeader, Duration(3 * time.Second)},
+		{"флаг поверх окружения", cfg.TimeZone, "UTC"},
+		{"флаг поверх окружения", cfg.Webhooks.MaxBackoff, Duration(4 * time.Minute)},
+		{"булев флаг без значения", cfg.Auth.Enabled, true},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: ожидается %v, получено %v", tt.name, tt.want, tt.got)
+		}
+	}
+
+	// Флаг -config важнее переменной CALENDAR_CONFIG
+	other := writeConfigFile(t, `{"addr": ":9100"}`)
+	if cfg, err = loadConfig([]string{"-config", other}); err != nil || cfg.Addr != ":9100" {
+		t.Errorf("Ожидается адрес из файла флага -config, получено %q, %v", cfg.Addr, err)
+	}
+}
+
+func TestLoadConfigInvalidValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"duration in file", `{"timeouts": {"read": "10 seconds"}}`, nil, nil, "parse config"},
+		{"duration as number", `{"timeouts": {"read": 10}}`, nil, nil, `duration must be a string like "1m30s"`},
+		{"unknown field", `{"adress": ":8080"}`, nil, nil, `unknown field "adress"`},
+		{"duration in env", "", map[string]string{"CALENDAR_WEBHOOK_MAX_BACKOFF": "soon"}, nil, "CALENDAR_WEBHOOK_MAX_BACKOFF"},
+		{"bool in env", "", map[string]string{"CALENDAR_AUTH": "yes"}, nil, "CALENDAR_AUTH"},
+		{"int in env", "", map[string]string{"CALENDAR_MAX_BODY_BYTES": "1MB"}, nil, "CALENDAR_MAX_BODY_BYTES"},
+		{"duration in flag", "", nil, []string{"-read-header-timeout", "5"}, "-read-header-timeout"},
+		{"bool in flag", "", nil, []string{"-stream=maybe"}, "-stream"},
+		{"unknown flag", "", nil, []string{"-verbose"}, "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CALENDAR_CONFIG", "")
+			if tt.file != "" {
+				t.Setenv("CALENDAR_CONFIG", writeConfigFile(t, tt.file))
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := loadConfig(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Ожидается ошибка с %q, получено %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateCollectsAllErrors(t *testing.T) {
+	if err := defaultConfig().Validate(); err != nil {
+		t.Fatalf("Настройки по умолчанию должны проходить проверку, получено %v", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Addr = "8080"
+	cfg.TimeZone = "Mars/Olympus"
+	cfg.Storage.Type = "sqlite"
+	cfg.Storage.Path = ""
+	cfg.Webhooks.MaxBackoff = Duration(time.Millisecond)
+	cfg.Timeouts.ReadHeader = Duration(-time.Second)
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Ожидается ошибка проверки")
+	}
+	// Все ошибки возвращаются разом, по одной на строку
+	want := []string{"addr \"8080\"", "time_zone \"Mars/Olympus\"", "timeouts: must not be negative",
+		"storage.path: required for sqlite storage", "webhooks: initial_backoff must be positive and not greater than max_backoff"}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != len(want) {
+		t.Errorf("Ожидается %d ошибок, получено %d: %v", len(want), len(lines), err)
+	}
+	for _, fragment := range want {
+		if !strings.Contains(err.Error(), fragment) {
+			t.Errorf("Ошибка должна содержать %q, получено %v", fragment, err)
+		}
+	}
+}
@@ -0,0 +1,1609 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata" // часовые пояса в тестах не зависят от базы tzdata системы
+
+	"github.com/ZnNr/wb-l2/L2-12/client"
+)
+
+// Сквозные тесты HTTP API: сервер собирается так же, как в run, и поднимается на httptest.Server.
+// Сеть наружу не нужна: вебхуки только ставятся в очередь, хранилище — в памяти.
+
+// Роли, от имени которых тест отправляет запрос
+const (
+	asAdmin     = ""          // токен администратора (по умолчанию)
+	asUser      = "user"      // токен пользователя 1
+	asAnonymous = "anonymous" // без токена
+)
+
+// testEnv — запущенный сервер с токенами администратора и пользователя 1
+type testEnv struct {
+	*httptest.Server
+	srv        *server
+	adminToken string
+	userToken  string
+}
+
+// newTestEnv поднимает сервер над repo (nil — хранилище в памяти) со всеми включенными
+// возможностями, кроме ограничения частоты запросов
+func newTestEnv(t *testing.T, repo EventRepository) *testEnv {
+	t.Helper()
+	if repo == nil {
+		repo = NewMemoryRepository()
+	}
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.RateLimit.Enabled = false
+	cfg.Auth.Enabled = true
+	cfg.Auth.TokensFile = filepath.Join(dir, "tokens.json")
+	cfg.Webhooks.File = filepath.Join(dir, "webhooks.json")
+	cfg.Stream.Heartbeat = Duration(time.Second)
+
+	srv, err := buildServer(cfg, repo)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при сборке сервера: %v", err)
+	}
+	env := &testEnv{srv: srv}
+	if env.adminToken, _, err = srv.tokens.Issue(1, true); err != nil {
+		t.Fatalf("Неожиданная ошибка при выдаче токена: %v", err)
+	}
+	if env.userToken, _, err = srv.tokens.Issue(1, false); err != nil {
+		t.Fatalf("Неожиданная ошибка при выдаче токена: %v", err)
+	}
+	env.Server = httptest.NewServer(srv.routes())
+	t.Cleanup(func() {
+		srv.changes.Close()
+		env.Close()
+		repo.Close()
+	})
+	return env
+}
+
+// seed создает событие пользователя 1 (ID 1) с приглашенным пользователем 2 и подписку на вебхуки
+func (env *testEnv) seed(t *testing.T) {
+	t.Helper()
+	start := time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC)
+	event := Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "planning", Attendees: []Attendee{{UserID: 2}}}
+	if _, err := env.srv.service.CreateEvent(event); err != nil {
+		t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+	}
+	if _, err := env.srv.webhooks.Subscribe(1, "https://hooks.example.com/calendar"); err != nil {
+		t.Fatalf("Неожиданная ошибка при подписке: %v", err)
+	}
+}
+
+// testRequest — запрос к серверу. Тело задается одним из полей form, json или body.
+type testRequest struct {
+	method      string
+	path        string
+	query       url.Values
+	form        url.Values
+	json        string
+	body        string
+	contentType string
+	accept      string
+	as          string
+}
+
+// testResponse — прочитанный ответ сервера
+type testResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func (env *testEnv) do(t *testing.T, req testRequest) testResponse {
+	t.Helper()
+	method := req.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	contentType := req.contentType
+	switch {
+	case req.form != nil:
+		body, contentType = strings.NewReader(req.form.Encode()), contentTypeForm
+	case req.json != "":
+		body, contentType = strings.NewReader(req.json), contentTypeJSON
+	case req.body != "":
+		body = strings.NewReader(req.body)
+	}
+	u := env.URL + req.path
+	if req.query != nil {
+		u += "?" + req.query.Encode()
+	}
+
+	httpReq, err := http.NewRequest(method, u, body)
+	if err != nil {
+		t.Fatalf("Неверный запрос: %v", err)
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if req.accept != "" {
+		httpReq.Header.Set("Accept", req.accept)
+	}
+	switch req.as {
+	case asAdmin:
+		httpReq.Header.Set("Authorization", "Bearer "+env.adminToken)
+	case asUser:
+		httpReq.Header.Set("Authorization", "Bearer "+env.userToken)
+	}
+
+	resp, err := env.Client().Do(httpReq)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, req.path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: чтение ответа: %v", method, req.path, err)
+	}
+	return testResponse{status: resp.StatusCode, header: resp.Header, body: string(data)}
+}
+
+// result разбирает {"result": ...} из тела ответа в v
+func (resp testResponse) result(t *testing.T, v interface{}) {
+	t.Helper()
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(resp.body), &envelope); err != nil || len(envelope.Result) == 0 {
+		t.Fatalf("Ожидается ответ {\"result\": ...}, получено %d %s", resp.status, resp.body)
+	}
+	if err := json.Unmarshal(envelope.Result, v); err != nil {
+		t.Fatalf("Не удалось разобрать result: %v; тело %s", err, resp.body)
+	}
+}
+
+// specPaths возвращает пути и методы из описания API
+func specPaths(t *testing.T) map[string][]string {
+	t.Helper()
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json не разбирается: %v", err)
+	}
+	paths := make(map[string][]string, len(spec.Paths))
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			paths[path] = append(paths[path], strings.ToUpper(method))
+		}
+	}
+	return paths
+}
+
+func TestEndpoints(t *testing.T) {
+	dayQuery := url.Values{"user_id": {"1"}, "date": {"2024-02-29"}}
+	tests := []struct {
+		name     string
+		req      testRequest
+		status   int
+		contains string
+	}{
+		{"root", testRequest{path: "/"}, http.StatusOK, ""},
+
+		{"create from form", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}, "note": {"retro"}}},
+			http.StatusOK, `"note":"retro"`},
+		{"create from json", testRequest{method: http.MethodPost, path: "/create_event",
+			json: `{"user_id":1,"start":"2024-03-01T09:00:00","end":"2024-03-01T09:30:00","tz":"Europe/Moscow","rrule":"FREQ=WEEKLY;BYDAY=FR","reminders":["15m"]}`},
+			http.StatusOK, `"time_zone":"Europe/Moscow"`},
+		{"create as icalendar", testRequest{method: http.MethodPost, path: "/create_event", accept: contentTypeCalendar,
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}, "note": {"retro"}}},
+			http.StatusOK, "SUMMARY:retro"},
+		{"create without date", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}}}, http.StatusBadRequest, ""},
+		{"create with end before start", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "start": {"2024-03-01T10:00:00Z"}, "end": {"2024-03-01T09:00:00Z"}}},
+			http.StatusBadRequest, ""},
+		{"create with attendees", testRequest{method: http.MethodPost, path: "/create_event",
+			json: `{"user_id":1,"date":"2024-03-01","attendees":[2,3]}`}, http.StatusOK, `"needs_action":2`},
+		{"create with owner as attendee", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}, "attendees": {"1,2"}}}, http.StatusBadRequest, "owner"},
+		{"create with unknown field", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}, "color": {"red"}}},
+			http.StatusBadRequest, "color"},
+		{"create with invalid rrule", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}, "rrule": {"FREQ=SOMETIMES"}}},
+			http.StatusBadRequest, ""},
+		{"create with unsupported body", testRequest{method: http.MethodPost, path: "/create_event",
+			body: "user_id=1", contentType: "text/plain"}, http.StatusUnsupportedMediaType, ""},
+		{"create with unacceptable response", testRequest{method: http.MethodPost, path: "/create_event", accept: "application/xml",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}}}, http.StatusNotAcceptable, ""},
+		{"create in another user's calendar", testRequest{method: http.MethodPost, path: "/create_event", as: asUser,
+			form: url.Values{"user_id": {"2"}, "date": {"2024-03-01"}}}, http.StatusForbidden, ""},
+		{"create without token", testRequest{method: http.MethodPost, path: "/create_event", as: asAnonymous,
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}}}, http.StatusUnauthorized, ""},
+
+		{"update", testRequest{method: http.MethodPost, path: "/update_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}, "date": {"2024-03-02"}, "note": {"moved"}}},
+			http.StatusOK, `"note":"moved"`},
+		{"update without id", testRequest{method: http.MethodPost, path: "/update_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-02"}}}, http.StatusBadRequest, ""},
+		{"update missing event", testRequest{method: http.MethodPost, path: "/update_event",
+			form: url.Values{"id": {"999"}, "user_id": {"1"}, "date": {"2024-03-02"}}},
+			http.StatusServiceUnavailable, "event not found"},
+
+		// Ответ об удалении есть только в JSON, поэтому событие не удаляется
+		{"delete as calendar", testRequest{method: http.MethodPost, path: "/delete_event", accept: contentTypeCalendar,
+			form: url.Values{"id": {"1"}, "user_id": {"1"}}}, http.StatusNotAcceptable, ""},
+		{"delete", testRequest{method: http.MethodPost, path: "/delete_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}}}, http.StatusOK, "event deleted"},
+		{"delete with invalid id", testRequest{method: http.MethodPost, path: "/delete_event",
+			form: url.Values{"id": {"first"}, "user_id": {"1"}}}, http.StatusBadRequest, ""},
+		{"delete missing event", testRequest{method: http.MethodPost, path: "/delete_event",
+			json: `{"id":999,"user_id":1}`}, http.StatusServiceUnavailable, "event not found"},
+
+		{"history", testRequest{path: "/event_history", query: url.Values{"id": {"1"}, "user_id": {"1"}}},
+			http.StatusOK, `"version":1`},
+		{"history of another user's event", testRequest{path: "/event_history",
+			query: url.Values{"id": {"1"}, "user_id": {"2"}}}, http.StatusForbidden, ""},
+		{"history of missing event", testRequest{path: "/event_history",
+			query: url.Values{"id": {"999"}, "user_id": {"1"}}}, http.StatusServiceUnavailable, "event not found"},
+		{"revert", testRequest{method: http.MethodPost, path: "/revert_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}, "version": {"1"}}}, http.StatusOK, `"note":"planning"`},
+		{"revert with invalid version", testRequest{method: http.MethodPost, path: "/revert_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}, "version": {"0"}}}, http.StatusBadRequest, "version"},
+		{"revert to missing version", testRequest{method: http.MethodPost, path: "/revert_event",
+			json: `{"id":1,"user_id":1,"version":7}`}, http.StatusServiceUnavailable, "version not found"},
+
+		{"rsvp", testRequest{method: http.MethodPost, path: "/rsvp_event",
+			form: url.Values{"id": {"1"}, "user_id": {"2"}, "status": {"tentative"}}}, http.StatusOK, `"tentative":1`},
+		{"rsvp with invalid status", testRequest{method: http.MethodPost, path: "/rsvp_event",
+			form: url.Values{"id": {"1"}, "user_id": {"2"}, "status": {"maybe"}}}, http.StatusBadRequest, ""},
+		{"rsvp without invitation", testRequest{method: http.MethodPost, path: "/rsvp_event",
+			form: url.Values{"id": {"1"}, "user_id": {"3"}, "status": {"accepted"}}}, http.StatusForbidden, "not invited"},
+		{"rsvp for another user", testRequest{method: http.MethodPost, path: "/rsvp_event", as: asUser,
+			form: url.Values{"id": {"1"}, "user_id": {"2"}, "status": {"accepted"}}}, http.StatusForbidden, ""},
+		{"rsvp to missing event", testRequest{method: http.MethodPost, path: "/rsvp_event",
+			json: `{"id":999,"user_id":2,"status":"declined"}`}, http.StatusServiceUnavailable, "event not found"},
+
+		{"day", testRequest{path: "/events_for_day", query: dayQuery}, http.StatusOK, "planning"},
+		{"day of an attendee", testRequest{path: "/events_for_day", query: url.Values{"user_id": {"2"}, "date": {"2024-02-29"}}},
+			http.StatusOK, "planning"},
+		{"day as icalendar", testRequest{path: "/events_for_day", query: dayQuery, accept: contentTypeCalendar},
+			http.StatusOK, "SUMMARY:planning"},
+		{"day with invalid date", testRequest{path: "/events_for_day",
+			query: url.Values{"user_id": {"1"}, "date": {"29.02.2024"}}}, http.StatusBadRequest, ""},
+		{"day without user", testRequest{path: "/events_for_day",
+			query: url.Values{"date": {"2024-02-29"}}}, http.StatusBadRequest, ""},
+		{"day with unknown time zone", testRequest{path: "/events_for_day",
+			query: url.Values{"user_id": {"1"}, "date": {"2024-02-29"}, "tz": {"Mars/Olympus"}}}, http.StatusBadRequest, ""},
+		{"week", testRequest{path: "/events_for_week", query: dayQuery}, http.StatusOK, "planning"},
+		{"week with invalid date", testRequest{path: "/events_for_week",
+			query: url.Values{"user_id": {"1"}, "date": {"2024-13-01"}}}, http.StatusBadRequest, ""},
+		{"month", testRequest{path: "/events_for_month", query: dayQuery}, http.StatusOK, "planning"},
+		{"month of another user", testRequest{path: "/events_for_month", query: url.Values{"user_id": {"2"}, "date": {"2024-02-29"}},
+			as: asUser}, http.StatusForbidden, ""},
+
+		{"search", testRequest{path: "/events/search", query: url.Values{"user_id": {"1"}, "q": {"Planning"}}},
+			http.StatusOK, "planning"},
+		{"search with invalid sort", testRequest{path: "/events/search",
+			query: url.Values{"user_id": {"1"}, "sort": {"note"}}}, http.StatusBadRequest, ""},
+		{"search with invalid cursor", testRequest{path: "/events/search",
+			query: url.Values{"user_id": {"1"}, "cursor": {"!"}}}, http.StatusBadRequest, "cursor"},
+
+		{"free busy", testRequest{path: "/free_busy",
+			query: url.Values{"user_id": {"1,2"}, "from": {"2024-02-29"}, "to": {"2024-03-01"}}},
+			http.StatusOK, `"start":"2024-02-29T10:00:00Z"`},
+		{"free busy without range", testRequest{path: "/free_busy", query: url.Values{"user_id": {"1"}}},
+			http.StatusBadRequest, "from and to"},
+		{"free busy for too long", testRequest{path: "/free_busy",
+			query: url.Values{"user_id": {"1"}, "from": {"2024-01-01"}, "to": {"2024-06-01"}}}, http.StatusBadRequest, "62 days"},
+		{"find slots", testRequest{path: "/find_slots",
+			query: url.Values{"user_id": {"1", "2"}, "from": {"2024-02-29"}, "to": {"2024-03-01"}, "duration": {"1h"}}},
+			http.StatusOK, `"end":"2024-02-29T10:00:00Z"`},
+		{"find slots without duration", testRequest{path: "/find_slots",
+			query: url.Values{"user_id": {"1"}, "from": {"2024-02-29"}, "to": {"2024-03-01"}}}, http.StatusBadRequest, "duration"},
+		{"find slots with reversed working hours", testRequest{path: "/find_slots",
+			query: url.Values{"user_id": {"1"}, "from": {"2024-02-29"}, "to": {"2024-03-01"}, "duration": {"1h"},
+				"work_start": {"18:00"}, "work_end": {"09:00"}}}, http.StatusBadRequest, "work_start"},
+
+		// Успешное подключение к потоку проверяет TestEventStream
+		{"stream without user", testRequest{path: "/events/stream"}, http.StatusBadRequest, ""},
+		{"stream as json", testRequest{path: "/events/stream", query: url.Values{"user_id": {"1"}}, accept: contentTypeJSON},
+			http.StatusNotAcceptable, ""},
+
+		{"export", testRequest{path: "/export_ics", query: url.Values{"user_id": {"1"}}}, http.StatusOK, "SUMMARY:planning"},
+		{"export with invalid range", testRequest{path: "/export_ics",
+			query: url.Values{"user_id": {"1"}, "from": {"yesterday"}}}, http.StatusBadRequest, ""},
+		{"import", testRequest{method: http.MethodPost, path: "/import_ics", query: url.Values{"user_id": {"1"}},
+			contentType: contentTypeCalendar, body: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20240305T120000Z\r\n" +
+				"DTEND:20240305T130000Z\r\nSUMMARY:imported\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+			http.StatusOK, `"note":"imported"`},
+		{"import broken calendar", testRequest{method: http.MethodPost, path: "/import_ics", query: url.Values{"user_id": {"1"}},
+			contentType: contentTypeCalendar, body: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:broken\r\n"},
+			http.StatusBadRequest, ""},
+
+		{"create webhook", testRequest{method: http.MethodPost, path: "/create_webhook",
+			form: url.Values{"user_id": {"1"}, "url": {"https://hooks.example.com/other"}}}, http.StatusOK, `"secret"`},
+		{"create webhook with invalid url", testRequest{method: http.MethodPost, path: "/create_webhook",
+			form: url.Values{"user_id": {"1"}, "url": {"ftp://hooks.example.com"}}}, http.StatusBadRequest, ""},
+		{"delete missing webhook", testRequest{method: http.MethodPost, path: "/delete_webhook",
+			form: url.Values{"user_id": {"1"}, "id": {"missing"}}}, http.StatusServiceUnavailable, ""},
+		{"delete webhook without id", testRequest{method: http.MethodPost, path: "/delete_webhook",
+			form: url.Values{"user_id": {"1"}}}, http.StatusBadRequest, ""},
+		{"webhooks", testRequest{path: "/webhooks", query: url.Values{"user_id": {"1"}}},
+			http.StatusOK, "https://hooks.example.com/calendar"},
+		{"webhook deliveries", testRequest{path: "/webhook_deliveries", query: url.Values{"user_id": {"1"}}},
+			http.StatusOK, `"result"`},
+		{"webhook deliveries with invalid status", testRequest{path: "/webhook_deliveries",
+			query: url.Values{"user_id": {"1"}, "status": {"lost"}}}, http.StatusBadRequest, ""},
+
+		{"issue token", testRequest{method: http.MethodPost, path: "/admin/issue_token",
+			form: url.Values{"user_id": {"5"}}}, http.StatusOK, tokenPrefix},
+		{"issue token without admin", testRequest{method: http.MethodPost, path: "/admin/issue_token", as: asUser,
+			form: url.Values{"user_id": {"5"}}}, http.StatusForbidden, ""},
+		{"revoke missing token", testRequest{method: http.MethodPost, path: "/admin/revoke_token",
+			form: url.Values{"id": {"missing"}}}, http.StatusServiceUnavailable, ""},
+		{"revoke token without id", testRequest{method: http.MethodPost, path: "/admin/revoke_token",
+			form: url.Values{}}, http.StatusBadRequest, ""},
+
+		{"metrics", testRequest{path: "/metrics", as: asUser}, http.StatusOK, "# TYPE"},
+		{"metrics without token", testRequest{path: "/metrics", as: asAnonymous}, http.StatusUnauthorized, ""},
+		{"healthz", testRequest{path: "/healthz", as: asAnonymous}, http.StatusOK, "ok"},
+		{"readyz", testRequest{path: "/readyz", as: asAnonymous}, http.StatusOK, "ready"},
+		{"openapi", testRequest{path: "/openapi.json", as: asAnonymous}, http.StatusOK, `"openapi"`},
+	}
+
+	// Каждый путь из описания API должен быть покрыт хотя бы одним случаем
+	covered := make(map[string]bool)
+	for _, test := range tests {
+		covered[test.req.path] = true
+	}
+	for path := range specPaths(t) {
+		if !covered[path] {
+			t.Errorf("Путь %s из openapi.json не покрыт тестами", path)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.seed(t)
+			resp := env.do(t, test.req)
+			if resp.status != test.status {
+				t.Fatalf("Ожидается код %d, получено %d: %s", test.status, resp.status, resp.body)
+			}
+			if !strings.Contains(resp.body, test.contains) {
+				t.Errorf("Ответ должен содержать %q, получено %s", test.contains, resp.body)
+			}
+		})
+	}
+}
+
+func TestMethodRestrictions(t *testing.T) {
+	env := newTestEnv(t, nil)
+	for path, methods := range specPaths(t) {
+		if path == "/" {
+			continue // корень отвечает на любой метод
+		}
+		allowed := methods[0]
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			if method == allowed {
+				continue
+			}
+			t.Run(method+" "+path, func(t *testing.T) {
+				resp := env.do(t, testRequest{method: method, path: path})
+				if resp.status != http.StatusMethodNotAllowed {
+					t.Fatalf("Ожидается код 405, получено %d: %s", resp.status, resp.body)
+				}
+				if got := resp.header.Get("Allow"); got != allowed {
+					t.Errorf("Заголовок Allow = %q, ожидается %q", got, allowed)
+				}
+			})
+		}
+	}
+}
+
+// errStorageDown — ошибка, которую возвращает failingRepository
+var errStorageDown = errors.New("storage is down: /var/lib/calendar/events.db")
+
+// failingRepository — хранилище, в котором ломается любая операция
+type failingRepository struct {
+	EventRepository
+}
+
+func (failingRepository) Create(Event) (Event, error) { return Event{}, errStorageDown }
+func (failingRepository) Update(Event) error          { return errStorageDown }
+func (failingRepository) Delete(int) error            { return errStorageDown }
+func (failingRepository) Write([]EventWrite) ([]Event, error) {
+	return nil, errStorageDown
+}
+func (failingRepository) Get(int) (Event, error) { return Event{}, errStorageDown }
+func (failingRepository) ListByUser(int, time.Time, time.Time) ([]Event, error) {
+	return nil, errStorageDown
+}
+func (failingRepository) Search(SearchQuery) ([]Event, error) { return nil, errStorageDown }
+func (failingRepository) Count() (int, error)                 { return 0, errStorageDown }
+func (failingRepository) Ping(context.Context) error          { return errStorageDown }
+func (failingRepository) Close() error                        { return nil }
+
+func TestErrorStatusMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing bool
+		req     testRequest
+		status  int
+		message string
+	}{
+		{"validation error", false, testRequest{path: "/events_for_day", query: url.Values{"user_id": {"x"}, "date": {"2024-02-29"}}},
+			http.StatusBadRequest, "invalid user_id"},
+		{"domain error", false, testRequest{method: http.MethodPost, path: "/delete_event", form: url.Values{"id": {"7"}, "user_id": {"1"}}},
+			http.StatusServiceUnavailable, "event not found"},
+		{"storage failure on read", true, testRequest{path: "/events_for_week", query: url.Values{"user_id": {"1"}, "date": {"2024-02-29"}}},
+			http.StatusInternalServerError, "Internal Server Error"},
+		{"storage failure on write", true, testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-02-29"}}}, http.StatusInternalServerError, "Internal Server Error"},
+		{"storage failure on delete", true, testRequest{method: http.MethodPost, path: "/delete_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}}}, http.StatusInternalServerError, "Internal Server Error"},
+		{"storage failure on search", true, testRequest{path: "/events/search", query: url.Values{"user_id": {"1"}}},
+			http.StatusInternalServerError, "Internal Server Error"},
+		{"storage is not ready", true, testRequest{path: "/readyz"}, http.StatusServiceUnavailable, "storage unavailable"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var repo EventRepository
+			if test.failing {
+				repo = failingRepository{NewMemoryRepository()}
+			}
+			env := newTestEnv(t, repo)
+			resp := env.do(t, test.req)
+			if resp.status != test.status {
+				t.Fatalf("Ожидается код %d, получено %d: %s", test.status, resp.status, resp.body)
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(resp.body), &body); err != nil {
+				t.Fatalf("Ожидается JSON {\"error\": ...}, получено %s", resp.body)
+			}
+			if !strings.Contains(body.Error, test.message) {
+				t.Errorf("Ожидается ошибка с %q, получено %q", test.message, body.Error)
+			}
+			// Подробности внутренних ошибок остаются в логе сервера
+			if test.failing && strings.Contains(resp.body, "/var/lib") {
+				t.Errorf("Ответ раскрывает внутреннюю ошибку: %s", resp.body)
+			}
+		})
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	env := newTestEnv(t, nil)
+	const users, perUser = 8, 25
+	day := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ids := make(map[int]bool)
+	errs := make(chan error, users*perUser)
+	for user := 1; user <= users; user++ {
+		c, err := client.New(env.URL, client.WithToken(env.adminToken), client.WithHTTPClient(env.Client()))
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при создании клиента: %v", err)
+		}
+		for i := 0; i < perUser; i++ {
+			wg.Add(1)
+			go func(user, i int) {
+				defer wg.Done()
+				start := day.AddDate(0, 0, i).Add(time.Duration(user) * time.Hour)
+				event, err := c.CreateEvent(context.Background(), client.EventParams{
+					UserID: user, Start: start, End: start.Add(30 * time.Minute), Note: fmt.Sprintf("u%d-%d", user, i),
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if ids[event.ID] {
+					errs <- fmt.Errorf("ID %d выдан дважды", event.ID)
+				}
+				ids[event.ID] = true
+			}(user, i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	c, _ := client.New(env.URL, client.WithToken(env.adminToken), client.WithHTTPClient(env.Client()))
+	var victim client.Event
+	for user := 1; user <= users; user++ {
+		events, err := c.EventsForMonth(context.Background(), user, day)
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при получении событий: %v", err)
+		}
+		if len(events) != perUser {
+			t.Errorf("У пользователя %d ожидается %d событий, получено %d", user, perUser, len(events))
+		}
+		for _, event := range events {
+			if event.UserID != user {
+				t.Errorf("Пользователю %d вернулось чужое событие %+v", user, event)
+			}
+		}
+		if len(events) > 0 {
+			victim = events[0]
+		}
+	}
+
+	// Из одновременных удалений одного события успешно ровно одно
+	var deleted sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < cap(results); i++ {
+		deleted.Add(1)
+		go func() {
+			defer deleted.Done()
+			results <- c.DeleteEvent(context.Background(), victim.ID, victim.UserID)
+		}()
+	}
+	deleted.Wait()
+	close(results)
+	succeeded := 0
+	for err := range results {
+		var apiErr *client.APIError
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusServiceUnavailable:
+		default:
+			t.Errorf("Неожиданная ошибка при удалении: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Ожидается одно успешное удаление, получено %d", succeeded)
+	}
+}
+
+func TestPeriodBoundaries(t *testing.T) {
+	env := newTestEnv(t, nil)
+	utc := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatalf("Неверное время %s: %v", value, err)
+		}
+		return parsed
+	}
+	events := []struct {
+		note       string
+		start, end string
+	}{
+		// Неделя с понедельника 2024-12-30 по воскресенье 2025-01-05 переходит через Новый год
+		{"sun-2024-12-29", "2024-12-29T12:00:00Z", "2024-12-29T13:00:00Z"},
+		{"mon-2024-12-30", "2024-12-30T00:00:00Z", "2024-12-30T01:00:00Z"},
+		{"wed-2025-01-01", "2025-01-01T12:00:00Z", "2025-01-01T13:00:00Z"},
+		{"sun-2025-01-05", "2025-01-05T23:00:00Z", "2025-01-05T23:59:00Z"},
+		{"mon-2025-01-06", "2025-01-06T00:00:00Z", "2025-01-06T01:00:00Z"},
+		// Концы месяцев невисокосного 2023 года
+		{"jan-31-2023", "2023-01-31T23:00:00Z", "2023-01-31T23:30:00Z"},
+		{"feb-28-2023", "2023-02-28T12:00:00Z", "2023-02-28T13:00:00Z"},
+		{"mar-01-2023", "2023-03-01T00:00:00Z", "2023-03-01T01:00:00Z"},
+		{"apr-30-2023", "2023-04-30T12:00:00Z", "2023-04-30T13:00:00Z"},
+		{"may-01-2023", "2023-05-01T00:00:00Z", "2023-05-01T01:00:00Z"},
+		// Високосный 2024 год: неделя с 29 февраля заканчивается воскресеньем 3 марта
+		{"feb-29-2024", "2024-02-29T10:00:00Z", "2024-02-29T11:00:00Z"},
+		{"sun-2024-03-03", "2024-03-03T10:00:00Z", "2024-03-03T11:00:00Z"},
+		{"mon-2024-03-04", "2024-03-04T00:00:00Z", "2024-03-04T01:00:00Z"},
+		// 2024-03-11 00:30 в Нью-Йорке, сразу после перехода на летнее время
+		{"new-york-2024-03-11", "2024-03-11T04:30:00Z", "2024-03-11T05:00:00Z"},
+		// Событие через полночь с воскресенья 30 июня на понедельник 1 июля
+		{"overnight", "2024-06-30T23:00:00Z", "2024-07-01T01:00:00Z"},
+		// 2024-10-01 01:30 в Москве, но еще сентябрь в UTC
+		{"moscow-2024-10-01", "2024-09-30T22:30:00Z", "2024-09-30T23:00:00Z"},
+	}
+	for _, event := range events {
+		_, err := env.srv.service.CreateEvent(Event{UserID: 1, Start: utc(event.start), End: utc(event.end), Note: event.note})
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при создании %s: %v", event.note, err)
+		}
+	}
+
+	tests := []struct {
+		period   string
+		date     string
+		tz       string
+		status   int
+		expected []string
+	}{
+		{"week", "2025-01-01", "", http.StatusOK, []string{"mon-2024-12-30", "wed-2025-01-01", "sun-2025-01-05"}},
+		{"week", "2024-12-30", "", http.StatusOK, []string{"mon-2024-12-30", "wed-2025-01-01", "sun-2025-01-05"}},
+		{"week", "2025-01-05", "", http.StatusOK, []string{"mon-2024-12-30", "wed-2025-01-01", "sun-2025-01-05"}},
+		{"week", "2024-12-29", "", http.StatusOK, []string{"sun-2024-12-29"}},
+		{"week", "2025-01-06", "", http.StatusOK, []string{"mon-2025-01-06"}},
+		{"month", "2024-12-15", "", http.StatusOK, []string{"sun-2024-12-29", "mon-2024-12-30"}},
+		{"month", "2025-01-31", "", http.StatusOK, []string{"wed-2025-01-01", "sun-2025-01-05", "mon-2025-01-06"}},
+
+		{"month", "2023-01-01", "", http.StatusOK, []string{"jan-31-2023"}},
+		{"month", "2023-02-01", "", http.StatusOK, []string{"feb-28-2023"}},
+		{"month", "2023-02-28", "", http.StatusOK, []string{"feb-28-2023"}},
+		{"month", "2023-03-31", "", http.StatusOK, []string{"mar-01-2023"}},
+		{"month", "2023-04-30", "", http.StatusOK, []string{"apr-30-2023"}},
+		{"month", "2023-05-01", "", http.StatusOK, []string{"may-01-2023"}},
+		{"day", "2023-01-31", "", http.StatusOK, []string{"jan-31-2023"}},
+		{"day", "2023-02-29", "", http.StatusBadRequest, nil},
+		{"day", "2023-04-31", "", http.StatusBadRequest, nil},
+
+		{"day", "2024-02-29", "", http.StatusOK, []string{"feb-29-2024"}},
+		{"week", "2024-02-29", "", http.StatusOK, []string{"feb-29-2024", "sun-2024-03-03"}},
+		{"week", "2024-03-04", "", http.StatusOK, []string{"mon-2024-03-04"}},
+		{"month", "2024-02-29", "", http.StatusOK, []string{"feb-29-2024"}},
+		{"month", "2024-03-01", "", http.StatusOK, []string{"sun-2024-03-03", "mon-2024-03-04", "new-york-2024-03-11"}},
+		{"day", "2000-02-29", "", http.StatusOK, nil},
+		{"day", "2100-02-29", "", http.StatusBadRequest, nil},
+
+		{"week", "2024-03-10", "America/New_York", http.StatusOK, nil},
+		{"week", "2024-03-11", "America/New_York", http.StatusOK, []string{"new-york-2024-03-11"}},
+		{"day", "2024-03-10", "America/New_York", http.StatusOK, nil},
+
+		{"day", "2024-06-30", "", http.StatusOK, []string{"overnight"}},
+		{"day", "2024-07-01", "", http.StatusOK, []string{"overnight"}},
+		{"week", "2024-06-30", "", http.StatusOK, []string{"overnight"}},
+		{"week", "2024-07-01", "", http.StatusOK, []string{"overnight"}},
+		{"month", "2024-06-01", "", http.StatusOK, []string{"overnight"}},
+		{"month", "2024-07-31", "", http.StatusOK, []string{"overnight"}},
+
+		{"month", "2024-09-01", "", http.StatusOK, []string{"moscow-2024-10-01"}},
+		{"month", "2024-09-01", "Europe/Moscow", http.StatusOK, nil},
+		{"month", "2024-10-01", "Europe/Moscow", http.StatusOK, []string{"moscow-2024-10-01"}},
+		{"day", "2024-10-01", "Europe/Moscow", http.StatusOK, []string{"moscow-2024-10-01"}},
+	}
+	for _, test := range tests {
+		name := test.period + " " + test.date
+		if test.tz != "" {
+			name += " " + test.tz
+		}
+		t.Run(name, func(t *testing.T) {
+			query := url.Values{"user_id": {"1"}, "date": {test.date}}
+			if test.tz != "" {
+				query.Set("tz", test.tz)
+			}
+			resp := env.do(t, testRequest{path: "/events_for_" + test.period, query: query})
+			if resp.status != test.status {
+				t.Fatalf("Ожидается код %d, получено %d: %s", test.status, resp.status, resp.body)
+			}
+			if resp.status != http.StatusOK {
+				return
+			}
+			var events []Event
+			resp.result(t, &events)
+			notes := make([]string, 0, len(events))
+			for _, event := range events {
+				notes = append(notes, event.Note)
+			}
+			if strings.Join(notes, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("Ожидаются события %v, получено %v", test.expected, notes)
+			}
+		})
+	}
+}
+
+// streamReader читает события SSE из открытого потока
+type streamReader struct {
+	events chan map[string]string
+}
+
+func openStream(t *testing.T, env *testEnv, lastEventID string) *streamReader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, env.URL+"/events/stream?user_id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+env.userToken)
+	req.Header.Set("Accept", contentTypeEventStream)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := env.Client().Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("Неожиданная ошибка при подключении к потоку: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+	})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentTypeEventStream {
+		t.Fatalf("Ожидается поток %s с кодом 200, получено %d %s", contentTypeEventStream, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	stream := &streamReader{events: make(chan map[string]string, 16)}
+	go func() {
+		defer close(stream.events)
+		scanner := bufio.NewScanner(resp.Body)
+		event := make(map[string]string)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				if event["event"] != "" {
+					stream.events <- event
+				}
+				event = make(map[string]string)
+				continue
+			}
+			if name, value, ok := strings.Cut(line, ": "); ok && !strings.HasPrefix(line, ":") {
+				event[name] = value
+			}
+		}
+	}()
+	return stream
+}
+
+// next ждет следующее событие потока
+func (s *streamReader) next(t *testing.T) map[string]string {
+	t.Helper()
+	select {
+	case event, ok := <-s.events:
+		if !ok {
+			t.Fatal("Поток закрылся раньше времени")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Событие потока не пришло")
+		return nil
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	env := newTestEnv(t, nil)
+	stream := openStream(t, env, "")
+
+	create := func(userID int, note string) {
+		t.Helper()
+		resp := env.do(t, testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {fmt.Sprint(userID)}, "date": {"2024-03-01"}, "note": {note}}})
+		if resp.status != http.StatusOK {
+			t.Fatalf("Ожидается код 200, получено %d: %s", resp.status, resp.body)
+		}
+	}
+	// Изменения других пользователей в поток не попадают
+	create(2, "foreign")
+	create(1, "own")
+	first := stream.next(t)
+	if first["event"] != ChangeCreated || !strings.Contains(first["data"], `"note":"own"`) {
+		t.Fatalf("Ожидается создание собственного события, получено %v", first)
+	}
+
+	// После переподключения с Last-Event-ID приходят пропущенные изменения
+	create(1, "missed")
+	resumed := openStream(t, env, first["id"])
+	if event := resumed.next(t); !strings.Contains(event["data"], `"note":"missed"`) {
+		t.Errorf("Ожидается пропущенное событие, получено %v", event)
+	}
+
+	// ID из другого запуска сервера продолжить нельзя
+	if event := openStream(t, env, "stale-1").next(t); event["event"] != "reset" {
+		t.Errorf("Ожидается событие reset, получено %v", event)
+	}
+}
+
+func TestEventStreamRemovedAttendee(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var webhook Webhook
+	env.do(t, testRequest{method: http.MethodPost, path: "/create_webhook", as: asUser,
+		json: `{"user_id":1,"url":"https://hooks.example.com/calendar"}`}).result(t, &webhook)
+	stream := openStream(t, env, "")
+
+	// Пользователь 2 приглашает пользователя 1, а затем убирает его из участников
+	var event Event
+	env.do(t, testRequest{method: http.MethodPost, path: "/create_event",
+		form: url.Values{"user_id": {"2"}, "date": {"2024-03-01"}, "note": {"sync"}, "attendees": {"1"}}}).result(t, &event)
+	if invited := stream.next(t); invited["event"] != ChangeCreated || !strings.Contains(invited["data"], `"note":"sync"`) {
+		t.Fatalf("Ожидается приглашение, получено %v", invited)
+	}
+	update := func(note string) {
+		t.Helper()
+		resp := env.do(t, testRequest{method: http.MethodPost, path: "/update_event",
+			form: url.Values{"id": {fmt.Sprint(event.ID)}, "user_id": {"2"}, "date": {"2024-03-01"}, "note": {note}}})
+		if resp.status != http.StatusOK {
+			t.Fatalf("Ожидается код 200, получено %d: %s", resp.status, resp.body)
+		}
+	}
+	update("sync without guests")
+	removed := stream.next(t)
+	if removed["event"] != ChangeDeleted || !strings.Contains(removed["data"], fmt.Sprintf(`"id":%d`, event.ID)) {
+		t.Fatalf("Убранному участнику ожидается удаление события, получено %v", removed)
+	}
+	// Чужое событие после этого в поток не попадает: следующим приходит собственное
+	update("sync, moved")
+	env.do(t, testRequest{method: http.MethodPost, path: "/create_event",
+		form: url.Values{"user_id": {"1"}, "date": {"2024-03-02"}, "note": {"own"}}})
+	if next := stream.next(t); next["event"] != ChangeCreated || !strings.Contains(next["data"], `"note":"own"`) {
+		t.Errorf("Ожидается создание собственного события, получено %v", next)
+	}
+
+	var deliveries []WebhookDelivery
+	env.do(t, testRequest{path: "/webhook_deliveries", as: asUser,
+		query: url.Values{"user_id": {"1"}, "webhook_id": {webhook.ID}}}).result(t, &deliveries)
+	var changes []string
+	for _, delivery := range deliveries {
+		if delivery.EventID == event.ID {
+			changes = append(changes, delivery.Change)
+		}
+	}
+	sort.Strings(changes)
+	if strings.Join(changes, ",") != "created,deleted" {
+		t.Errorf("Ожидаются доставки created и deleted, получено %v", changes)
+	}
+}
+
+func TestEventLifecycle(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var webhook Webhook
+	env.do(t, testRequest{method: http.MethodPost, path: "/create_webhook", as: asUser,
+		json: `{"user_id":1,"url":"https://hooks.example.com/calendar"}`}).result(t, &webhook)
+
+	var created Event
+	env.do(t, testRequest{method: http.MethodPost, path: "/create_event", as: asUser,
+		json: `{"user_id":1,"start":"2024-02-28T09:00:00Z","end":"2024-02-28T10:00:00Z","note":"draft"}`}).result(t, &created)
+	var updated Event
+	env.do(t, testRequest{method: http.MethodPost, path: "/update_event", as: asUser,
+		form: url.Values{"id": {fmt.Sprint(created.ID)}, "user_id": {"1"}, "start": {"2024-03-01T09:00:00Z"},
+			"end": {"2024-03-01T10:00:00Z"}, "note": {"final"}}}).result(t, &updated)
+	if updated.ID != created.ID || updated.Note != "final" {
+		t.Fatalf("Неожиданный результат изменения: %+v", updated)
+	}
+
+	var week []Event
+	env.do(t, testRequest{path: "/events_for_week", as: asUser,
+		query: url.Values{"user_id": {"1"}, "date": {"2024-02-28"}}}).result(t, &week)
+	if len(week) != 1 || week[0].Note != "final" {
+		t.Errorf("Ожидается перенесенное событие в неделе, получено %+v", week)
+	}
+
+	deleted := env.do(t, testRequest{method: http.MethodPost, path: "/delete_event", as: asUser,
+		form: url.Values{"id": {fmt.Sprint(created.ID)}, "user_id": {"1"}}})
+	if deleted.status != http.StatusOK {
+		t.Fatalf("Ожидается код 200, получено %d: %s", deleted.status, deleted.body)
+	}
+	var month []Event
+	env.do(t, testRequest{path: "/events_for_month", as: asUser,
+		query: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}}}).result(t, &month)
+	if len(month) != 0 {
+		t.Errorf("После удаления ожидается пустой месяц, получено %+v", month)
+	}
+
+	// Каждое изменение поставлено в очередь доставки подписке пользователя
+	var deliveries []WebhookDelivery
+	env.do(t, testRequest{path: "/webhook_deliveries", as: asUser,
+		query: url.Values{"user_id": {"1"}, "webhook_id": {webhook.ID}}}).result(t, &deliveries)
+	var changes []string
+	for _, delivery := range deliveries {
+		changes = append(changes, delivery.Change)
+	}
+	sort.Strings(changes)
+	if strings.Join(changes, ",") != "created,deleted,updated" {
+		t.Errorf("Ожидаются доставки created, updated и deleted, получено %v", changes)
+	}
+
+	if resp := env.do(t, testRequest{method: http.MethodPost, path: "/delete_webhook", as: asUser,
+		form: url.Values{"id": {webhook.ID}, "user_id": {"1"}}}); resp.status != http.StatusOK {
+		t.Fatalf("Ожидается код 200, получено %d: %s", resp.status, resp.body)
+	}
+	var webhooks []Webhook
+	env.do(t, testRequest{path: "/webhooks", as: asUser, query: url.Values{"user_id": {"1"}}}).result(t, &webhooks)
+	if len(webhooks) != 0 {
+		t.Errorf("После удаления подписки ожидается пустой список, получено %+v", webhooks)
+	}
+}
+
+// testRepositories — хранилища, на которых проверяются сквозные сценарии. open открывает
+// хранилище в каталоге dir; persistent — данные переживают повторное открытие.
+var testRepositories = []struct {
+	name       string
+	persistent bool
+	open       func(t *testing.T, dir string) EventRepository
+}{
+	{"memory", false, func(t *testing.T, dir string) EventRepository { return NewMemoryRepository() }},
+	{"file", true, func(t *testing.T, dir string) EventRepository {
+		repo, err := NewFileRepository(dir, 0, slog.Default())
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при открытии хранилища: %v", err)
+		}
+		return repo
+	}},
+	{"sqlite", true, func(t *testing.T, dir string) EventRepository {
+		repo, err := NewSQLiteRepository(filepath.Join(dir, "events.db"))
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при открытии хранилища: %v", err)
+		}
+		return repo
+	}},
+}
+
+func TestInvitations(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			env := newTestEnv(t, repository.open(t, t.TempDir()))
+			c, _ := client.New(env.URL, client.WithToken(env.adminToken), client.WithHTTPClient(env.Client()))
+			ctx := context.Background()
+			day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+			params := client.EventParams{UserID: 1, Start: day.Add(10 * time.Hour), End: day.Add(11 * time.Hour),
+				Note: "design review", Attendees: []int{2, 3}}
+			event, err := c.CreateEvent(ctx, params)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+			}
+
+			// Приглашенные видят событие в своем календаре
+			for _, userID := range []int{2, 3} {
+				events, err := c.EventsForDay(ctx, userID, day)
+				if err != nil || len(events) != 1 || events[0].ID != event.ID {
+					t.Fatalf("Пользователь %d должен видеть приглашение, получено %+v, %v", userID, events, err)
+				}
+			}
+			if _, err := c.RespondToEvent(ctx, event.ID, 2, client.RSVPAccepted); err != nil {
+				t.Fatalf("Неожиданная ошибка при ответе: %v", err)
+			}
+			answered, err := c.RespondToEvent(ctx, event.ID, 3, client.RSVPDeclined)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при ответе: %v", err)
+			}
+			if want := (client.RSVPSummary{Accepted: 1, Declined: 1}); answered.Responses == nil || *answered.Responses != want {
+				t.Errorf("Ожидается сводка %+v, получено %+v", want, answered.Responses)
+			}
+
+			// Приглашенный не может менять событие
+			invitee := params
+			invitee.UserID, invitee.Attendees = 2, nil
+			var apiErr *client.APIError
+			if _, err := c.UpdateEvent(ctx, event.ID, invitee); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+				t.Errorf("Ожидается 403 при изменении события приглашенным, получено %v", err)
+			}
+
+			// Изменение заметки сохраняет ответы, перенос времени сбрасывает их
+			params.Note = "design review, room 4"
+			updated, err := c.UpdateEvent(ctx, event.ID, params)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при изменении: %v", err)
+			}
+			if want := (client.RSVPSummary{Accepted: 1, Declined: 1}); updated.Responses == nil || *updated.Responses != want {
+				t.Errorf("После изменения заметки ожидается сводка %+v, получено %+v", want, updated.Responses)
+			}
+			params.Start, params.End = params.Start.Add(time.Hour), params.End.Add(time.Hour)
+			params.Attendees = []int{2}
+			moved, err := c.UpdateEvent(ctx, event.ID, params)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при переносе: %v", err)
+			}
+			if want := (client.RSVPSummary{NeedsAction: 1}); moved.Responses == nil || *moved.Responses != want {
+				t.Errorf("После переноса ожидается сводка %+v, получено %+v", want, moved.Responses)
+			}
+
+			// Исключенный из участников больше не видит событие и не может ответить
+			if events, err := c.EventsForDay(ctx, 3, day); err != nil || len(events) != 0 {
+				t.Errorf("Исключенный участник не должен видеть событие, получено %+v, %v", events, err)
+			}
+			if _, err := c.RespondToEvent(ctx, event.ID, 3, client.RSVPAccepted); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+				t.Errorf("Ожидается 403 при ответе исключенного участника, получено %v", err)
+			}
+			if err := c.DeleteEvent(ctx, event.ID, 1); err != nil {
+				t.Fatalf("Неожиданная ошибка при удалении: %v", err)
+			}
+			if events, err := c.EventsForDay(ctx, 2, day); err != nil || len(events) != 0 {
+				t.Errorf("После удаления приглашение должно исчезнуть, получено %+v, %v", events, err)
+			}
+		})
+	}
+}
+
+func TestEventHistory(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			dir := t.TempDir()
+			env := newTestEnv(t, repository.open(t, dir))
+			c, _ := client.New(env.URL, client.WithToken(env.adminToken), client.WithHTTPClient(env.Client()))
+			ctx := context.Background()
+			day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+			params := client.EventParams{UserID: 1, Start: day.Add(10 * time.Hour), End: day.Add(11 * time.Hour),
+				Note: "budget", Attendees: []int{2}}
+			event, err := c.CreateEvent(ctx, params)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+			}
+			params.Note = "budget, wrong room"
+			if _, err := c.UpdateEvent(ctx, event.ID, params); err != nil {
+				t.Fatalf("Неожиданная ошибка при изменении: %v", err)
+			}
+			if _, err := c.RespondToEvent(ctx, event.ID, 2, client.RSVPAccepted); err != nil {
+				t.Fatalf("Неожиданная ошибка при ответе: %v", err)
+			}
+			if err := c.DeleteEvent(ctx, event.ID, 1); err != nil {
+				t.Fatalf("Неожиданная ошибка при удалении: %v", err)
+			}
+
+			history, err := c.EventHistory(ctx, event.ID, 1)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при чтении истории: %v", err)
+			}
+			want := []struct {
+				typ       string
+				changedBy int
+				before    string
+				after     string
+			}{
+				{client.ChangeCreated, 1, "", "budget"},
+				{client.ChangeUpdated, 1, "budget", "budget, wrong room"},
+				{client.ChangeUpdated, 2, "budget, wrong room", "budget, wrong room"},
+				{client.ChangeDeleted, 1, "budget, wrong room", ""},
+			}
+			if len(history) != len(want) {
+				t.Fatalf("Ожидается %d версий, получено %+v", len(want), history)
+			}
+			note := func(event *client.Event) string {
+				if event == nil {
+					return ""
+				}
+				return event.Note
+			}
+			for i, entry := range history {
+				if entry.Version != i+1 || entry.Type != want[i].typ || entry.ChangedBy != want[i].changedBy ||
+					note(entry.Before) != want[i].before || note(entry.After) != want[i].after {
+					t.Errorf("Версия %d: ожидается %+v, получено %+v", i+1, want[i], entry)
+				}
+			}
+
+			// Удаление нельзя выбрать целью отката, но удаленное событие можно восстановить
+			var apiErr *client.APIError
+			if _, err := c.RevertEvent(ctx, event.ID, 1, 4); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("Ожидается 400 при откате к удалению, получено %v", err)
+			}
+			restored, err := c.RevertEvent(ctx, event.ID, 1, 3)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при восстановлении: %v", err)
+			}
+			if restored.ID != event.ID || restored.Responses == nil || restored.Responses.Accepted != 1 {
+				t.Errorf("Ожидается событие %d с ответом приглашенного, получено %+v", event.ID, restored)
+			}
+			reverted, err := c.RevertEvent(ctx, event.ID, 1, 1)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при откате: %v", err)
+			}
+			// Время не менялось, поэтому ответ приглашенного сохраняется
+			if reverted.Note != "budget" || reverted.Responses == nil || reverted.Responses.Accepted != 1 {
+				t.Errorf("Ожидается заметка budget и принятое приглашение, получено %+v", reverted)
+			}
+			if events, err := c.EventsForDay(ctx, 2, day); err != nil || len(events) != 1 || events[0].Note != "budget" {
+				t.Errorf("Приглашенный должен снова видеть событие, получено %+v, %v", events, err)
+			}
+
+			// Историю видит только владелец
+			resp := env.do(t, testRequest{path: "/event_history", as: asUser,
+				query: url.Values{"id": {strconv.Itoa(event.ID)}, "user_id": {"2"}}})
+			if resp.status != http.StatusForbidden {
+				t.Errorf("Ожидается 403 при чтении чужой истории, получено %d", resp.status)
+			}
+			if _, err := c.EventHistory(ctx, event.ID, 2); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+				t.Errorf("Ожидается 403 при чтении истории приглашенным, получено %v", err)
+			}
+
+			if !repository.persistent {
+				return
+			}
+			reopened := repository.open(t, dir)
+			defer reopened.Close()
+			persisted, err := reopened.History().List(event.ID)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при чтении истории: %v", err)
+			}
+			if len(persisted) != 6 || persisted[4].RevertedTo != 3 || persisted[4].Type != ChangeCreated ||
+				persisted[5].RevertedTo != 1 || persisted[5].After == nil || persisted[5].After.Note != "budget" {
+				t.Errorf("История должна сохраниться на диске, получено %+v", persisted)
+			}
+		})
+	}
+}
+
+func TestEventHistoryWriteFailure(t *testing.T) {
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии хранилища: %v", err)
+	}
+	env := newTestEnv(t, repo)
+	resp := env.do(t, testRequest{method: http.MethodPost, path: "/create_event",
+		form: url.Values{"user_id": {"1"}, "date": {"2024-02-29"}, "note": {"original"}}})
+	if resp.status != http.StatusOK {
+		t.Fatalf("Ожидается 200, получено %d: %s", resp.status, resp.body)
+	}
+	// Запись истории ломается: изменение должно откатиться вместе с ней
+	if _, err := repo.(*sqliteRepository).db.Exec(`CREATE TRIGGER fail_history BEFORE INSERT ON event_history
+		BEGIN SELECT RAISE(ABORT, 'history is down'); END`); err != nil {
+		t.Fatalf("Неожиданная ошибка при создании триггера: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  testRequest
+	}{
+		{"create", testRequest{method: http.MethodPost, path: "/create_event",
+			form: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}}}},
+		{"update", testRequest{method: http.MethodPost, path: "/update_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}, "date": {"2024-03-02"}, "note": {"moved"}}}},
+		{"delete", testRequest{method: http.MethodPost, path: "/delete_event",
+			form: url.Values{"id": {"1"}, "user_id": {"1"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := env.do(t, tt.req)
+			if resp.status != http.StatusInternalServerError {
+				t.Fatalf("Ожидается 500 при сбое записи истории, получено %d: %s", resp.status, resp.body)
+			}
+			if strings.Contains(resp.body, "history is down") {
+				t.Errorf("Ответ не должен раскрывать внутреннюю ошибку: %s", resp.body)
+			}
+			event, err := repo.Get(1)
+			if err != nil || event.Note != "original" {
+				t.Errorf("Событие не должно измениться, получено %+v, %v", event, err)
+			}
+			if n, err := repo.Count(); err != nil || n != 1 {
+				t.Errorf("Ожидается 1 сохраненное событие, получено %d, %v", n, err)
+			}
+			if history, err := repo.History().List(1); err != nil || len(history) != 1 {
+				t.Errorf("Ожидается 1 версия, получено %d, %v", len(history), err)
+			}
+		})
+	}
+}
+
+func TestEventHistoryRetention(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			dir := t.TempDir()
+			env := newTestEnv(t, repository.open(t, dir))
+			c, _ := client.New(env.URL, client.WithToken(env.adminToken), client.WithHTTPClient(env.Client()))
+			ctx := context.Background()
+			day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+			params := client.EventParams{UserID: 1, Start: day.Add(10 * time.Hour), End: day.Add(11 * time.Hour), Note: "v1"}
+			event, err := c.CreateEvent(ctx, params)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+			}
+			const versions = historyLimit + 5
+			for v := 2; v <= versions; v++ {
+				params.Note = "v" + strconv.Itoa(v)
+				if _, err := c.UpdateEvent(ctx, event.ID, params); err != nil {
+					t.Fatalf("Неожиданная ошибка при изменении %d: %v", v, err)
+				}
+			}
+
+			// Хранятся только последние historyLimit версий, номера не сдвигаются
+			history, err := c.EventHistory(ctx, event.ID, 1)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при чтении истории: %v", err)
+			}
+			oldest := versions - historyLimit + 1
+			if len(history) != historyLimit || history[0].Version != oldest || history[len(history)-1].Version != versions {
+				t.Fatalf("Ожидаются версии %d-%d, получено %d версий с %d", oldest, versions, len(history), history[0].Version)
+			}
+			var apiErr *client.APIError
+			if _, err := c.RevertEvent(ctx, event.ID, 1, 1); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("Ожидается ошибка при откате к вытесненной версии, получено %v", err)
+			}
+			reverted, err := c.RevertEvent(ctx, event.ID, 1, oldest)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при откате: %v", err)
+			}
+			if want := "v" + strconv.Itoa(oldest); reverted.Note != want {
+				t.Errorf("Ожидается заметка %s, получено %q", want, reverted.Note)
+			}
+
+			if !repository.persistent {
+				return
+			}
+			reopened := repository.open(t, dir)
+			defer reopened.Close()
+			_, err = reopened.Write([]EventWrite{{Op: WriteDelete, Event: Event{ID: event.ID},
+				History: &HistoryEntry{Type: ChangeDeleted, ChangedBy: 1, Before: versionOf(Event{ID: event.ID, UserID: 1})}}})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при удалении: %v", err)
+			}
+			// Откат записан версией versions+1, номера продолжаются после перезапуска
+			persisted, err := reopened.History().List(event.ID)
+			if err != nil || len(persisted) != historyLimit || persisted[0].Version != oldest+2 {
+				t.Fatalf("Ожидается %d версий с %d, получено %d, %v", historyLimit, oldest+2, len(persisted), err)
+			}
+			if last := persisted[len(persisted)-1]; last.Version != versions+2 || last.Type != ChangeDeleted || last.After != nil {
+				t.Errorf("Ожидается удаление версией %d, получено %+v", versions+2, last)
+			}
+		})
+	}
+}
+
+func TestFindSlots(t *testing.T) {
+	env := newTestEnv(t, NewMemoryRepository())
+	c, _ := client.New(env.URL, client.WithToken(env.adminToken), client.WithHTTPClient(env.Client()))
+	ctx := context.Background()
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при загрузке пояса: %v", err)
+	}
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 3, day, hour, minute, 0, 0, newYork) }
+
+	// Пятница 8 марта и понедельник 11 марта: в ночь на 10 марта Нью-Йорк переходит на летнее время
+	events := []client.EventParams{
+		{UserID: 1, Start: at(8, 9, 0), End: at(8, 10, 0), Note: "standup"},
+		{UserID: 2, Start: at(8, 9, 30), End: at(8, 11, 0), Note: "secret interview"},
+		{UserID: 2, Start: at(8, 14, 0), End: at(8, 17, 30), Note: "offsite"},
+		{UserID: 3, Start: at(11, 12, 0), End: at(11, 13, 0), Note: "declined lunch", Attendees: []int{1}},
+		{UserID: 1, Start: at(9, 11, 0), End: at(9, 12, 0), Note: "weekend chores"},
+	}
+	for _, params := range events {
+		params.TimeZone = newYork.String()
+		created, err := c.CreateEvent(ctx, params)
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+		}
+		if params.UserID == 3 {
+			if _, err := c.RespondToEvent(ctx, created.ID, 1, client.RSVPDeclined); err != nil {
+				t.Fatalf("Неожиданная ошибка при ответе: %v", err)
+			}
+		}
+	}
+
+	busy, err := c.FreeBusy(ctx, []int{1, 2}, at(8, 0, 0), at(9, 0, 0))
+	if err != nil || len(busy) != 2 {
+		t.Fatalf("Неожиданный ответ занятости: %+v, %v", busy, err)
+	}
+	if got := busy[1].Busy; len(got) != 2 || !got[0].Start.Equal(at(8, 9, 30)) || !got[1].End.Equal(at(8, 17, 30)) {
+		t.Errorf("Неожиданная занятость пользователя 2: %+v", got)
+	}
+
+	tests := []struct {
+		name   string
+		params client.SlotParams
+		want   []client.TimeSlot
+	}{
+		{"busy time is merged across users", client.SlotParams{UserIDs: []int{1, 2}, From: at(8, 0, 0), To: at(9, 0, 0),
+			Duration: time.Hour}, []client.TimeSlot{{Start: at(8, 11, 0), End: at(8, 14, 0)}}},
+		{"short windows are skipped", client.SlotParams{UserIDs: []int{1, 2}, From: at(8, 0, 0), To: at(9, 0, 0),
+			Duration: 30 * time.Minute}, []client.TimeSlot{{Start: at(8, 11, 0), End: at(8, 14, 0)},
+			{Start: at(8, 17, 30), End: at(8, 18, 0)}}},
+		{"weekends and declined invitations", client.SlotParams{UserIDs: []int{1, 3}, From: at(9, 0, 0), To: at(12, 0, 0),
+			Duration: 2 * time.Hour, WorkStart: "10:00", WorkEnd: "16:00"},
+			[]client.TimeSlot{{Start: at(11, 10, 0), End: at(11, 12, 0)}, {Start: at(11, 13, 0), End: at(11, 16, 0)}}},
+		{"working hours follow the local clock after DST", client.SlotParams{UserIDs: []int{1}, From: at(9, 0, 0),
+			To: at(12, 0, 0), Duration: time.Hour, WorkStart: "10:00", WorkEnd: "11:00", Weekends: true},
+			[]client.TimeSlot{{Start: at(9, 10, 0), End: at(9, 11, 0)}, {Start: at(10, 10, 0), End: at(10, 11, 0)},
+				{Start: at(11, 10, 0), End: at(11, 11, 0)}}},
+		{"limit", client.SlotParams{UserIDs: []int{1}, From: at(11, 0, 0), To: at(16, 0, 0), Duration: time.Hour, Limit: 2},
+			[]client.TimeSlot{{Start: at(11, 9, 0), End: at(11, 18, 0)}, {Start: at(12, 9, 0), End: at(12, 18, 0)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slots, err := c.FindSlots(ctx, tt.params)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка: %v", err)
+			}
+			if len(slots) != len(tt.want) {
+				t.Fatalf("Ожидается %v, получено %v", tt.want, slots)
+			}
+			for i, slot := range slots {
+				if !slot.Start.Equal(tt.want[i].Start) || !slot.End.Equal(tt.want[i].End) {
+					t.Errorf("Окно %d: ожидается %v–%v, получено %v–%v", i, tt.want[i].Start, tt.want[i].End, slot.Start, slot.End)
+				}
+			}
+		})
+	}
+
+	// Занятость не раскрывает заметки чужих событий
+	resp := env.do(t, testRequest{path: "/free_busy", as: asUser,
+		query: url.Values{"user_id": {"2"}, "from": {"2024-03-08"}, "to": {"2024-03-09"}, "tz": {newYork.String()}}})
+	if resp.status != http.StatusOK || strings.Contains(resp.body, "interview") || strings.Contains(resp.body, `"id"`) {
+		t.Errorf("Ожидается занятость без заметок, получено %d %s", resp.status, resp.body)
+	}
+}
+
+func TestICSImport(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			env := newTestEnv(t, repository.open(t, t.TempDir()))
+			start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+			if _, err := env.srv.service.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "created by API"}); err != nil {
+				t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+			}
+			importICS := func(lines ...string) testResponse {
+				return env.do(t, testRequest{method: http.MethodPost, path: "/import_ics", query: url.Values{"user_id": {"1"}},
+					contentType: contentTypeCalendar, body: icsDocument(lines...)})
+			}
+			count := func() int {
+				n, err := env.srv.service.CountEvents()
+				if err != nil {
+					t.Fatalf("Неожиданная ошибка: %v", err)
+				}
+				return n
+			}
+
+			calendar := []string{
+				"BEGIN:VEVENT", "UID:review@example.com", "DTSTART:20240305T100000Z", "DTEND:20240305T110000Z", "SUMMARY:review", "END:VEVENT",
+				"BEGIN:VEVENT", "UID:retro@example.com", "DTSTART:20240306T100000Z", "DTEND:20240306T110000Z", "SUMMARY:retro", "END:VEVENT",
+			}
+			var first []Event
+			importICS(calendar...).result(t, &first)
+			if len(first) != 2 || count() != 3 {
+				t.Fatalf("Ожидается 2 импортированных события из 3, получено %+v, всего %d", first, count())
+			}
+
+			// Повторный импорт того же UID обновляет событие, а не создает копию
+			calendar[4] = "SUMMARY:review, moved"
+			calendar[2] = "DTSTART:20240305T150000Z"
+			calendar[3] = "DTEND:20240305T160000Z"
+			var second []Event
+			importICS(calendar...).result(t, &second)
+			if len(second) != 2 || second[0].ID != first[0].ID || second[0].Note != "review, moved" || second[0].Start.Hour() != 15 || count() != 3 {
+				t.Errorf("Ожидается обновление события %d, получено %+v, всего %d", first[0].ID, second, count())
+			}
+
+			// Выгрузка, загруженная обратно, тоже не создает копий, в том числе событий без UID
+			export := env.do(t, testRequest{path: "/export_ics", query: url.Values{"user_id": {"1"}}})
+			resp := env.do(t, testRequest{method: http.MethodPost, path: "/import_ics", query: url.Values{"user_id": {"1"}},
+				contentType: contentTypeCalendar, body: export.body})
+			if resp.status != http.StatusOK || count() != 3 {
+				t.Errorf("Повторный импорт выгрузки не должен создавать события, получено %d %s, всего %d", resp.status, resp.body, count())
+			}
+
+			// Ошибка в одном событии отменяет импорт всего файла
+			resp = importICS("BEGIN:VEVENT", "UID:new@example.com", "DTSTART:20240307T100000Z", "SUMMARY:new", "END:VEVENT",
+				"BEGIN:VEVENT", "UID:broken@example.com", "DTSTART:20240307T100000Z", "DTEND:20240307T090000Z", "END:VEVENT")
+			if resp.status != http.StatusBadRequest || !strings.Contains(resp.body, "ics event 2") || count() != 3 {
+				t.Errorf("Ожидается 400 без сохранения событий, получено %d %s, всего %d", resp.status, resp.body, count())
+			}
+			resp = importICS("BEGIN:VEVENT", "DTSTART;TZID=Mars/Olympus:20240307T100000", "END:VEVENT")
+			if resp.status != http.StatusBadRequest || !strings.Contains(resp.body, "unknown TZID") {
+				t.Errorf("Ожидается 400 для неизвестного пояса, получено %d %s", resp.status, resp.body)
+			}
+			resp = importICS("BEGIN:VEVENT", "UID:twice", "DTSTART:20240307T100000Z", "END:VEVENT",
+				"BEGIN:VEVENT", "UID:twice", "DTSTART:20240308T100000Z", "END:VEVENT")
+			if resp.status != http.StatusBadRequest || count() != 3 {
+				t.Errorf("Ожидается 400 для повторяющегося UID, получено %d %s", resp.status, resp.body)
+			}
+
+			// Событие, на которое пользователь приглашен, выгружается под UID владельца;
+			// при загрузке выгрузки оно пропускается, а не копируется в календарь приглашенного
+			invitation, err := env.srv.service.CreateEvent(Event{UserID: 2, Start: start.Add(48 * time.Hour),
+				End: start.Add(49 * time.Hour), Note: "invitation", Attendees: []Attendee{{UserID: 1}}})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании приглашения: %v", err)
+			}
+			export = env.do(t, testRequest{path: "/export_ics", query: url.Values{"user_id": {"1"}}})
+			if !strings.Contains(export.body, "SUMMARY:invitation") {
+				t.Fatalf("Выгрузка должна содержать приглашение: %s", export.body)
+			}
+			var reimported []Event
+			env.do(t, testRequest{method: http.MethodPost, path: "/import_ics", query: url.Values{"user_id": {"1"}},
+				contentType: contentTypeCalendar, body: export.body}).result(t, &reimported)
+			for _, event := range reimported {
+				if event.ID == invitation.ID || event.Note == "invitation" {
+					t.Errorf("Приглашение не должно импортироваться, получено %+v", event)
+				}
+			}
+			if len(reimported) != 3 || count() != 4 {
+				t.Errorf("Ожидается 3 обновленных события и 4 всего, получено %d и %d", len(reimported), count())
+			}
+		})
+	}
+}
+
+// flakyWriteRepository — хранилище, в котором первые failures вызовов Write завершаются ошибкой
+type flakyWriteRepository struct {
+	EventRepository
+	failures int
+}
+
+func (r *flakyWriteRepository) Write(writes []EventWrite) ([]Event, error) {
+	if r.failures > 0 {
+		r.failures--
+		return nil, errStorageDown
+	}
+	return r.EventRepository.Write(writes)
+}
+
+func TestICSImportWriteFailure(t *testing.T) {
+	repo := &flakyWriteRepository{EventRepository: NewMemoryRepository(), failures: 1}
+	env := newTestEnv(t, repo)
+	calendar := icsDocument(
+		"BEGIN:VEVENT", "DTSTART:20240305T100000Z", "DTEND:20240305T110000Z", "SUMMARY:review", "END:VEVENT",
+		"BEGIN:VEVENT", "DTSTART:20240306T100000Z", "DTEND:20240306T110000Z", "SUMMARY:retro", "END:VEVENT",
+	)
+	importICS := func() testResponse {
+		return env.do(t, testRequest{method: http.MethodPost, path: "/import_ics", query: url.Values{"user_id": {"1"}},
+			contentType: contentTypeCalendar, body: calendar})
+	}
+
+	// Сбой записи не оставляет части файла: повтор импорта событий без UID не создает копий
+	if resp := importICS(); resp.status != http.StatusInternalServerError {
+		t.Fatalf("Ожидается 500 при сбое записи, получено %d: %s", resp.status, resp.body)
+	}
+	if n, _ := repo.Count(); n != 0 {
+		t.Fatalf("После сбоя не должно остаться событий, получено %d", n)
+	}
+	var imported []Event
+	importICS().result(t, &imported)
+	if n, _ := repo.Count(); len(imported) != 2 || n != 2 {
+		t.Errorf("Ожидается 2 события после повтора, получено %d и %d", len(imported), n)
+	}
+}
+
+func TestRepositoryWriteAtomic(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			dir := t.TempDir()
+			repo := repository.open(t, dir)
+			start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+			kept, err := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "kept"})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании: %v", err)
+			}
+			removed, err := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "removed"})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании: %v", err)
+			}
+
+			// Невыполнимая последняя запись отменяет и предыдущие
+			changed := kept
+			changed.Note = "changed"
+			_, err = repo.Write([]EventWrite{
+				{Op: WriteCreate, Event: Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "new"}},
+				{Op: WriteUpdate, Event: changed},
+				{Op: WriteDelete, Event: Event{ID: 999}},
+			})
+			if !errors.Is(err, ErrEventNotFound) {
+				t.Fatalf("Ожидается ErrEventNotFound, получено %v", err)
+			}
+			if n, _ := repo.Count(); n != 2 {
+				t.Errorf("После отмененной записи ожидается 2 события, получено %d", n)
+			}
+			if event, _ := repo.Get(kept.ID); event.Note != "kept" {
+				t.Errorf("Событие не должно измениться, получено %+v", event)
+			}
+			if _, err := repo.Write([]EventWrite{{Op: WriteRestore, Event: kept}, {Op: WriteDelete, Event: removed}}); !errors.Is(err, ErrEventExists) {
+				t.Errorf("Ожидается ErrEventExists при восстановлении занятого ID, получено %v", err)
+			}
+
+			events, err := repo.Write([]EventWrite{
+				{Op: WriteCreate, Event: Event{UserID: 2, Start: start, End: start.Add(time.Hour), Note: "new"}},
+				{Op: WriteUpdate, Event: changed},
+				{Op: WriteDelete, Event: Event{ID: removed.ID}},
+				{Op: WriteRestore, Event: removed},
+			})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при записи: %v", err)
+			}
+			if len(events) != 4 || events[0].ID == 0 || events[0].ID == kept.ID || events[0].ID == removed.ID ||
+				events[1].Note != "changed" || events[2].Note != "removed" || events[3].ID != removed.ID {
+				t.Fatalf("Ожидаются события после записей, получено %+v", events)
+			}
+			check := func(repo EventRepository) {
+				t.Helper()
+				if n, _ := repo.Count(); n != 3 {
+					t.Errorf("Ожидается 3 события, получено %d", n)
+				}
+				if event, err := repo.Get(events[0].ID); err != nil || event.Note != "new" || event.UserID != 2 {
+					t.Errorf("Ожидается новое событие, получено %+v, %v", event, err)
+				}
+				if event, _ := repo.Get(kept.ID); event.Note != "changed" {
+					t.Errorf("Ожидается измененное событие, получено %+v", event)
+				}
+				if event, err := repo.Get(removed.ID); err != nil || event.Note != "removed" {
+					t.Errorf("Удаленное и восстановленное событие должно остаться, получено %+v, %v", event, err)
+				}
+			}
+			check(repo)
+			if !repository.persistent {
+				return
+			}
+			// Второе хранилище над теми же файлами видит весь набор записей
+			reopened := repository.open(t, dir)
+			defer reopened.Close()
+			check(reopened)
+			repo.Close()
+		})
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			env := newTestEnv(t, repository.open(t, t.TempDir()))
+			create := func(start time.Time, note, rrule string) int {
+				t.Helper()
+				event := Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: note}
+				if rrule != "" {
+					rec, err := parseRecurrence(rrule, "", start)
+					if err != nil {
+						t.Fatalf("Неожиданная ошибка: %v", err)
+					}
+					event.Recurrence = rec
+				}
+				created, err := env.srv.service.CreateEvent(event)
+				if err != nil {
+					t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+				}
+				return created.ID
+			}
+			tue := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+			wed := time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC)
+			// Серии начинаются раньше диапазона, но ни одно их повторение в него не попадает:
+			// хранилище отдает их, и сервис должен дочитать страницу
+			for i := 0; i < 3; i++ {
+				create(tue.AddDate(0, 0, -1), "Sync series", "FREQ=WEEKLY")
+			}
+			// События с одинаковым началом упорядочиваются по ID
+			want := []int{
+				create(tue, "SYNC with team", ""),
+				create(tue, "Синк sync", ""),
+				create(tue, "daily sync", ""),
+				create(wed, "sync again", ""),
+				create(wed, "last SYNC", ""),
+			}
+			create(tue, "lunch", "")
+
+			search := func(query url.Values) []int {
+				t.Helper()
+				var ids []int
+				seen := make(map[int]bool)
+				for pages := 0; ; pages++ {
+					if pages > len(want) {
+						t.Fatalf("Слишком много страниц: %v", ids)
+					}
+					var page searchResponse
+					env.do(t, testRequest{path: "/events/search", query: query}).result(t, &page)
+					if len(page.Events) > 2 {
+						t.Fatalf("Страница больше limit: %d событий", len(page.Events))
+					}
+					for _, event := range page.Events {
+						if seen[event.ID] {
+							t.Fatalf("Событие %d повторилось на разных страницах: %v", event.ID, ids)
+						}
+						seen[event.ID] = true
+						ids = append(ids, event.ID)
+					}
+					if page.NextCursor == "" {
+						return ids
+					}
+					query.Set("cursor", page.NextCursor)
+				}
+			}
+			query := url.Values{"user_id": {"1"}, "q": {"sync"}, "limit": {"2"},
+				"from": {"2024-03-05"}, "to": {"2024-03-07"}}
+			if got := search(query); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("Ожидаются события %v, получено %v", want, got)
+			}
+
+			query = url.Values{"user_id": {"1"}, "q": {"sync"}, "limit": {"2"}, "sort": {"-start"},
+				"from": {"2024-03-05"}, "to": {"2024-03-07"}}
+			reversed := make([]int, len(want))
+			for i, id := range want {
+				reversed[len(want)-1-i] = id
+			}
+			if got := search(query); fmt.Sprint(got) != fmt.Sprint(reversed) {
+				t.Errorf("Ожидаются события %v, получено %v", reversed, got)
+			}
+
+			// Регистр не важен и для букв не из ASCII
+			query = url.Values{"user_id": {"1"}, "q": {"СИНК"}}
+			if got := search(query); fmt.Sprint(got) != fmt.Sprint(want[1:2]) {
+				t.Errorf("Ожидается событие %v, получено %v", want[1:2], got)
+			}
+		})
+	}
+}
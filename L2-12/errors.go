@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ValidationError — ошибка входных данных (невалидный int, неверный формат даты и т.п.).
+// Отдается клиенту с кодом HTTP 400.
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string { return e.Msg }
+
+// DomainError — ошибка бизнес-логики (событие не найдено и т.п.).
+// Отдается клиенту с кодом HTTP 503.
+type DomainError struct {
+	Msg string
+}
+
+func (e *DomainError) Error() string { return e.Msg }
+
+// ForbiddenError — запрет доступа к чужим данным.
+// Отдается клиенту с кодом HTTP 403.
+type ForbiddenError struct {
+	Msg string
+}
+
+func (e *ForbiddenError) Error() string { return e.Msg }
+
+// InternalError — все остальные ошибки (сбой хранилища и т.п.).
+// Отдается клиенту с кодом HTTP 500, подробности пишутся только в лог.
+type InternalError struct {
+	Err error
+}
+
+func (e *InternalError) Error() string { return e.Err.Error() }
+
+func (e *InternalError) Unwrap() error { return e.Err }
+
+// StatusError — ошибка протокола с заданным HTTP-кодом (неподдерживаемый Content-Type,
+// слишком большое тело запроса и т.п.)
+type StatusError struct {
+	Status int
+	Msg    string
+}
+
+func (e *StatusError) Error() string { return e.Msg }
+
+func newValidationError(msg string) error { return &ValidationError{Msg: msg} }
+
+func newDomainError(msg string) error { return &DomainError{Msg: msg} }
+
+func newForbiddenError(msg string) error { return &ForbiddenError{Msg: msg} }
+
+func newStatusError(status int, msg string) error { return &StatusError{Status: status, Msg: msg} }
+
+// internalError оборачивает ошибку в InternalError, если она еще не типизирована
+func internalError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	var domainErr *DomainError
+	var forbiddenErr *ForbiddenError
+	var statusErr *StatusError
+	var internalErr *InternalError
+	if errors.As(err, &validationErr) || errors.As(err, &domainErr) || errors.As(err, &forbiddenErr) ||
+		errors.As(err, &statusErr) || errors.As(err, &internalErr) {
+		return err
+	}
+	return &InternalError{Err: err}
+}
+
+// errorStatus сопоставляет ошибке HTTP-код ответа
+func errorStatus(err error) int {
+	var validationErr *ValidationError
+	var domainErr *DomainError
+	var forbiddenErr *ForbiddenError
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.Status
+	case errors.As(err, &forbiddenErr):
+		return http.StatusForbidden
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &domainErr):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError отправляет клиенту JSON-документ вида {"error": "..."} с подходящим HTTP-кодом.
+// Ошибка запоминается в statusRecorder, и LoggingMiddleware пишет внутренние ошибки
+// в лог сервера вместе с идентификатором запроса.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		msg = http.StatusText(status)
+	}
+	if rec := findStatusRecorder(w); rec != nil {
+		rec.err = err
+	}
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	journalFileName   = "events.journal"
+	snapshotFileName  = "events.snapshot.json"
+	remindersFileName = "reminders.json"
+	historyFileName   = "history.jsonl"
+)
+
+// Операции, записываемые в журнал
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+	// opBatch — несколько операций, записанных одной строкой (EventRepository.Write)
+	opBatch = "batch"
+)
+
+// journalRecord — одна строка журнала операций
+type journalRecord struct {
+	Op    string          `json:"op"`
+	ID    int             `json:"id"`
+	Event *Event          `json:"event,omitempty"`
+	Batch []journalRecord `json:"batch,omitempty"`
+	// History — версия события, сохраненная вместе с операцией
+	History *HistoryEntry `json:"history,omitempty"`
+}
+
+// snapshotFile — содержимое файла снимка
+type snapshotFile struct {
+	NextID int     `json:"next_id"`
+	Events []Event `json:"events"`
+}
+
+// fileRepository хранит события на диске.
+// Каждая операция дописывается в журнал (JSON lines), при старте журнал проигрывается поверх
+// последнего снимка. Периодически журнал уплотняется: текущее состояние записывается в снимок,
+// а журнал очищается. Чтение обслуживается из памяти.
+type fileRepository struct {
+	mu      sync.Mutex
+	dir     string
+	mem     *memoryRepository
+	journal *os.File
+	ops     int // количество операций в журнале с момента последнего уплотнения
+
+	reminders *fileReminderStore
+	history   *fileHistoryStore
+	logger    *slog.Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewFileRepository открывает (или создает) хранилище в каталоге dir.
+// Если compactEvery > 0, журнал уплотняется в фоне с указанным интервалом.
+func NewFileRepository(dir string, compactEvery time.Duration, logger *slog.Logger) (EventRepository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+
+	f := &fileRepository{dir: dir, mem: newMemoryRepository(), logger: logger}
+	// История загружается первой: журнал дописывает в нее версии, еще не сохраненные в файл
+	history, err := openFileHistoryStore(f.path(historyFileName), logger)
+	if err != nil {
+		return nil, err
+	}
+	f.history = history
+	if err := f.load(); err != nil {
+		// Файл истории уже открыт: закрываем его, иначе дескриптор утечет
+		history.close()
+		return nil, err
+	}
+
+	if compactEvery > 0 {
+		f.stop = make(chan struct{})
+		f.done = make(chan struct{})
+		go f.compactLoop(compactEvery)
+	}
+	return f, nil
+}
+
+// load загружает снимок, проигрывает журнал поверх него и открывает журнал для записи
+func (f *fileRepository) load() error {
+	if err := f.loadSnapshot(); err != nil {
+		return err
+	}
+	if err := f.replayJournal(); err != nil {
+		return err
+	}
+	reminders, err := openFileReminderStore(f.path(remindersFileName))
+	if err != nil {
+		return err
+	}
+	f.reminders = reminders
+	journal, err := os.OpenFile(f.path(journalFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	f.journal = journal
+	return nil
+}
+
+func (f *fileRepository) Create(event Event) (Event, error) {
+	return writeOne(f, EventWrite{Op: WriteCreate, Event: event})
+}
+
+func (f *fileRepository) Update(event Event) error {
+	_, err := writeOne(f, EventWrite{Op: WriteUpdate, Event: event})
+	return err
+}
+
+func (f *fileRepository) Restore(event Event) error {
+	_, err := writeOne(f, EventWrite{Op: WriteRestore, Event: event})
+	return err
+}
+
+func (f *fileRepository) Delete(id int) error {
+	_, err := writeOne(f, EventWrite{Op: WriteDelete, Event: Event{ID: id}})
+	return err
+}
+
+// Write проверяет записи по состоянию в памяти, присваивает ID новым событиям и номера версиям
+// и дописывает в журнал одну строку вместе с версиями: после сбоя при проигрывании журнала
+// набор либо применяется целиком, либо его недописанная строка отрезается.
+func (f *fileRepository) Write(writes []EventWrite) ([]Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.mem.checkWrites(writes); err != nil {
+		return nil, err
+	}
+	result := make([]Event, len(writes))
+	records := make([]journalRecord, len(writes))
+	// written — события, уже измененные предыдущими записями набора, versions — номера их последних версий
+	written := make(map[int]Event)
+	versions := make(map[int]int)
+	for i, w := range writes {
+		event := w.Event
+		switch w.Op {
+		case WriteCreate:
+			event.ID = int(f.mem.nextID.Add(1))
+			fallthrough
+		case WriteRestore:
+			// При проигрывании журнала create с уже присвоенным ID восстанавливает событие как есть
+			records[i] = journalRecord{Op: opCreate, ID: event.ID, Event: &event}
+		case WriteUpdate:
+			records[i] = journalRecord{Op: opUpdate, ID: event.ID, Event: &event}
+		case WriteDelete:
+			current, ok := written[event.ID]
+			if !ok {
+				var err error
+				if current, err = f.mem.Get(event.ID); err != nil {
+					return nil, err
+				}
+			}
+			records[i] = journalRecord{Op: opDelete, ID: event.ID}
+			event = current
+		}
+		if w.History != nil {
+			entry := historyEntry(*w.History, w.Op, event)
+			if _, ok := versions[event.ID]; !ok {
+				versions[event.ID] = f.history.version(event.ID)
+			}
+			versions[event.ID]++
+			entry.Version = versions[event.ID]
+			records[i].History = &entry
+		}
+		written[event.ID] = event
+		result[i] = event
+	}
+
+	record := records[0]
+	if len(records) > 1 {
+		record = journalRecord{Op: opBatch, Batch: records}
+	}
+	if err := f.appendRecord(record); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		f.apply(record)
+	}
+	return result, nil
+}
+
+func (f *fileRepository) UpdateAttendee(id int, attendee Attendee, history *HistoryEntry) (Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, err := f.mem.Get(id)
+	if err != nil {
+		return Event{}, err
+	}
+	event, err := current.withAttendee(attendee)
+	if err != nil {
+		return Event{}, err
+	}
+	record := journalRecord{Op: opUpdate, ID: event.ID, Event: &event}
+	if history != nil {
+		entry := historyEntry(*history, WriteUpdate, event)
+		entry.Before = versionOf(current)
+		entry.Version = f.history.version(id) + 1
+		record.History = &entry
+	}
+	if err := f.appendRecord(record); err != nil {
+		return Event{}, err
+	}
+	f.apply(record)
+	return event, nil
+}
+
+func (f *fileRepository) Get(id int) (Event, error) {
+	return f.mem.Get(id)
+}
+
+func (f *fileRepository) GetByUID(userID int, uid string) (Event, error) {
+	return f.mem.GetByUID(userID, uid)
+}
+
+func (f *fileRepository) ListByUser(userID int, from, to time.Time) ([]Event, error) {
+	return f.mem.ListByUser(userID, from, to)
+}
+
+func (f *fileRepository) Search(query SearchQuery) ([]Event, error) {
+	return f.mem.Search(query)
+}
+
+func (f *fileRepository) ListWithReminders(from, to time.Time) ([]Event, error) {
+	return f.mem.ListWithReminders(from, to)
+}
+
+func (f *fileRepository) Reminders() ReminderStore {
+	return f.reminders
+}
+
+func (f *fileRepository) History() HistoryStore {
+	return f.history
+}
+
+func (f *fileRepository) Count() (int, error) {
+	return f.mem.Count()
+}
+
+// Ping проверяет, что в каталог данных можно писать: создает и удаляет пробный файл
+func (f *fileRepository) Ping(ctx context.Context) error {
+	probe, err := os.CreateTemp(f.dir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("data dir is not writable: %w", err)
+	}
+	name := probe.Name()
+	probe.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("data dir is not writable: %w", err)
+	}
+	return nil
+}
+
+// Close останавливает фоновое уплотнение, записывает финальный снимок и закрывает журнал
+func (f *fileRepository) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.done
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.compactLocked()
+	if closeErr := f.journal.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.history.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Compact записывает текущее состояние в снимок и очищает журнал
+func (f *fileRepository) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.compactLocked()
+}
+
+func (f *fileRepository) compactLoop(every time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.Compact(); err != nil {
+				f.logger.Error("compact journal", "error", err)
+			}
+		}
+	}
+}
+
+func (f *fileRepository) compactLocked() error {
+	if f.ops == 0 {
+		return nil
+	}
+
+	// Версии из журнала сохраняются в файл истории до очистки журнала. При сбое между этими шагами
+	// журнал проиграется снова, а уже сохраненные версии будут пропущены.
+	if err := f.history.flush(); err != nil {
+		return err
+	}
+
+	events, nextID := f.mem.snapshot()
+	data, err := json.Marshal(snapshotFile{NextID: nextID, Events: events})
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	// Снимок пишется во временный файл и атомарно подменяет старый,
+	// поэтому при сбое на любом шаге остается согласованная пара снимок + журнал.
+	if err := writeFileSync(f.path(snapshotFileName), data, 0o644); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := f.journal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	f.ops = 0
+	return nil
+}
+
+func (f *fileRepository) appendRecord(record journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode journal record: %w", err)
+	}
+	if _, err := f.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := f.journal.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	f.ops++
+	return nil
+}
+
+func (f *fileRepository) loadSnapshot() error {
+	data, err := os.ReadFile(f.path(snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	f.mem.restore(snapshot.Events, snapshot.NextID)
+	return nil
+}
+
+func (f *fileRepository) replayJournal() error {
+	file, err := os.Open(f.path(journalFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Недописанная последняя строка означает сбой во время записи: операция не была
+			// подтверждена клиенту, поэтому хвост отрезается, чтобы новые записи не склеились с ним.
+			if len(data) > 0 {
+				f.logger.Warn("journal: dropping incomplete record", "line", line)
+				return os.Truncate(f.path(journalFileName), offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read journal: %w", err)
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("decode journal line %d: %w", line, err)
+		}
+		records := []journalRecord{record}
+		if record.Op == opBatch {
+			records = record.Batch
+		}
+		for _, record := range records {
+			if !validRecord(record) {
+				return fmt.Errorf("journal line %d: invalid %q record", line, record.Op)
+			}
+		}
+		for _, record := range records {
+			f.apply(record)
+		}
+		f.ops++
+		offset += int64(len(data))
+	}
+}
+
+func validRecord(record journalRecord) bool {
+	switch record.Op {
+	case opCreate, opUpdate:
+		return record.Event != nil
+	case opDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// apply выполняет операцию журнала над событиями в памяти и добавляет ее версию в историю
+func (f *fileRepository) apply(record journalRecord) {
+	if record.Op == opDelete {
+		f.mem.remove(record.ID)
+	} else {
+		f.mem.put(*record.Event)
+	}
+	if record.History != nil {
+		f.history.add(*record.History)
+	}
+}
+
+func (f *fileRepository) path(name string) string {
+	return filepath.Join(f.dir, name)
+}
+
+// writeFileSync атомарно записывает файл: сначала во временный, затем rename
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// remindersFile — содержимое файла состояния напоминаний
+type remindersFile struct {
+	Watermark time.Time            `json:"watermark"`
+	Delivered map[string]time.Time `json:"delivered"`
+}
+
+// fileReminderStore хранит состояние доставки напоминаний в JSON-файле,
+// который целиком перезаписывается после каждого изменения
+type fileReminderStore struct {
+	*memoryReminderStore
+	path string
+}
+
+func openFileReminderStore(path string) (*fileReminderStore, error) {
+	store := &fileReminderStore{memoryReminderStore: newMemoryReminderStore(), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read reminders state: %w", err)
+	}
+	var state remindersFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse reminders state: %w", err)
+	}
+	store.watermark = state.Watermark
+	for key, fireAt := range state.Delivered {
+		store.delivered[key] = fireAt
+	}
+	return store, nil
+}
+
+func (s *fileReminderStore) MarkDelivered(key string, fireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[key] = fireAt
+	if err := s.saveLocked(); err != nil {
+		delete(s.delivered, key)
+		return err
+	}
+	return nil
+}
+
+func (s *fileReminderStore) Advance(watermark time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advanceLocked(watermark)
+	return s.saveLocked()
+}
+
+func (s *fileReminderStore) saveLocked() error {
+	data, err := json.Marshal(remindersFile{Watermark: s.watermark, Delivered: s.delivered})
+	if err != nil {
+		return fmt.Errorf("encode reminders state: %w", err)
+	}
+	if err := writeFileSync(s.path, data, 0o644); err != nil {
+		return fmt.Errorf("write reminders state: %w", err)
+	}
+	return nil
+}
+
+// fileHistoryStore держит версии событий в памяти и в файле JSON lines. Новая версия сначала
+// попадает в журнал событий вместе с изменением, а в файл дописывается при уплотнении журнала.
+// Версии сверх historyLimit вытесняются из памяти, а их строки остаются в файле; когда таких
+// строк становится не меньше, чем хранимых версий, файл переписывается только с хранимыми.
+// Методы вызываются под блокировкой fileRepository или до начала работы с хранилищем.
+type fileHistoryStore struct {
+	*memoryHistoryStore
+	path    string
+	file    *os.File
+	pending []HistoryEntry // версии из журнала, которых еще нет в файле
+	stale   int            // строки файла с вытесненными или повторенными версиями
+	logger  *slog.Logger
+}
+
+func openFileHistoryStore(path string, logger *slog.Logger) (*fileHistoryStore, error) {
+	store := &fileHistoryStore{memoryHistoryStore: newMemoryHistoryStore(), path: path, logger: logger}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	var offset int
+	for line := 1; offset < len(data); line++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			// Недописанная запись, как и в журнале событий, отрезается
+			logger.Warn("history: dropping incomplete record", "line", line)
+			if err := os.Truncate(path, int64(offset)); err != nil {
+				return nil, fmt.Errorf("truncate history: %w", err)
+			}
+			break
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(data[offset:offset+end], &entry); err != nil {
+			return nil, fmt.Errorf("decode history line %d: %w", line, err)
+		}
+		added, dropped := store.put(entry)
+		if !added {
+			dropped++
+		}
+		store.stale += dropped
+		offset += end + 1
+	}
+
+	if store.stale > 0 && store.stale >= store.size() {
+		if err := store.compact(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	store.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	return store, nil
+}
+
+// add добавляет версию из журнала событий, если ее еще нет в истории
+func (s *fileHistoryStore) add(entry HistoryEntry) {
+	added, dropped := s.put(entry)
+	if added {
+		s.pending = append(s.pending, entry)
+	}
+	// Вытесненная версия, которая еще не в файле, попадет туда при flush и тоже станет лишней строкой
+	s.stale += dropped
+}
+
+// flush дописывает в файл версии из журнала, а если лишних строк набралось не меньше, чем хранимых
+// версий, переписывает файл целиком
+func (s *fileHistoryStore) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if s.stale >= s.size() {
+		return s.compact()
+	}
+	var buf bytes.Buffer
+	for _, entry := range s.pending {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("encode history entry: %w", err)
+		}
+		buf.Write(append(data, '\n'))
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		// Недописанный хвост отрезается, чтобы следующая попытка не склеилась с ним
+		s.file.Truncate(info.Size())
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("sync history: %w", err)
+	}
+	s.pending = nil
+	return nil
+}
+
+// compact атомарно переписывает файл только хранимыми версиями и переоткрывает его
+func (s *fileHistoryStore) compact() error {
+	s.memoryHistoryStore.mu.RLock()
+	ids := make([]int, 0, len(s.entries))
+	for id := range s.entries {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var buf bytes.Buffer
+	for _, id := range ids {
+		for _, entry := range s.entries[id] {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				s.memoryHistoryStore.mu.RUnlock()
+				return fmt.Errorf("encode history entry: %w", err)
+			}
+			buf.Write(append(data, '\n'))
+		}
+	}
+	s.memoryHistoryStore.mu.RUnlock()
+
+	if err := writeFileSync(s.path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("compact history: %w", err)
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.pending = nil
+	s.stale = 0
+	return nil
+}
+
+func (s *fileHistoryStore) close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestFileRepository(t *testing.T, dir string) *fileRepository {
+	t.Helper()
+	repo, err := NewFileRepository(dir, 0, slog.Default())
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии хранилища: %v", err)
+	}
+	return repo.(*fileRepository)
+}
+
+func TestFileRepositoryReplay(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepository(t, dir)
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	first, _ := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "first"})
+	second, _ := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "second"})
+	first.Note = "first, updated"
+	if err := repo.Update(first); err != nil {
+		t.Fatalf("Неожиданная ошибка при изменении: %v", err)
+	}
+	if err := repo.Delete(second.ID); err != nil {
+		t.Fatalf("Неожиданная ошибка при удалении: %v", err)
+	}
+
+	// Журнал еще не уплотнялся: второе хранилище собирает состояние только из него
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); !os.IsNotExist(err) {
+		t.Fatalf("Снимок не должен появиться до уплотнения, получено %v", err)
+	}
+	replayed := openTestFileRepository(t, dir)
+	if event, err := replayed.Get(first.ID); err != nil || event.Note != "first, updated" {
+		t.Errorf("Ожидается измененное событие, получено %+v, %v", event, err)
+	}
+	if _, err := replayed.Get(second.ID); err != ErrEventNotFound {
+		t.Errorf("Удаленное событие не должно восстановиться, получено %v", err)
+	}
+	replayed.Close()
+	repo.Close()
+}
+
+func TestFileRepositoryHistoryReplay(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepository(t, dir)
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	event := Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "v1"}
+	history := func(changeType string) *HistoryEntry { return &HistoryEntry{Type: changeType, ChangedBy: 1} }
+	created, err := writeOne(repo, EventWrite{Op: WriteCreate, Event: event, History: history(ChangeCreated)})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при создании: %v", err)
+	}
+	created.Note = "v2"
+	if _, err := writeOne(repo, EventWrite{Op: WriteUpdate, Event: created, History: history(ChangeUpdated)}); err != nil {
+		t.Fatalf("Неожиданная ошибка при изменении: %v", err)
+	}
+	journal, err := os.ReadFile(filepath.Join(dir, journalFileName))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при чтении журнала: %v", err)
+	}
+	// Сбой после записи истории в файл, но до очистки журнала: журнал проиграется повторно
+	if err := repo.Compact(); err != nil {
+		t.Fatalf("Неожиданная ошибка при уплотнении: %v", err)
+	}
+	repo.Close()
+	if err := os.WriteFile(filepath.Join(dir, journalFileName), journal, 0o644); err != nil {
+		t.Fatalf("Неожиданная ошибка при записи журнала: %v", err)
+	}
+
+	replayed := openTestFileRepository(t, dir)
+	defer replayed.Close()
+	versions, err := replayed.History().List(created.ID)
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("Ожидается 2 версии без повторов, получено %d, %v", len(versions), err)
+	}
+	if versions[0].Version != 1 || versions[1].Version != 2 || versions[1].After.Note != "v2" {
+		t.Errorf("Ожидаются версии 1 и 2 с заметкой v2, получено %+v", versions)
+	}
+}
+
+func TestFileRepositoryCompaction(t *testing.T) {
+	dir := t.TempDir()
+	repo := openTestFileRepository(t, dir)
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if _, err := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour)}); err != nil {
+			t.Fatalf("Неожиданная ошибка при создании: %v", err)
+		}
+	}
+	if err := repo.Delete(3); err != nil {
+		t.Fatalf("Неожиданная ошибка при удалении: %v", err)
+	}
+	if err := repo.Compact(); err != nil {
+		t.Fatalf("Неожиданная ошибка при уплотнении: %v", err)
+	}
+
+	// Снимок подменяется через rename: временный файл не остается, журнал пуст
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("Временный файл снимка должен исчезнуть, получено %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, journalFileName)); err != nil || info.Size() != 0 {
+		t.Errorf("После уплотнения журнал должен быть пуст, получено %v, %v", info, err)
+	}
+	repo.Close()
+
+	// ID удаленного последним события не выдается повторно
+	reopened := openTestFileRepository(t, dir)
+	defer reopened.Close()
+	if n, _ := reopened.Count(); n != 2 {
+		t.Errorf("Ожидается 2 события после перезапуска, получено %d", n)
+	}
+	created, err := reopened.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour)})
+	if err != nil || created.ID != 4 {
+		t.Errorf("Ожидается ID 4, получено %d, %v", created.ID, err)
+	}
+}
+
+func TestFileRepositoryDamagedJournal(t *testing.T) {
+	event := `{"op":"create","id":1,"event":{"id":1,"user_id":1,"start":"2024-05-06T10:00:00Z","end":"2024-05-06T11:00:00Z","time_zone":"UTC","note":"kept"}}` + "\n"
+	tests := []struct {
+		name    string
+		journal string
+		wantErr string
+	}{
+		// Запись оборвалась на середине: операция не была подтверждена, хвост отрезается
+		{"torn last line", event + `{"op":"create","id":2,"event":{"id":2,"us`, ""},
+		{"corrupted line", event + "garbage\n" + event, "decode journal line 2"},
+		{"unknown operation", event + `{"op":"rename","id":1}` + "\n", `invalid "rename" record`},
+		// Набор записей Write — одна строка: оборванный набор отбрасывается целиком
+		{"torn batch", event + `{"op":"batch","id":0,"batch":[{"op":"create","id":2,"event":{"id":2,"user_id":1,"start":"2024-05-06T12:00:00Z","end":"2024-05-06T13:00:00Z","time_zone":"UTC"}},{"op":"del`, ""},
+		{"unknown operation in batch", event + `{"op":"batch","id":0,"batch":[{"op":"delete","id":1},{"op":"rename","id":1}]}` + "\n",
+			`journal line 2: invalid "rename" record`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, journalFileName)
+			if err := os.WriteFile(path, []byte(tt.journal), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			repo, err := NewFileRepository(dir, 0, slog.Default())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Ожидается ошибка %q, получено %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при открытии хранилища: %v", err)
+			}
+			if data, _ := os.ReadFile(path); string(data) != event {
+				t.Errorf("Недописанная строка должна быть отрезана, журнал: %q", data)
+			}
+
+			// Новая запись не склеивается с отрезанным хвостом и читается после перезапуска
+			start := time.Date(2024, 5, 7, 10, 0, 0, 0, time.UTC)
+			created, err := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Note: "after crash"})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при создании: %v", err)
+			}
+			replayed := openTestFileRepository(t, dir)
+			for id, note := range map[int]string{1: "kept", created.ID: "after crash"} {
+				if got, err := replayed.Get(id); err != nil || got.Note != note {
+					t.Errorf("Событие %d: ожидается %q, получено %+v, %v", id, note, got, err)
+				}
+			}
+			replayed.Close()
+			repo.Close()
+		})
+	}
+}
+
+func TestFileHistoryCompaction(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, historyFileName)
+	store, err := openFileHistoryStore(path, slog.Default())
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии истории: %v", err)
+	}
+	lines := func() int {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при чтении истории: %v", err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+	// add добавляет версии так же, как проигрывание журнала событий
+	version := 0
+	add := func(n int) {
+		for i := 0; i < n; i++ {
+			version++
+			store.add(HistoryEntry{EventID: 1, Version: version, Type: ChangeUpdated, ChangedBy: 1, After: &Event{ID: 1, UserID: 1}})
+		}
+		if err := store.flush(); err != nil {
+			t.Fatalf("Неожиданная ошибка при записи: %v", err)
+		}
+	}
+	add(2*historyLimit - 1)
+	if n := lines(); n != 2*historyLimit-1 {
+		t.Fatalf("До уплотнения ожидается %d строк, получено %d", 2*historyLimit-1, n)
+	}
+
+	// Вытесненных строк стало столько же, сколько хранимых версий: файл переписывается
+	add(1)
+	if n := lines(); n != historyLimit {
+		t.Errorf("После уплотнения ожидается %d строк, получено %d", historyLimit, n)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Временный файл истории должен исчезнуть, получено %v", err)
+	}
+	add(1)
+	// Версия из журнала, уже сохраненная в файл до сбоя, не дублируется
+	store.add(HistoryEntry{EventID: 1, Version: version, Type: ChangeUpdated, ChangedBy: 1})
+	if err := store.flush(); err != nil || lines() != historyLimit+1 {
+		t.Errorf("Ожидается %d строк, получено %d, %v", historyLimit+1, lines(), err)
+	}
+	store.close()
+
+	reopened, err := openFileHistoryStore(path, slog.Default())
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии истории: %v", err)
+	}
+	defer reopened.close()
+	history, _ := reopened.List(1)
+	if len(history) != historyLimit || history[0].Version != historyLimit+2 || history[len(history)-1].Version != 2*historyLimit+1 {
+		t.Errorf("Ожидаются версии %d-%d, получено %d версий с %d", historyLimit+2, 2*historyLimit+1, len(history), history[0].Version)
+	}
+	if next := reopened.version(1) + 1; next != 2*historyLimit+2 {
+		t.Errorf("Ожидается версия %d, получено %d", 2*historyLimit+2, next)
+	}
+}
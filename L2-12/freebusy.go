@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Занятость пользователей и поиск общего свободного времени. Наружу выдаются только интервалы
+// занятости без заметок и ID событий, поэтому занятость можно смотреть и в чужих календарях.
+
+const (
+	// maxFreeBusyUsers — сколько пользователей можно передать в одном запросе
+	maxFreeBusyUsers = 50
+	// maxFreeBusyRange — наибольшая длина диапазона запроса
+	maxFreeBusyRange = 62 * 24 * time.Hour
+	// defaultSlotLimit и maxSlotLimit ограничивают число найденных окон
+	defaultSlotLimit = 50
+	maxSlotLimit     = 500
+)
+
+// TimeSlot — полуинтервал времени [Start, End)
+type TimeSlot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// UserBusy — занятое время пользователя
+type UserBusy struct {
+	UserID int        `json:"user_id"`
+	Busy   []TimeSlot `json:"busy"`
+}
+
+// SlotQuery — параметры поиска общего свободного времени
+type SlotQuery struct {
+	UserIDs []int
+	// From и To — диапазон поиска [From, To); рабочие дни считаются в поясе From
+	From, To time.Time
+	// Duration — наименьшая длина окна
+	Duration time.Duration
+	// WorkStart и WorkEnd — начало и конец рабочего дня по местным часам, отсчитанные от полуночи
+	WorkStart, WorkEnd time.Duration
+	// Weekends — искать и в субботу с воскресеньем
+	Weekends bool
+	Limit    int
+}
+
+// validateRange проверяет общие параметры запросов занятости
+func validateRange(userIDs []int, from, to time.Time) error {
+	if len(userIDs) == 0 {
+		return newValidationError("user_id is required")
+	}
+	if len(userIDs) > maxFreeBusyUsers {
+		return newValidationError(fmt.Sprintf("at most %d users per request", maxFreeBusyUsers))
+	}
+	if !from.Before(to) {
+		return newValidationError("invalid date range")
+	}
+	if to.Sub(from) > maxFreeBusyRange {
+		return newValidationError(fmt.Sprintf("date range must not exceed %d days", maxFreeBusyRange/(24*time.Hour)))
+	}
+	return nil
+}
+
+// busyIntervals возвращает отсортированные непересекающиеся интервалы, занятые событиями
+// в пределах [from, to). Повторения уже развернуты; отклоненные приглашения userID не учитываются.
+func busyIntervals(userID int, occurrences []Event, from, to time.Time) []TimeSlot {
+	intervals := make([]TimeSlot, 0, len(occurrences))
+	for _, event := range occurrences {
+		if attendee, ok := event.attendee(userID); ok && attendee.Status == RSVPDeclined {
+			continue
+		}
+		start, end := event.Start, event.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			intervals = append(intervals, TimeSlot{Start: start.In(from.Location()), End: end.In(from.Location())})
+		}
+	}
+	return mergeIntervals(intervals)
+}
+
+// mergeIntervals объединяет пересекающиеся и соприкасающиеся интервалы
+func mergeIntervals(intervals []TimeSlot) []TimeSlot {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start.Before(intervals[j].Start) })
+	merged := []TimeSlot{}
+	for _, interval := range intervals {
+		if last := len(merged) - 1; last >= 0 && !interval.Start.After(merged[last].End) {
+			if interval.End.After(merged[last].End) {
+				merged[last].End = interval.End
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// freeSlots возвращает окна рабочего времени не короче query.Duration, не пересекающиеся с busy.
+// Рабочий день отсчитывается по местным часам, поэтому переход на летнее время его не сдвигает.
+func freeSlots(query SlotQuery, busy []TimeSlot) []TimeSlot {
+	loc := query.From.Location()
+	slots := []TimeSlot{}
+	day := time.Date(query.From.Year(), query.From.Month(), query.From.Day(), 0, 0, 0, 0, loc)
+	for ; day.Before(query.To) && len(slots) < query.Limit; day = day.AddDate(0, 0, 1) {
+		if !query.Weekends && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+			continue
+		}
+		start, end := wallClock(day, query.WorkStart), wallClock(day, query.WorkEnd)
+		if start.Before(query.From) {
+			start = query.From
+		}
+		if end.After(query.To) {
+			end = query.To
+		}
+		for _, interval := range busy {
+			if !start.Before(end) || !interval.Start.Before(end) {
+				break
+			}
+			if interval.End.After(start) {
+				if interval.Start.Sub(start) >= query.Duration {
+					slots = append(slots, TimeSlot{Start: start, End: interval.Start})
+				}
+				start = interval.End
+			}
+		}
+		if end.Sub(start) >= query.Duration {
+			slots = append(slots, TimeSlot{Start: start, End: end})
+		}
+	}
+	if len(slots) > query.Limit {
+		slots = slots[:query.Limit]
+	}
+	return slots
+}
+
+// wallClock возвращает момент, когда в день day (полночь) местные часы показывают offset от полуночи
+func wallClock(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+// parseUserIDs разбирает список пользователей: user_id=1,2 или повторяющийся параметр user_id
+func parseUserIDs(values []string) ([]int, error) {
+	seen := make(map[int]bool)
+	var userIDs []int
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			userID, err := parseUserID(strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			if !seen[userID] {
+				seen[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		}
+	}
+	return userIDs, nil
+}
+
+// parseClock разбирает время рабочего дня вида 09:00; допускается 24:00
+func parseClock(value string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || len(minutes) != 2 || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+//HTTP-обработчики
+
+// parseFreeBusyRange разбирает user_id, from, to и tz — общие параметры запросов занятости
+func (s *server) parseFreeBusyRange(query url.Values) ([]int, time.Time, time.Time, error) {
+	loc, err := parseLocation(query.Get("tz"), s.loc)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	userIDs, err := parseUserIDs(query["user_id"])
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	if query.Get("from") == "" || query.Get("to") == "" {
+		return nil, time.Time{}, time.Time{}, newValidationError("from and to are required")
+	}
+	from, err := parseSearchTime(query.Get("from"), loc)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, newValidationError("invalid from")
+	}
+	to, err := parseSearchTime(query.Get("to"), loc)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, newValidationError("invalid to")
+	}
+	// Время со смещением переводится в tz: по нему считаются рабочие часы
+	return userIDs, from.In(loc), to.In(loc), nil
+}
+
+// Занятость пользователей: user_id — список пользователей, from и to — диапазон [from, to),
+// tz — пояс, в котором разбираются даты и выдаются интервалы. Заметки и ID событий не выдаются.
+func (s *server) freeBusyHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	userIDs, from, to, err := s.parseFreeBusyRange(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	busy, err := s.service.FreeBusy(userIDs, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, busy)
+}
+
+// Поиск общего свободного времени: кроме параметров /free_busy принимает duration — длину встречи,
+// work_start и work_end — рабочие часы (по умолчанию 09:00–18:00), weekends — искать в выходные
+// и limit — сколько окон вернуть
+func (s *server) findSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	params := r.URL.Query()
+	userIDs, from, to, err := s.parseFreeBusyRange(params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := SlotQuery{UserIDs: userIDs, From: from, To: to, WorkStart: 9 * time.Hour, WorkEnd: 18 * time.Hour,
+		Limit: defaultSlotLimit}
+	if query.Duration, err = time.ParseDuration(params.Get("duration")); err != nil || query.Duration <= 0 {
+		writeError(w, newValidationError("duration must be a positive duration, e.g. 30m"))
+		return
+	}
+	if value := params.Get("work_start"); value != "" {
+		if query.WorkStart, err = parseClock(value); err != nil {
+			writeError(w, newValidationError("invalid work_start"))
+			return
+		}
+	}
+	if value := params.Get("work_end"); value != "" {
+		if query.WorkEnd, err = parseClock(value); err != nil {
+			writeError(w, newValidationError("invalid work_end"))
+			return
+		}
+	}
+	if value := params.Get("weekends"); value != "" {
+		if query.Weekends, err = strconv.ParseBool(value); err != nil {
+			writeError(w, newValidationError("invalid weekends"))
+			return
+		}
+	}
+	if value := params.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 1 || query.Limit > maxSlotLimit {
+			writeError(w, newValidationError("limit must be between 1 and "+strconv.Itoa(maxSlotLimit)))
+			return
+		}
+	}
+
+	slots, err := s.service.FindSlots(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, slots)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Пробы для оркестратора: /healthz — процесс жив, /readyz — сервер готов принимать трафик
+
+// readinessTimeout ограничивает проверку хранилища в /readyz
+const readinessTimeout = 2 * time.Second
+
+// Проба живости: отвечает 200, пока процесс обрабатывает запросы, и не трогает хранилище
+func (s *server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeResult(w, "ok")
+}
+
+// Проба готовности: проверяет хранилище и отвечает 503 во время остановки сервера,
+// чтобы балансировщик перестал присылать новые запросы до закрытия соединений
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := s.service.Ping(ctx); err != nil {
+		// Подробности остаются в логе: проба доступна без токена
+		s.logger.Warn("readiness check failed", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "storage unavailable"})
+		return
+	}
+	writeResult(w, "ready")
+}
+
+// shutdown останавливает HTTP-сервер. Сначала сервер объявляет себя неготовым и delay продолжает
+// обслуживать запросы, чтобы оркестратор успел заметить это по /readyz и убрать его из балансировки,
+// затем не дольше timeout дожидается завершения текущих запросов.
+func (s *server) shutdown(httpServer *http.Server, delay, timeout time.Duration) error {
+	s.shuttingDown.Store(true)
+	if delay > 0 {
+		s.logger.Info("readiness switched off, draining", "delay", delay.String())
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		httpServer.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
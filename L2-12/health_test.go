@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadinessDuringShutdownDelay(t *testing.T) {
+	env := newTestEnv(t, nil)
+	const delay = 500 * time.Millisecond
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- env.srv.shutdown(env.Config, delay, time.Second) }()
+
+	// Пока идет задержка, сервер сообщает о неготовности, но продолжает обслуживать запросы
+	for {
+		resp := env.do(t, testRequest{path: "/readyz", as: asAnonymous})
+		if resp.status == http.StatusServiceUnavailable {
+			if !strings.Contains(resp.body, "shutting down") {
+				t.Errorf("Ожидается ошибка shutting down, получено %s", resp.body)
+			}
+			break
+		}
+		if time.Since(started) > delay {
+			t.Fatalf("/readyz не перешел в 503 за время задержки, последний ответ %d", resp.status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp := env.do(t, testRequest{path: "/healthz", as: asAnonymous}); resp.status != http.StatusOK {
+		t.Errorf("Проба живости во время задержки должна отвечать 200, получено %d", resp.status)
+	}
+	resp := env.do(t, testRequest{path: "/events_for_day", query: url.Values{"user_id": {"1"}, "date": {"2024-03-01"}}})
+	if resp.status != http.StatusOK {
+		t.Errorf("Запросы во время задержки должны обслуживаться, получено %d: %s", resp.status, resp.body)
+	}
+	select {
+	case <-done:
+		t.Fatal("Сервер остановился раньше окончания задержки")
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Неожиданная ошибка при остановке: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < delay {
+		t.Errorf("Остановка заняла %v, меньше задержки %v", elapsed, delay)
+	}
+	// После остановки новые соединения не принимаются
+	if resp, err := http.Get(env.URL + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Error("После остановки сервер не должен принимать соединения")
+	}
+}
+
+// shutdownRepository задерживает чтение событий дня до release и отмечает обращения
+// к хранилищу после Close
+type shutdownRepository struct {
+	EventRepository
+	entered        chan struct{}
+	release        chan struct{}
+	reminderReads  atomic.Int32
+	closed         atomic.Bool
+	usedAfterClose atomic.Bool
+	enterOnce      sync.Once
+}
+
+func (r *shutdownRepository) ListByUser(userID int, from, to time.Time) ([]Event, error) {
+	r.enterOnce.Do(func() { close(r.entered) })
+	<-r.release
+	r.check()
+	return r.EventRepository.ListByUser(userID, from, to)
+}
+
+func (r *shutdownRepository) ListWithReminders(from, to time.Time) ([]Event, error) {
+	r.check()
+	r.reminderReads.Add(1)
+	return r.EventRepository.ListWithReminders(from, to)
+}
+
+func (r *shutdownRepository) Close() error {
+	r.closed.Store(true)
+	return r.EventRepository.Close()
+}
+
+func (r *shutdownRepository) check() {
+	if r.closed.Load() {
+		r.usedAfterClose.Store(true)
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.RateLimit.Enabled = false
+	cfg.Auth.Enabled = false
+	cfg.Webhooks.File = filepath.Join(dir, "webhooks.json")
+	cfg.Reminders.Enabled = true
+	cfg.Reminders.Interval = Duration(5 * time.Millisecond)
+	cfg.Timeouts.ShutdownDelay = Duration(200 * time.Millisecond)
+	cfg.Timeouts.Shutdown = Duration(5 * time.Second)
+	repo := &shutdownRepository{EventRepository: NewMemoryRepository(),
+		entered: make(chan struct{}), release: make(chan struct{})}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии порта: %v", err)
+	}
+	base := "http://" + listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, cfg, repo, listener) }()
+
+	get := func(path string) (int, string, error) {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	// Планировщик напоминаний должен успеть поработать с хранилищем до остановки
+	for deadline := time.Now().Add(2 * time.Second); repo.reminderReads.Load() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("Планировщик напоминаний не обратился к хранилищу")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		status, body, err := get("/events_for_day?user_id=1&date=2024-03-01")
+		slow <- result{status, body, err}
+	}()
+	<-repo.entered
+	cancel()
+
+	// Во время задержки сервер сообщает о неготовности и принимает новые соединения
+	for deadline := time.Now().Add(time.Duration(cfg.Timeouts.ShutdownDelay)); ; {
+		status, _, err := get("/readyz")
+		if err == nil && status == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("/readyz не перешел в 503 за время задержки: %d, %v", status, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Сервер остановился, не дождавшись текущего запроса: %v", err)
+	case <-time.After(time.Duration(cfg.Timeouts.ShutdownDelay)):
+	}
+
+	// Текущий запрос дорабатывает до конца, и только потом закрывается хранилище
+	close(repo.release)
+	res := <-slow
+	if res.err != nil || res.status != http.StatusOK {
+		t.Fatalf("Запрос во время остановки должен завершиться с 200, получено %d, %v: %s", res.status, res.err, res.body)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при остановке: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Сервер не остановился")
+	}
+	if !repo.closed.Load() {
+		t.Error("После остановки хранилище должно быть закрыто")
+	}
+	if repo.usedAfterClose.Load() {
+		t.Error("Обработчики и фоновые задачи не должны обращаться к хранилищу после Close")
+	}
+	if _, _, err := get("/healthz"); err == nil {
+		t.Error("После остановки сервер не должен принимать соединения")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// История изменений: каждое создание, изменение, ответ на приглашение и удаление события
+// записывается новой версией с состоянием до и после. По версии событие можно откатить,
+// в том числе восстановить удаленное. Сам откат тоже записывается новой версией.
+// Хранятся только последние historyLimit версий каждого события, номера при этом не сдвигаются.
+
+// historyLimit — сколько последних версий события хранится в истории
+const historyLimit = 100
+
+var (
+	// ErrVersionNotFound возвращается, если у события нет версии с таким номером
+	ErrVersionNotFound = newDomainError("version not found")
+	// ErrEventExists возвращается при восстановлении события, ID которого уже занят
+	ErrEventExists = newDomainError("event already exists")
+)
+
+// HistoryEntry — версия события
+type HistoryEntry struct {
+	EventID int `json:"event_id"`
+	// Version — номер версии, у каждого события начинается с 1
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	// ChangedBy — пользователь, выполнивший изменение: владелец или ответивший приглашенный
+	ChangedBy int       `json:"changed_by"`
+	At        time.Time `json:"at"`
+	// Before — событие до изменения, nil при создании
+	Before *Event `json:"before,omitempty"`
+	// After — событие после изменения, nil при удалении
+	After *Event `json:"after,omitempty"`
+	// RevertedTo — номер версии, к которой откатили событие; 0, если это не откат
+	RevertedTo int `json:"reverted_to,omitempty"`
+}
+
+// owner возвращает владельца события в этой версии
+func (h HistoryEntry) owner() int {
+	if h.After != nil {
+		return h.After.UserID
+	}
+	return h.Before.UserID
+}
+
+// HistoryStore хранит историю изменений событий. История удаленного события сохраняется.
+// Версии записываются хранилищем событий вместе с изменением (EventWrite.History),
+// поэтому сохраненное изменение не может остаться без версии.
+type HistoryStore interface {
+	// List возвращает сохраненные версии события по возрастанию номера
+	List(eventID int) ([]HistoryEntry, error)
+}
+
+// memoryHistoryStore хранит историю в памяти процесса
+type memoryHistoryStore struct {
+	mu      sync.RWMutex
+	entries map[int][]HistoryEntry // ID события -> версии
+}
+
+func newMemoryHistoryStore() *memoryHistoryStore {
+	return &memoryHistoryStore{entries: make(map[int][]HistoryEntry)}
+}
+
+// record присваивает записи следующий номер версии события и сохраняет ее
+func (m *memoryHistoryStore) record(entry HistoryEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.Version = lastVersion(m.entries[entry.EventID]) + 1
+	m.add(entry)
+}
+
+func (m *memoryHistoryStore) List(eventID int) ([]HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]HistoryEntry{}, m.entries[eventID]...), nil
+}
+
+// version возвращает номер последней версии события
+func (m *memoryHistoryStore) version(eventID int) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return lastVersion(m.entries[eventID])
+}
+
+// put добавляет запись с уже присвоенной версией (используется при загрузке с диска)
+// и возвращает, добавлена ли она и сколько старых версий при этом вытеснено.
+// Версия, которая уже есть в истории, пропускается.
+func (m *memoryHistoryStore) put(entry HistoryEntry) (bool, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry.Version <= lastVersion(m.entries[entry.EventID]) {
+		return false, 0
+	}
+	return true, m.add(entry)
+}
+
+// size возвращает число хранимых версий всех событий
+func (m *memoryHistoryStore) size() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var n int
+	for _, versions := range m.entries {
+		n += len(versions)
+	}
+	return n
+}
+
+// add дописывает версию и отбрасывает самые старые сверх historyLimit; вызывается под m.mu
+func (m *memoryHistoryStore) add(entry HistoryEntry) int {
+	versions := append(m.entries[entry.EventID], entry)
+	dropped := max(len(versions)-historyLimit, 0)
+	m.entries[entry.EventID] = versions[dropped:]
+	return dropped
+}
+
+func lastVersion(versions []HistoryEntry) int {
+	if len(versions) == 0 {
+		return 0
+	}
+	return versions[len(versions)-1].Version
+}
+
+// historyEntry дополняет версию, сохраняемую вместе с записью op, ID события и его состоянием
+// после записи (nil для удаления). Номер версии присваивает хранилище.
+func historyEntry(history HistoryEntry, op string, event Event) HistoryEntry {
+	history.EventID = event.ID
+	history.After = nil
+	if op != WriteDelete {
+		history.After = versionOf(event)
+	}
+	return history
+}
+
+// versionOf возвращает копию события для записи в историю: без вычисляемых полей
+func versionOf(event Event) *Event {
+	event.Conflicts = nil
+	event.Responses = nil
+	return &event
+}
+
+//HTTP-обработчики
+
+// История изменений события: id и user_id владельца
+func (s *server) eventHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	params := r.URL.Query()
+	id, userID, err := validateIDParams(params.Get("id"), params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	history, err := s.service.EventHistory(id, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, history)
+}
+
+// Откат события к версии version: событие принимает состояние после этой версии
+func (s *server) revertEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	params, err := readParams(w, r, s.maxBodyBytes, "id", "user_id", "version")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, userID, err := validateIDParams(params.Get("id"), params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := strconv.Atoi(params.Get("version"))
+	if err != nil || version < 1 {
+		writeError(w, newValidationError("invalid version"))
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	event, err := s.service.RevertEvent(id, userID, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, event)
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Формат iCalendar (RFC 5545): экспорт событий пользователя и импорт из .ics-файлов
+
+const (
+	icsContentType  = "text/calendar; charset=utf-8"
+	icsProductID    = "-//wb-l2//calendar L2.12//RU"
+	icsMaxLineBytes = 75
+	icsMaxBodyBytes = 4 << 20
+
+	icsDateLayout  = "20060102"
+	icsLocalLayout = "20060102T150405"
+	icsUTCLayout   = "20060102T150405Z"
+
+	// icsExportUIDFormat — UID, который экспорт присваивает событиям, созданным не импортом
+	icsExportUIDFormat = "event-%d-user-%d@wb-l2"
+	// icsTimeZoneYears — на сколько лет вперед описываются переходы часовых поясов
+	// для повторяющихся событий без UNTIL
+	icsTimeZoneYears = 10
+)
+
+// writeICS записывает события в формате iCalendar.
+// Повторяющиеся события выгружаются одной записью с RRULE и EXDATE, а для каждого часового пояса
+// событий со временем добавляется компонент VTIMEZONE с его переходами на период событий.
+func writeICS(w io.Writer, events []Event, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	line := func(s string) {
+		writeICSLine(bw, s)
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:" + icsProductID)
+	line("CALSCALE:GREGORIAN")
+	for _, zone := range icsTimeZones(events, now) {
+		writeVTimezone(line, zone.loc, zone.from, zone.to)
+	}
+	for _, event := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + escapeICSText(exportUID(event)))
+		line("DTSTAMP:" + now.UTC().Format("20060102T150405Z"))
+		if event.AllDay {
+			line("DTSTART;VALUE=DATE:" + event.Start.Format(icsDateLayout))
+			line("DTEND;VALUE=DATE:" + event.End.In(event.Start.Location()).Format(icsDateLayout))
+		} else {
+			line("DTSTART" + icsDateTime(event.Start))
+			line("DTEND" + icsDateTime(event.End.In(event.Start.Location())))
+		}
+		line("SUMMARY:" + escapeICSText(event.Note))
+		if rec := event.Recurrence; rec != nil {
+			line("RRULE:" + icsRRule(event))
+			if len(rec.Exceptions) > 0 {
+				if event.AllDay {
+					dates := make([]string, len(rec.Exceptions))
+					for i, ex := range rec.Exceptions {
+						dates[i] = ex.In(event.Start.Location()).Format(icsDateLayout)
+					}
+					line("EXDATE;VALUE=DATE:" + strings.Join(dates, ","))
+				} else {
+					for _, ex := range rec.Exceptions {
+						line("EXDATE" + icsDateTime(ex.In(event.Start.Location())))
+					}
+				}
+			}
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+// exportUID возвращает UID события в .ics
+func exportUID(event Event) string {
+	if event.UID != "" {
+		return event.UID
+	}
+	return fmt.Sprintf(icsExportUIDFormat, event.ID, event.UserID)
+}
+
+// parseExportUID возвращает ID события по UID, который экспорт присвоил событию пользователя userID
+func parseExportUID(uid string, userID int) (int, bool) {
+	var id, owner int
+	if _, err := fmt.Sscanf(uid, icsExportUIDFormat, &id, &owner); err != nil {
+		return 0, false
+	}
+	return id, owner == userID && fmt.Sprintf(icsExportUIDFormat, id, owner) == uid
+}
+
+// icsZone — часовой пояс событий и период, на который нужно описать его переходы
+type icsZone struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// icsTimeZones собирает часовые пояса событий со временем в порядке первого появления
+func icsTimeZones(events []Event, now time.Time) []icsZone {
+	var zones []icsZone
+	index := make(map[string]int)
+	for _, event := range events {
+		loc := event.Start.Location()
+		if event.AllDay || isUTC(loc) {
+			continue
+		}
+		to := event.End
+		if rec := event.Recurrence; rec != nil {
+			if rec.Until != nil {
+				to = rec.lastEnd(event.Duration())
+			} else {
+				to = now.AddDate(icsTimeZoneYears, 0, 0)
+				if event.Start.After(now) {
+					to = event.Start.AddDate(icsTimeZoneYears, 0, 0)
+				}
+			}
+		}
+		i, ok := index[loc.String()]
+		if !ok {
+			index[loc.String()] = len(zones)
+			zones = append(zones, icsZone{loc: loc, from: event.Start, to: to})
+			continue
+		}
+		if event.Start.Before(zones[i].from) {
+			zones[i].from = event.Start
+		}
+		if to.After(zones[i].to) {
+			zones[i].to = to
+		}
+	}
+	return zones
+}
+
+// icsObservance — период действия одного смещения часового пояса (STANDARD или DAYLIGHT)
+type icsObservance struct {
+	daylight   bool
+	offsetFrom int
+	offsetTo   int
+	name       string
+}
+
+// writeVTimezone записывает VTIMEZONE с переходами пояса loc, действующими в [from, to].
+// Переходы с одинаковыми смещениями объединяются в один компонент с RDATE.
+func writeVTimezone(line func(string), loc *time.Location, from, to time.Time) {
+	var order []icsObservance
+	onsets := make(map[icsObservance][]time.Time)
+	t := from.In(loc)
+	for i := 0; i < 1000; i++ {
+		name, offset := t.Zone()
+		start, end := t.ZoneBounds()
+		obs := icsObservance{daylight: t.IsDST(), offsetFrom: offset, offsetTo: offset, name: name}
+		if start.IsZero() {
+			// У пояса нет переходов до этого момента: смещение действует с начала эпохи
+			start = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Duration(offset) * time.Second)
+		} else {
+			_, obs.offsetFrom = start.Add(-time.Second).Zone()
+		}
+		if _, ok := onsets[obs]; !ok {
+			order = append(order, obs)
+		}
+		onsets[obs] = append(onsets[obs], start)
+		if end.IsZero() || end.After(to) {
+			break
+		}
+		t = end.In(loc)
+	}
+
+	line("BEGIN:VTIMEZONE")
+	line("TZID:" + loc.String())
+	for _, obs := range order {
+		kind := "STANDARD"
+		if obs.daylight {
+			kind = "DAYLIGHT"
+		}
+		// Начало периода записывается по местному времени до перехода
+		local := func(onset time.Time) string {
+			return onset.UTC().Add(time.Duration(obs.offsetFrom) * time.Second).Format(icsLocalLayout)
+		}
+		line("BEGIN:" + kind)
+		line("DTSTART:" + local(onsets[obs][0]))
+		if rest := onsets[obs][1:]; len(rest) > 0 {
+			dates := make([]string, len(rest))
+			for i, onset := range rest {
+				dates[i] = local(onset)
+			}
+			line("RDATE:" + strings.Join(dates, ","))
+		}
+		line("TZOFFSETFROM:" + icsOffset(obs.offsetFrom))
+		line("TZOFFSETTO:" + icsOffset(obs.offsetTo))
+		line("TZNAME:" + escapeICSText(obs.name))
+		line("END:" + kind)
+	}
+	line("END:VTIMEZONE")
+}
+
+// icsOffset форматирует смещение от UTC в виде +hhmm или +hhmmss
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
+
+func isUTC(loc *time.Location) bool {
+	return loc == time.UTC || loc.String() == "UTC"
+}
+
+// icsDateTime форматирует время с параметром TZID или в UTC, включая разделитель ':'
+func icsDateTime(t time.Time) string {
+	if isUTC(t.Location()) {
+		return ":" + t.UTC().Format(icsUTCLayout)
+	}
+	return ";TZID=" + t.Location().String() + ":" + t.Format(icsLocalLayout)
+}
+
+// icsRRule возвращает правило повторения события. UNTIL по RFC 5545 должен быть того же типа,
+// что DTSTART: датой для событий на весь день и моментом в UTC для событий со временем.
+func icsRRule(event Event) string {
+	rec := *event.Recurrence
+	if rec.Until == nil {
+		return rec.String()
+	}
+	until := *rec.Until
+	rec.Until = nil
+	if event.AllDay {
+		return rec.String() + ";UNTIL=" + until.In(event.Start.Location()).Format(icsDateLayout)
+	}
+	return rec.String() + ";UNTIL=" + until.UTC().Format(icsUTCLayout)
+}
+
+// writeICSLine записывает строку, перенося ее по 75 байт, как требует RFC 5545
+func writeICSLine(w *bufio.Writer, s string) {
+	limit := icsMaxLineBytes
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		limit = icsMaxLineBytes - 1 // пробел в начале строки продолжения тоже считается
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICSText(s string) string {
+	return icsTextEscaper.Replace(s)
+}
+
+func unescapeICSText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// icsProperty — разобранная строка содержимого вида NAME;PARAM=VALUE:value
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseICS разбирает iCalendar-документ и возвращает события из компонентов VEVENT.
+// Время без часового пояса и даты интерпретируются в loc. Измененное повторение (VEVENT
+// с RECURRENCE-ID) становится отдельным событием, а в правило основного события
+// добавляется исключение.
+func parseICS(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfoldICSLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var overrides []icsOverride
+	var current []icsProperty
+	depth := 0 // вложенность компонентов внутри VEVENT (VALARM и т.п.)
+	inEvent := false
+	for n, raw := range lines {
+		if raw == "" {
+			continue
+		}
+		prop, err := parseICSProperty(raw)
+		if err != nil {
+			return nil, newValidationError(fmt.Sprintf("ics line %d: %v", n+1, err))
+		}
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT") && !inEvent:
+			inEvent, current = true, nil
+		case prop.name == "BEGIN" && inEvent:
+			depth++
+		case prop.name == "END" && inEvent && depth > 0:
+			depth--
+		case prop.name == "END" && inEvent && strings.EqualFold(prop.value, "VEVENT"):
+			event, recurrenceID, err := icsEvent(current, loc)
+			if err != nil {
+				return nil, newValidationError(fmt.Sprintf("ics event ending at line %d: %v", n+1, err))
+			}
+			if recurrenceID != nil {
+				overrides = append(overrides, icsOverride{index: len(events), uid: event.UID, recurrenceID: *recurrenceID})
+				event.UID += "/" + recurrenceID.UTC().Format(icsUTCLayout)
+			}
+			events = append(events, event)
+			inEvent = false
+		case inEvent && depth == 0:
+			current = append(current, prop)
+		}
+	}
+	if inEvent {
+		return nil, newValidationError("ics: unterminated VEVENT")
+	}
+
+	for _, override := range overrides {
+		for i := range events {
+			if i != override.index && events[i].UID == override.uid && events[i].Recurrence != nil {
+				events[i].Recurrence.Exceptions = append(events[i].Recurrence.Exceptions, override.recurrenceID)
+			}
+		}
+	}
+	return events, nil
+}
+
+// icsOverride — измененное повторение события с UID uid, заменяющее повторение в recurrenceID
+type icsOverride struct {
+	index        int
+	uid          string
+	recurrenceID time.Time
+}
+
+// unfoldICSLines читает строки документа, склеивая перенесенные строки продолжения
+func unfoldICSLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), icsMaxBodyBytes)
+	var lines []string
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			lines[len(lines)-1] += text[1:]
+			continue
+		}
+		lines = append(lines, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, newValidationError(fmt.Sprintf("read ics: %v", err))
+	}
+	return lines, nil
+}
+
+func parseICSProperty(line string) (icsProperty, error) {
+	// Двоеточие внутри значения параметра в кавычках не разделяет имя и значение
+	inQuotes := false
+	sep := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuotes = !inQuotes
+		}
+		if c == ':' && !inQuotes {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return icsProperty{}, fmt.Errorf("missing ':' in %q", line)
+	}
+
+	parts := strings.Split(line[:sep], ";")
+	prop := icsProperty{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[sep+1:]}
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return prop, nil
+}
+
+// icsEvent собирает событие из свойств VEVENT и возвращает его RECURRENCE-ID, если он есть.
+// Без DTEND и DURATION событие на дату длится один день, а событие со временем — час.
+func icsEvent(props []icsProperty, loc *time.Location) (Event, *time.Time, error) {
+	var event Event
+	var rrule string
+	var dtend, duration, exdates, recurrenceID []icsProperty
+	hasStart := false
+	for _, prop := range props {
+		switch prop.name {
+		case "UID":
+			event.UID = strings.TrimSpace(unescapeICSText(prop.value))
+		case "DTSTART":
+			start, allDay, err := parseICSTime(prop, loc)
+			if err != nil {
+				return Event{}, nil, fmt.Errorf("DTSTART: %w", err)
+			}
+			event.Start, event.AllDay, event.TimeZone = start, allDay, start.Location().String()
+			hasStart = true
+		case "RECURRENCE-ID":
+			recurrenceID = append(recurrenceID, prop)
+		case "DTEND":
+			dtend = append(dtend, prop)
+		case "DURATION":
+			duration = append(duration, prop)
+		case "SUMMARY":
+			event.Note = unescapeICSText(prop.value)
+		case "DESCRIPTION":
+			if event.Note == "" {
+				event.Note = unescapeICSText(prop.value)
+			}
+		case "RRULE":
+			rrule = prop.value
+		case "EXDATE":
+			exdates = append(exdates, prop)
+		}
+	}
+	if !hasStart {
+		return Event{}, nil, fmt.Errorf("DTSTART is required")
+	}
+
+	switch {
+	case len(dtend) > 0:
+		end, _, err := parseICSTime(dtend[0], event.Start.Location())
+		if err != nil {
+			return Event{}, nil, fmt.Errorf("DTEND: %w", err)
+		}
+		event.End = end.In(event.Start.Location())
+	case len(duration) > 0:
+		days, d, err := parseICSDuration(duration[0].value)
+		if err != nil {
+			return Event{}, nil, fmt.Errorf("DURATION: %w", err)
+		}
+		event.End = event.Start.AddDate(0, 0, days).Add(d)
+	case event.AllDay:
+		event.End = event.Start.AddDate(0, 0, 1)
+	default:
+		event.End = event.Start.Add(time.Hour)
+	}
+
+	var exceptions []string
+	for _, prop := range exdates {
+		for _, value := range strings.Split(prop.value, ",") {
+			ex, isDate, err := parseICSTime(icsProperty{params: prop.params, value: value}, event.Start.Location())
+			if err != nil {
+				return Event{}, nil, fmt.Errorf("EXDATE: %w", err)
+			}
+			if isDate {
+				exceptions = append(exceptions, ex.Format(icsDateLayout))
+			} else {
+				exceptions = append(exceptions, ex.UTC().Format(icsUTCLayout))
+			}
+		}
+	}
+	rec, err := parseRecurrence(rrule, strings.Join(exceptions, ","), event.Start)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	event.Recurrence = rec
+
+	if len(recurrenceID) == 0 {
+		return event, nil, nil
+	}
+	if event.UID == "" || rec != nil {
+		return Event{}, nil, fmt.Errorf("RECURRENCE-ID requires UID and no RRULE")
+	}
+	at, _, err := parseICSTime(recurrenceID[0], event.Start.Location())
+	if err != nil {
+		return Event{}, nil, fmt.Errorf("RECURRENCE-ID: %w", err)
+	}
+	return event, &at, nil
+}
+
+// parseICSTime разбирает DATE или DATE-TIME с учетом параметров VALUE и TZID.
+// Второй результат сообщает, что значение — дата без времени.
+func parseICSTime(prop icsProperty, loc *time.Location) (time.Time, bool, error) {
+	value := strings.TrimSpace(prop.value)
+	if prop.params["VALUE"] == "DATE" || len(value) == len(icsDateLayout) {
+		t, err := time.ParseInLocation(icsDateLayout, value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icsUTCLayout, value)
+		return t, false, err
+	}
+	if tzid := prop.params["TZID"]; tzid != "" {
+		// Поддерживаются только пояса IANA: описание нестандартного пояса в VTIMEZONE не разбирается
+		tz, err := loadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown TZID %q", tzid)
+		}
+		loc = tz
+	}
+	t, err := time.ParseInLocation(icsLocalLayout, value, loc)
+	return t, false, err
+}
+
+// parseICSDuration разбирает длительность RFC 5545 вида P1W, P2D, PT1H30M, P1DT12H.
+// Недели и дни возвращаются отдельно: они считаются в календарных днях.
+func parseICSDuration(value string) (int, time.Duration, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(value), "+")
+	if strings.HasPrefix(s, "-") {
+		return 0, 0, fmt.Errorf("negative duration %q", value)
+	}
+	s, ok := strings.CutPrefix(s, "P")
+	if !ok || s == "" {
+		return 0, 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var days int
+	var d time.Duration
+	inTime := false
+	num := 0
+	digits := false
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			num = num*10 + int(c-'0')
+			digits = true
+			continue
+		case c == 'T' && !inTime && !digits:
+			inTime = true
+			continue
+		}
+		if !digits {
+			return 0, 0, fmt.Errorf("invalid duration %q", value)
+		}
+		switch {
+		case c == 'W' && !inTime:
+			days += 7 * num
+		case c == 'D' && !inTime:
+			days += num
+		case c == 'H' && inTime:
+			d += time.Duration(num) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(num) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(num) * time.Second
+		default:
+			return 0, 0, fmt.Errorf("invalid duration %q", value)
+		}
+		num, digits = 0, false
+	}
+	if digits {
+		return 0, 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return days, d, nil
+}
+
+//HTTP-обработчики
+
+// Экспорт событий пользователя в iCalendar.
+// Необязательные from и to (включительно) ограничивают выгрузку событиями, попадающими в диапазон.
+func (s *server) exportICSHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	userID, err := parseUserID(query.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	from, to, err := s.parseOptionalRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	events, err := s.service.ListEvents(userID, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", icsContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="calendar-%d.ics"`, userID))
+	writeICS(w, events, time.Now())
+}
+
+// Импорт событий из iCalendar. Файл передается телом запроса (text/calendar)
+// или полем file формы multipart/form-data, user_id — в queryString или в форме.
+// Событие с UID, уже импортированным или выданным экспортом, обновляется вместо создания копии;
+// если хоть одно событие файла не проходит проверку, не сохраняется ни одно.
+func (s *server) importICSHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, icsMaxBodyBytes)
+	var body io.Reader = r.Body
+	userIDStr := r.URL.Query().Get("user_id")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, newValidationError("multipart field \"file\" is required"))
+			return
+		}
+		defer file.Close()
+		body = file
+		userIDStr = r.FormValue("user_id")
+	}
+
+	userID, err := parseUserID(userIDStr)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	parsed, err := parseICS(body, s.loc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	imported, err := s.service.ImportEvents(userID, parsed)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, imported)
+}
+
+// parseOptionalRange разбирает необязательный диапазон дат; конец диапазона включительно
+func (s *server) parseOptionalRange(fromStr, toStr string) (time.Time, time.Time, error) {
+	from, to := minTime, maxTime
+	var err error
+	if fromStr != "" {
+		if from, err = time.ParseInLocation("2006-01-02", fromStr, s.loc); err != nil {
+			return time.Time{}, time.Time{}, newValidationError("invalid from date")
+		}
+	}
+	if toStr != "" {
+		if to, err = time.ParseInLocation("2006-01-02", toStr, s.loc); err != nil {
+			return time.Time{}, time.Time{}, newValidationError("invalid to date")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
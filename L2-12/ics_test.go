@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// icsDocument собирает календарь из строк содержимого с разделителями CRLF
+func icsDocument(lines ...string) string {
+	all := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0"}, lines...)
+	return strings.Join(append(all, "END:VCALENDAR"), "\r\n") + "\r\n"
+}
+
+func TestParseICS(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Нет базы часовых поясов: %v", err)
+	}
+	moscow := time.FixedZone("MSK", 3*60*60)
+
+	tests := []struct {
+		name  string
+		lines []string
+		check func(t *testing.T, events []Event)
+	}{
+		{"tzid and duration", []string{"BEGIN:VEVENT", "UID:standup@example.com", "DTSTART;TZID=Europe/Berlin:20240310T090000",
+			"DURATION:PT1H30M", "SUMMARY:standup", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				event := events[0]
+				if !event.Start.Equal(time.Date(2024, 3, 10, 9, 0, 0, 0, berlin)) || event.End.Sub(event.Start) != 90*time.Minute ||
+					event.TimeZone != "Europe/Berlin" || event.UID != "standup@example.com" || event.AllDay {
+					t.Errorf("Неожиданное событие %+v", event)
+				}
+			}},
+		{"utc time without end", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				event := events[0]
+				if !event.Start.Equal(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)) || event.End.Sub(event.Start) != time.Hour {
+					t.Errorf("Событие без DTEND должно длиться час, получено %+v", event)
+				}
+			}},
+		{"all day", []string{"BEGIN:VEVENT", "DTSTART;VALUE=DATE:20240301", "END:VEVENT",
+			"BEGIN:VEVENT", "DTSTART;VALUE=DATE:20240305", "DURATION:P1W", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				day := time.Date(2024, 3, 1, 0, 0, 0, 0, moscow)
+				if !events[0].AllDay || !events[0].Start.Equal(day) || !events[0].End.Equal(day.AddDate(0, 0, 1)) {
+					t.Errorf("Ожидается событие на 1 марта по Москве, получено %+v", events[0])
+				}
+				if !events[1].End.Equal(time.Date(2024, 3, 12, 0, 0, 0, 0, moscow)) {
+					t.Errorf("Ожидается событие на неделю, получено %+v", events[1])
+				}
+			}},
+		{"exdate at occurrence time", []string{"BEGIN:VEVENT", "DTSTART;TZID=Europe/Berlin:20240310T090000",
+			"RRULE:FREQ=DAILY;UNTIL=20240313T080000Z", "EXDATE;TZID=Europe/Berlin:20240311T090000,20240312T090000", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				event := events[0]
+				got := event.Recurrence.Occurrences(event.Start, event.Start, event.Start.AddDate(0, 0, 10))
+				want := []time.Time{time.Date(2024, 3, 10, 9, 0, 0, 0, berlin), time.Date(2024, 3, 13, 9, 0, 0, 0, berlin)}
+				if len(got) != len(want) || !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+					t.Errorf("Ожидаются повторения %v, получено %v", want, got)
+				}
+			}},
+		{"folded and escaped text", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z",
+			"SUMMARY:Планерка\\, затем ретро", " спектива\\; кофе\\nи вопросы", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				if want := "Планерка, затем ретроспектива; кофе\nи вопросы"; events[0].Note != want {
+					t.Errorf("Ожидается заметка %q, получено %q", want, events[0].Note)
+				}
+			}},
+		{"nested alarm is ignored", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z", "SUMMARY:event",
+			"BEGIN:VALARM", "TRIGGER:-PT15M", "DESCRIPTION:alarm", "END:VALARM", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				if len(events) != 1 || events[0].Note != "event" {
+					t.Errorf("Ожидается одно событие event, получено %+v", events)
+				}
+			}},
+		{"recurrence override", []string{"BEGIN:VEVENT", "UID:weekly", "DTSTART:20240301T090000Z", "RRULE:FREQ=WEEKLY", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:weekly", "RECURRENCE-ID:20240308T090000Z", "DTSTART:20240308T130000Z", "SUMMARY:moved", "END:VEVENT"},
+			func(t *testing.T, events []Event) {
+				moved := time.Date(2024, 3, 8, 9, 0, 0, 0, time.UTC)
+				if len(events[0].Recurrence.Exceptions) != 1 || !events[0].Recurrence.Exceptions[0].Equal(moved) {
+					t.Errorf("Измененное повторение должно стать исключением, получено %+v", events[0].Recurrence)
+				}
+				if events[1].UID != "weekly/20240308T090000Z" || events[1].Recurrence != nil || events[1].Start.Hour() != 13 {
+					t.Errorf("Измененное повторение должно стать отдельным событием, получено %+v", events[1])
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := parseICS(strings.NewReader(icsDocument(tt.lines...)), moscow)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка: %v", err)
+			}
+			tt.check(t, events)
+		})
+	}
+}
+
+func TestParseICSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{"unknown tzid", []string{"BEGIN:VEVENT", "DTSTART;TZID=W. Europe Standard Time:20240301T090000", "END:VEVENT"}, `unknown TZID "W. Europe Standard Time"`},
+		{"missing dtstart", []string{"BEGIN:VEVENT", "SUMMARY:no start", "END:VEVENT"}, "DTSTART is required"},
+		{"negative duration", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z", "DURATION:-PT1H", "END:VEVENT"}, "negative duration"},
+		{"invalid duration", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z", "DURATION:PT1H30", "END:VEVENT"}, "invalid duration"},
+		{"unterminated event", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z"}, "unterminated VEVENT"},
+		{"line without colon", []string{"BEGIN:VEVENT", "DTSTART", "END:VEVENT"}, "missing ':'"},
+		{"unsupported rrule", []string{"BEGIN:VEVENT", "DTSTART:20240301T090000Z", "RRULE:FREQ=HOURLY", "END:VEVENT"}, "unsupported rrule FREQ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseICS(strings.NewReader(icsDocument(tt.lines...)), time.UTC)
+			if errorStatus(err) != http.StatusBadRequest || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Ожидается ошибка валидации %q, получено %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWriteICS(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Нет базы часовых поясов: %v", err)
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, berlin)
+	events := []Event{
+		{ID: 1, UserID: 1, Start: start, End: start.Add(time.Hour), TimeZone: "Europe/Berlin",
+			Note: strings.Repeat("Длинная заметка о встрече, ", 5), Recurrence: &Recurrence{Freq: FreqWeekly}},
+		{ID: 2, UserID: 1, Start: now, End: now.Add(time.Hour), TimeZone: "UTC", Note: "utc"},
+	}
+	var buf bytes.Buffer
+	if err := writeICS(&buf, events, now); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	doc := buf.String()
+
+	// Строки длиннее 75 байт переносятся, не разрезая символы UTF-8
+	for _, line := range strings.Split(strings.TrimSuffix(doc, "\r\n"), "\r\n") {
+		if len(line) > icsMaxLineBytes || !utf8.ValidString(line) {
+			t.Errorf("Строка длиной %d байт перенесена неверно: %q", len(line), line)
+		}
+	}
+	unfolded := strings.ReplaceAll(doc, "\r\n ", "")
+	for _, want := range []string{
+		"SUMMARY:" + escapeICSText(events[0].Note),
+		"DTSTART;TZID=Europe/Berlin:20240304T090000",
+		"DTSTART:20240301T120000Z",
+		"UID:event-1-user-1@wb-l2",
+		// Пояс описан переходами на летнее и зимнее время на период повторений
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240331T020000\r\nRDATE:20250330T020000",
+		"TZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST",
+		"BEGIN:STANDARD\r\nDTSTART:20231029T030000\r\nRDATE:20241027T030000",
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("Ожидается %q в документе:\n%s", want, unfolded)
+		}
+	}
+	if strings.Count(unfolded, "BEGIN:VTIMEZONE") != 1 {
+		t.Errorf("Для UTC пояс не описывается, получено:\n%s", unfolded)
+	}
+}
+
+func TestICSRoundTrip(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Нет базы часовых поясов: %v", err)
+	}
+	start := time.Date(2024, 3, 1, 18, 30, 0, 0, newYork)
+	until := time.Date(2024, 4, 26, 18, 30, 0, 0, newYork)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, newYork)
+	dayUntil := endOfDay(day.AddDate(0, 0, 10))
+	events := []Event{
+		{ID: 1, UserID: 1, Start: start, End: start.Add(90 * time.Minute), TimeZone: "America/New_York", Note: "weekly; with, specials\nand lines",
+			Recurrence: &Recurrence{Freq: FreqWeekly, Interval: 2, ByDay: []WeekdayNum{{Weekday: time.Friday}}, Until: &until,
+				Exceptions: []time.Time{start.AddDate(0, 0, 14)}}},
+		{ID: 2, UserID: 1, Start: day, End: day.AddDate(0, 0, 2), TimeZone: "America/New_York", AllDay: true, UID: "trip@example.com",
+			Recurrence: &Recurrence{Freq: FreqDaily, Until: &dayUntil, Exceptions: []time.Time{day.AddDate(0, 0, 3)}}},
+		{ID: 3, UserID: 1, Start: start.UTC(), End: start.UTC().Add(time.Hour), TimeZone: "UTC", Note: "once"},
+	}
+	var buf bytes.Buffer
+	if err := writeICS(&buf, events, time.Now()); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	parsed, err := parseICS(&buf, newYork)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при разборе выгрузки: %v", err)
+	}
+	if len(parsed) != len(events) {
+		t.Fatalf("Ожидается %d событий, получено %d", len(events), len(parsed))
+	}
+	for i, want := range events {
+		got := parsed[i]
+		if got.UID != exportUID(want) || !got.Start.Equal(want.Start) || !got.End.Equal(want.End) || got.TimeZone != want.TimeZone ||
+			got.AllDay != want.AllDay || got.Note != want.Note {
+			t.Errorf("Событие %d: ожидается %+v, получено %+v", want.ID, want, got)
+		}
+		if want.Recurrence == nil {
+			continue
+		}
+		// Правила совпадают по повторениям, а не по записи UNTIL
+		from, to := want.Start, want.Start.AddDate(1, 0, 0)
+		wantOccurrences := want.Recurrence.Occurrences(want.Start, from, to)
+		gotOccurrences := got.Recurrence.Occurrences(got.Start, from, to)
+		if len(gotOccurrences) != len(wantOccurrences) {
+			t.Fatalf("Событие %d: ожидаются повторения %v, получено %v", want.ID, wantOccurrences, gotOccurrences)
+		}
+		for j := range wantOccurrences {
+			if !gotOccurrences[j].Equal(wantOccurrences[j]) {
+				t.Errorf("Событие %d: ожидаются повторения %v, получено %v", want.ID, wantOccurrences, gotOccurrences)
+				break
+			}
+		}
+	}
+}
+
+func TestICSImportRejectsConflicts(t *testing.T) {
+	service := NewEventService(NewMemoryRepository(), ServiceOptions{ConflictPolicy: ConflictReject})
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	if _, err := service.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), UID: "standup"}); err != nil {
+		t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+	}
+	later := start.Add(3 * time.Hour)
+
+	tests := []struct {
+		name    string
+		events  []Event
+		wantErr string
+	}{
+		{"conflict with stored event", []Event{{Start: later, End: later.Add(time.Hour)}, {Start: start, End: start.Add(time.Hour)}},
+			"ics event 2: event overlaps with events 1"},
+		{"conflict inside the file", []Event{{Start: later, End: later.Add(time.Hour)},
+			{Start: later.Add(-24 * time.Hour), End: later.Add(-23 * time.Hour), Recurrence: &Recurrence{Freq: FreqDaily, Count: 2}}},
+			"ics event 2 overlaps with ics event 1"},
+		// Событие, которое файл переносит, не мешает занять его прежнее время
+		{"moved event frees its time", []Event{{Start: start, End: start.Add(time.Hour)}, {UID: "standup", Start: later, End: later.Add(time.Hour)}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.ImportEvents(1, tt.events)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Неожиданная ошибка: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Ожидается ошибка %q, получено %v", tt.wantErr, err)
+			}
+			if n, _ := service.CountEvents(); n != 1 {
+				t.Errorf("Ничего не должно сохраниться, всего событий %d", n)
+			}
+		})
+	}
+}
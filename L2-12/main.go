@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -52,12 +63,55 @@ Web-сервер должен запускаться на порту указа
 
 // Event представляет собой структуру события
 type Event struct {
-	UserID    int       `json:"user_id"`
-	EventDate time.Time `json:"event_date"`
-	Note      string    `json:"note"`
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// Start и End — начало и конец события, End строго позже Start
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// TimeZone — часовой пояс IANA, в котором событие показывается и повторяется
+	TimeZone string `json:"time_zone"`
+	// AllDay — событие на весь день (или несколько дней) без времени начала
+	AllDay bool   `json:"all_day,omitempty"`
+	Note   string `json:"note"`
+	// Recurrence — правило повторения; nil для однократного события
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+	// Reminders — за сколько до начала события (каждого повторения) отправить напоминания
+	Reminders []Duration `json:"reminders,omitempty"`
+	// Attendees — приглашенные пользователи и их ответы
+	Attendees []Attendee `json:"attendees,omitempty"`
+	// Responses — сводка ответов приглашенных. Вычисляется при выдаче и не сохраняется.
+	Responses *RSVPSummary `json:"responses,omitempty"`
+	// Conflicts — ID пересекающихся событий того же пользователя. Заполняется только
+	// в ответах на создание и обновление и не сохраняется.
+	Conflicts []int `json:"conflicts,omitempty"`
+	// UID — идентификатор события в импортированном .ics; повторный импорт обновляет событие
+	UID string `json:"uid,omitempty"`
 }
 
-var events = []Event{}
+// UnmarshalJSON читает и старый формат события с одной датой event_date:
+// такие записи остались в журналах файлового хранилища
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type plain Event
+	var aux struct {
+		plain
+		EventDate *time.Time `json:"event_date"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*e = Event(aux.plain)
+	if aux.EventDate != nil && e.Start.IsZero() {
+		e.Start = *aux.EventDate
+		e.End = e.Start.AddDate(0, 0, 1)
+		e.AllDay = true
+	}
+	return nil
+}
+
+// Duration возвращает длительность события
+func (e Event) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
 
 // Функция для сериализации события в JSON
 func serializeEvent(event Event) ([]byte, error) {
@@ -74,106 +128,544 @@ func deserializeEvent(data []byte) (Event, error) {
 	return event, nil
 }
 
-//Создадим middleware для логирования запросов.
+// writeJSON отправляет клиенту произвольный JSON-документ с указанным HTTP-кодом
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
 
-// LoggingMiddleware логирует входящие HTTP-запросы
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Логируем запрос
-		logRequest(r)
-		next.ServeHTTP(w, r)
-	})
+// writeResult отправляет клиенту JSON-документ вида {"result": ...}
+func writeResult(w http.ResponseWriter, result interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": result})
 }
 
-func logRequest(r *http.Request) {
-	// Здесь можно использовать более сложное логирование, например, logrus или zap
-	println(r.Method, r.URL.String())
+// allowMethod проверяет метод запроса и при несовпадении сам отвечает клиенту 405
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	return false
 }
 
 //Валидация параметров
 
-func validateEventParams(userIDStr string, dateStr string) (int, time.Time, error) {
+func parseUserID(userIDStr string) (int, error) {
 	userID, err := strconv.Atoi(userIDStr)
 	if err != nil {
-		return 0, time.Time{}, errors.New("invalid user_id")
+		return 0, newValidationError("invalid user_id")
 	}
-	date, err := time.Parse("2006-01-02", dateStr)
+	return userID, nil
+}
+
+func validateEventParams(userIDStr string, dateStr string, loc *time.Location) (int, time.Time, error) {
+	userID, err := parseUserID(userIDStr)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	date, err := time.ParseInLocation("2006-01-02", dateStr, loc)
 	if err != nil {
-		return 0, time.Time{}, errors.New("invalid date format")
+		return 0, time.Time{}, newValidationError("invalid date format")
 	}
 	return userID, date, nil
 }
 
+// eventTimeLayouts — допустимые форматы start и end без явного смещения
+var eventTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// parseEventTime разбирает время в формате RFC 3339 или локальное время в поясе loc
+func parseEventTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range eventTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// parseLocation возвращает часовой пояс из параметра tz или пояс по умолчанию
+func parseLocation(tz string, def *time.Location) (*time.Location, error) {
+	if tz == "" {
+		return def, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, newValidationError("invalid tz")
+	}
+	return loc, nil
+}
+
+// parseEventForm собирает событие из параметров /create_event и /update_event.
+// Событие задается либо датой date (событие на весь день), либо парой start и end;
+// время без смещения интерпретируется в поясе tz.
+func parseEventForm(form url.Values, defaultLoc *time.Location) (Event, error) {
+	userID, err := parseUserID(form.Get("user_id"))
+	if err != nil {
+		return Event{}, err
+	}
+	loc, err := parseLocation(form.Get("tz"), defaultLoc)
+	if err != nil {
+		return Event{}, err
+	}
+	event := Event{UserID: userID, TimeZone: loc.String(), Note: form.Get("note")}
+
+	switch {
+	case form.Get("start") != "":
+		if event.Start, err = parseEventTime(form.Get("start"), loc); err != nil {
+			return Event{}, newValidationError("invalid start")
+		}
+		if form.Get("end") == "" {
+			return Event{}, newValidationError("end is required with start")
+		}
+		if event.End, err = parseEventTime(form.Get("end"), loc); err != nil {
+			return Event{}, newValidationError("invalid end")
+		}
+	case form.Get("date") != "":
+		_, date, err := validateEventParams(form.Get("user_id"), form.Get("date"), loc)
+		if err != nil {
+			return Event{}, err
+		}
+		event.Start, event.End, event.AllDay = date, date.AddDate(0, 0, 1), true
+	default:
+		return Event{}, newValidationError("date or start and end are required")
+	}
+
+	event.Recurrence, err = parseRecurrence(form.Get("rrule"), form.Get("exdate"), event.Start)
+	if err != nil {
+		return Event{}, err
+	}
+	if event.Reminders, err = parseReminders(form.Get("reminders")); err != nil {
+		return Event{}, err
+	}
+	if event.Attendees, err = parseAttendees(form.Get("attendees")); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+func validateIDParams(idStr string, userIDStr string) (int, int, error) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, 0, newValidationError("invalid id")
+	}
+	userID, err := parseUserID(userIDStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, userID, nil
+}
+
 //HTTP-обработчики
 
+// server связывает HTTP-обработчики с бизнес-логикой
+type server struct {
+	service         EventService
+	tokens          *tokenStore    // nil, если аутентификация выключена
+	loc             *time.Location // часовой пояс, в котором разбираются даты запросов
+	maxBodyBytes    int64          // ограничение размера тела POST-запросов
+	rateLimit       RateLimitConfig
+	webhooks        *WebhookDispatcher // nil, если вебхуки выключены
+	changes         *changeBroker      // nil, если поток изменений выключен
+	streamHeartbeat time.Duration
+	shuttingDown    atomic.Bool // выставляется в начале остановки, /readyz начинает отвечать 503
+	logger          *slog.Logger
+}
+
+func newServer(service EventService, cfg Config) *server {
+	return &server{
+		service:         service,
+		loc:             cfg.Location(),
+		maxBodyBytes:    int64(cfg.MaxBodyBytes),
+		rateLimit:       cfg.RateLimit,
+		streamHeartbeat: time.Duration(cfg.Stream.Heartbeat),
+		logger:          slog.Default(),
+	}
+}
+
 // Создание события
-func createEventHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (s *server) createEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
 
-	r.ParseForm()
-	userIDStr := r.FormValue("user_id")
-	dateStr := r.FormValue("date")
-	note := r.FormValue("note")
-
-	userID, eventDate, err := validateEventParams(userIDStr, dateStr)
+	params, err := readParams(w, r, s.maxBodyBytes, eventFields...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
+		return
+	}
+	event, err := parseEventForm(params, s.loc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, event.UserID); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	event := Event{UserID: userID, EventDate: eventDate, Note: note}
-	events = append(events, event)
-
-	response := map[string]string{"result": "event created"}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	event, err = s.service.CreateEvent(event)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, event)
 }
 
 // Обновление события
-func updateEventHandler(w http.ResponseWriter, r *http.Request) {
-	// Логика для обновления события
+func (s *server) updateEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	params, err := readParams(w, r, s.maxBodyBytes, updateEventFields...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, _, err := validateIDParams(params.Get("id"), params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	event, err := parseEventForm(params, s.loc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, event.UserID); err != nil {
+		writeError(w, err)
+		return
+	}
+	event.ID = id
+
+	event, err = s.service.UpdateEvent(event)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, event)
 }
 
 // Удаление события
-func deleteEventHandler(w http.ResponseWriter, r *http.Request) {
-	// Логика для удаления события
+func (s *server) deleteEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	params, err := readParams(w, r, s.maxBodyBytes, deleteEventFields...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, userID, err := validateIDParams(params.Get("id"), params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.service.DeleteEvent(id, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, "event deleted")
 }
 
 // Получение событий за день
-func eventsForDayHandler(w http.ResponseWriter, r *http.Request) {
-	// Логика для получения событий за день
+func (s *server) eventsForDayHandler(w http.ResponseWriter, r *http.Request) {
+	s.eventsForPeriod(w, r, s.service.EventsForDay)
 }
 
 // Получение событий за неделю
-func eventsForWeekHandler(w http.ResponseWriter, r *http.Request) {
-	// Логика для получения событий за неделю
+func (s *server) eventsForWeekHandler(w http.ResponseWriter, r *http.Request) {
+	s.eventsForPeriod(w, r, s.service.EventsForWeek)
 }
 
 // Получение событий за месяц
-func eventsForMonthHandler(w http.ResponseWriter, r *http.Request) {
-	// Логика для получения событий за месяц
+func (s *server) eventsForMonthHandler(w http.ResponseWriter, r *http.Request) {
+	s.eventsForPeriod(w, r, s.service.EventsForMonth)
+}
+
+// eventsForPeriod — общая часть GET-обработчиков: параметры берутся из queryString
+func (s *server) eventsForPeriod(w http.ResponseWriter, r *http.Request, list func(int, time.Time) ([]Event, error)) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	// Границы дня, недели и месяца считаются в часовом поясе пользователя (параметр tz)
+	query := r.URL.Query()
+	loc, err := parseLocation(query.Get("tz"), s.loc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, date, err := validateEventParams(query.Get("user_id"), query.Get("date"), loc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	events, err := list(userID, date)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, events)
+}
+
+// routes регистрирует обработчики всех методов API и возвращает роутер, обернутый middleware
+func (s *server) routes() http.Handler {
+	_, handler := s.router()
+	return handler
+}
+
+// router регистрирует обработчики всех методов API и возвращает роутер и его обертку middleware
+func (s *server) router() (*http.ServeMux, http.Handler) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/create_event", requireAccept(s.createEventHandler, eventResponseTypes...))
+	mux.HandleFunc("/update_event", requireAccept(s.updateEventHandler, eventResponseTypes...))
+	mux.HandleFunc("/delete_event", requireAccept(s.deleteEventHandler, contentTypeJSON))
+	mux.HandleFunc("/rsvp_event", requireAccept(s.rsvpEventHandler, eventResponseTypes...))
+	mux.HandleFunc("/revert_event", requireAccept(s.revertEventHandler, eventResponseTypes...))
+	mux.HandleFunc("/event_history", requireAccept(s.eventHistoryHandler, contentTypeJSON))
+	mux.HandleFunc("/events_for_day", requireAccept(s.eventsForDayHandler, eventResponseTypes...))
+	mux.HandleFunc("/events_for_week", requireAccept(s.eventsForWeekHandler, eventResponseTypes...))
+	mux.HandleFunc("/events_for_month", requireAccept(s.eventsForMonthHandler, eventResponseTypes...))
+	mux.HandleFunc("/events/search", requireAccept(s.searchEventsHandler, contentTypeJSON))
+	mux.HandleFunc("/free_busy", requireAccept(s.freeBusyHandler, contentTypeJSON))
+	mux.HandleFunc("/find_slots", requireAccept(s.findSlotsHandler, contentTypeJSON))
+	mux.HandleFunc("/export_ics", requireAccept(s.exportICSHandler, contentTypeCalendar))
+	mux.HandleFunc("/import_ics", requireAccept(s.importICSHandler, eventResponseTypes...))
+
+	// Метки маршрутов берутся из шаблонов роутера, чтобы число меток не зависело от путей в запросах
+	metrics := NewMetrics(muxRoute(mux), s.service.CountEvents)
+	mux.Handle("/metrics", metrics)
+	if s.changes != nil {
+		mux.HandleFunc("/events/stream", requireAccept(s.streamEventsHandler, contentTypeEventStream))
+	}
+	if s.webhooks != nil {
+		mux.HandleFunc("/create_webhook", requireAccept(s.createWebhookHandler, contentTypeJSON))
+		mux.HandleFunc("/delete_webhook", requireAccept(s.deleteWebhookHandler, contentTypeJSON))
+		mux.HandleFunc("/webhooks", requireAccept(s.webhooksHandler, contentTypeJSON))
+		mux.HandleFunc("/webhook_deliveries", requireAccept(s.webhookDeliveriesHandler, contentTypeJSON))
+	}
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/readyz", s.readyzHandler)
+	mux.HandleFunc("/openapi.json", s.openAPIHandler)
+
+	var handler http.Handler = mux
+	var limiter *RateLimiter
+	if s.rateLimit.Enabled {
+		limiter = NewRateLimiter(s.rateLimit, time.Now)
+		handler = limiter.ByClient(handler)
+	}
+	if s.tokens != nil {
+		mux.HandleFunc("/admin/issue_token", requireAccept(s.issueTokenHandler, contentTypeJSON))
+		mux.HandleFunc("/admin/revoke_token", requireAccept(s.revokeTokenHandler, contentTypeJSON))
+		handler = AuthMiddleware(s.tokens, handler)
+	}
+	// Лимит по адресу стоит перед аутентификацией, чтобы перебор токенов тоже ограничивался
+	if limiter != nil {
+		handler = limiter.ByAddress(handler)
+	}
+
+	// Middleware оборачивает весь роутер, поэтому логируется каждый обработанный запрос
+	return mux, LoggingMiddleware(s.logger, metrics, handler)
+}
+
+// buildServer собирает сервис событий и HTTP-сервер над открытым хранилищем:
+// подключает вебхуки, поток изменений и токены, если они включены в конфигурации
+func buildServer(cfg Config, repo EventRepository) (*server, error) {
+	opts := ServiceOptions{Location: cfg.Location(), ConflictPolicy: cfg.ConflictPolicy}
+	var webhooks *WebhookDispatcher
+	if cfg.Webhooks.Enabled {
+		var err error
+		if webhooks, err = NewWebhookDispatcher(cfg.Webhooks, slog.Default()); err != nil {
+			return nil, fmt.Errorf("open webhooks: %w", err)
+		}
+		opts.Listeners = append(opts.Listeners, webhooks)
+	}
+	var changes *changeBroker
+	if cfg.Stream.Enabled {
+		var err error
+		if changes, err = newChangeBroker(cfg.Stream.ChangeLogSize); err != nil {
+			return nil, err
+		}
+		opts.Listeners = append(opts.Listeners, changes)
+	}
+
+	srv := newServer(NewEventService(repo, opts), cfg)
+	srv.webhooks = webhooks
+	srv.changes = changes
+	if cfg.Auth.Enabled {
+		tokens, err := openTokenStore(cfg.Auth.TokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("open tokens: %w", err)
+		}
+		srv.tokens = tokens
+	}
+	return srv, nil
+}
+
+// openRepository создает хранилище событий, выбранное в конфигурации
+func openRepository(cfg StorageConfig, logger *slog.Logger) (EventRepository, error) {
+	switch cfg.Type {
+	case "memory":
+		return NewMemoryRepository(), nil
+	case "file":
+		return NewFileRepository(cfg.Path, time.Duration(cfg.CompactInterval), logger)
+	case "sqlite":
+		return NewSQLiteRepository(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Type)
+	}
 }
 
 //Основная функция и роутер
 
 func main() {
-	http.Handle("/", LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})))
+	if len(os.Args) > 1 && os.Args[1] == "issue-token" {
+		if err := issueTokenCommand(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "Ошибка выдачи токена: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка конфигурации: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(newLogger(cfg.Log))
 
-	http.HandleFunc("/create_event", createEventHandler)
-	http.HandleFunc("/update_event", updateEventHandler)
-	http.HandleFunc("/delete_event", deleteEventHandler)
-	http.HandleFunc("/events_for_day", eventsForDayHandler)
-	http.HandleFunc("/events_for_week", eventsForWeekHandler)
-	http.HandleFunc("/events_for_month", eventsForMonthHandler)
+	if err := run(cfg); err != nil {
+		slog.Error("server stopped with error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run запускает сервер и блокируется до SIGINT/SIGTERM. После сигнала сервер перестает
+// принимать новые соединения, дожидается завершения текущих запросов и закрывает хранилище.
+func run(cfg Config) error {
+	repo, err := openRepository(cfg.Storage, slog.Default())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		repo.Close()
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// После первого сигнала обработка возвращается по умолчанию: повторный завершит процесс сразу
+	context.AfterFunc(ctx, stop)
+	return serve(ctx, cfg, repo, listener)
+}
+
+// serve обслуживает запросы на listener до отмены ctx, затем останавливает сервер:
+// /readyz отвечает 503 в течение ShutdownDelay, текущие запросы дорабатывают,
+// фоновые задачи останавливаются, и только после этого закрывается хранилище repo.
+func serve(ctx context.Context, cfg Config, repo EventRepository, listener net.Listener) error {
+	srv, err := buildServer(cfg, repo)
+	if err != nil {
+		listener.Close()
+		repo.Close()
+		return err
+	}
+	httpServer := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           srv.routes(),
+		ReadTimeout:       time.Duration(cfg.Timeouts.Read),
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		WriteTimeout:      time.Duration(cfg.Timeouts.Write),
+		IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if srv.changes != nil {
+		// Открытые потоки иначе не дали бы Shutdown дождаться завершения запросов
+		httpServer.RegisterOnShutdown(srv.changes.Close)
+	}
+
+	// Фоновые задачи останавливаются до закрытия хранилища
+	background, stopBackground := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	defer func() {
+		stopBackground()
+		workers.Wait()
+	}()
+	if cfg.Reminders.Enabled {
+		scheduler := NewReminderScheduler(srv.service, repo.Reminders(), newNotifier(cfg.Reminders, slog.Default()),
+			time.Duration(cfg.Reminders.Interval))
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			scheduler.Run(background)
+		}()
+	}
+	if srv.webhooks != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			srv.webhooks.Run(background)
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server is running", "addr", listener.Addr().String(), "storage", cfg.Storage.Type, "time_zone", cfg.TimeZone, "auth", cfg.Auth.Enabled)
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err = <-serveErr:
+		// Сервер не смог стартовать или упал сам
+	case <-ctx.Done():
+		slog.Info("shutting down", "timeout", time.Duration(cfg.Timeouts.Shutdown).String())
+		err = srv.shutdown(httpServer, time.Duration(cfg.Timeouts.ShutdownDelay), time.Duration(cfg.Timeouts.Shutdown))
+	}
+
+	// Хранилище закрывается только после того, как обработчики и фоновые задачи перестали его использовать
+	stopBackground()
+	workers.Wait()
+	if closeErr := repo.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("close storage: %w", closeErr))
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	if err == nil {
+		slog.Info("server stopped")
+	}
+	return err
+}
 
-	port := ":8080" // Укажите ваш порт
-	println("Server is running on port", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		panic(err)
+// newLogger создает логгер в формате, заданном в конфигурации
+func newLogger(cfg LogConfig) *slog.Logger {
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	}
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
 }
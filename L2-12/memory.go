@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memoryShardCount — число шардов хранилища в памяти.
+// События пользователя всегда лежат в одном шарде, поэтому запросы разных пользователей
+// почти не конкурируют за блокировки.
+const memoryShardCount = 32
+
+// memoryRepository хранит события в памяти процесса.
+// Безопасен для одновременного использования из нескольких горутин.
+type memoryRepository struct {
+	shards    [memoryShardCount]memoryShard
+	reminders *memoryReminderStore
+	history   *memoryHistoryStore
+	// owners — индекс ID события -> ID владельца, чтобы находить шард по ID события
+	owners sync.Map
+	nextID atomic.Int64
+
+	// invites — индекс user_id -> ID событий, на которые пользователь приглашен.
+	// Меняется под блокировкой шарда владельца события, блокировка индекса берется второй.
+	invitesMu sync.RWMutex
+	invites   map[int]map[int]struct{}
+}
+
+// memoryShard — часть хранилища со своей блокировкой
+type memoryShard struct {
+	mu    sync.RWMutex
+	users map[int]map[int]Event // user_id -> id -> событие
+}
+
+// NewMemoryRepository создает пустое хранилище событий в памяти
+func NewMemoryRepository() EventRepository {
+	return newMemoryRepository()
+}
+
+func newMemoryRepository() *memoryRepository {
+	m := &memoryRepository{reminders: newMemoryReminderStore(), history: newMemoryHistoryStore(), invites: make(map[int]map[int]struct{})}
+	for i := range m.shards {
+		m.shards[i].users = make(map[int]map[int]Event)
+	}
+	return m
+}
+
+func (m *memoryRepository) shard(userID int) *memoryShard {
+	return &m.shards[shardIndex(userID)]
+}
+
+func shardIndex(userID int) int {
+	i := userID % memoryShardCount
+	if i < 0 {
+		i = -i
+	}
+	return i
+}
+
+func (m *memoryRepository) Create(event Event) (Event, error) {
+	return writeOne(m, EventWrite{Op: WriteCreate, Event: event})
+}
+
+func (m *memoryRepository) Update(event Event) error {
+	_, err := writeOne(m, EventWrite{Op: WriteUpdate, Event: event})
+	return err
+}
+
+func (m *memoryRepository) Delete(id int) error {
+	_, err := writeOne(m, EventWrite{Op: WriteDelete, Event: Event{ID: id}})
+	return err
+}
+
+func (m *memoryRepository) Restore(event Event) error {
+	_, err := writeOne(m, EventWrite{Op: WriteRestore, Event: event})
+	return err
+}
+
+// Write с одной записью выполняет ее под блокировками этой операции, а несколько записей —
+// под блокировкой всех шардов: сначала проверяются все, затем выполняются.
+// Версия события сохраняется в истории под той же блокировкой, что и само изменение.
+func (m *memoryRepository) Write(writes []EventWrite) ([]Event, error) {
+	if len(writes) == 1 {
+		event, err := m.writeOne(writes[0])
+		if err != nil {
+			return nil, err
+		}
+		return []Event{event}, nil
+	}
+
+	unlock := m.lockAll()
+	defer unlock()
+	if err := m.checkWrites(writes); err != nil {
+		return nil, err
+	}
+	result := make([]Event, len(writes))
+	for i, w := range writes {
+		result[i] = m.applyLocked(w)
+		m.record(w, result[i])
+	}
+	return result, nil
+}
+
+func (m *memoryRepository) writeOne(w EventWrite) (Event, error) {
+	switch w.Op {
+	case WriteCreate:
+		return m.create(w), nil
+	case WriteRestore:
+		return w.Event, m.insert(w)
+	case WriteUpdate:
+		return w.Event, m.update(w)
+	case WriteDelete:
+		return m.delete(w)
+	default:
+		return Event{}, fmt.Errorf("unknown write %q", w.Op)
+	}
+}
+
+func (m *memoryRepository) create(w EventWrite) Event {
+	event := w.Event
+	event.ID = int(m.nextID.Add(1))
+	sh := m.shard(event.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	m.storeLocked(event)
+	m.record(w, event)
+	return event
+}
+
+func (m *memoryRepository) update(w EventWrite) error {
+	event := w.Event
+	for {
+		ownerID, ok := m.owner(event.ID)
+		if !ok {
+			return ErrEventNotFound
+		}
+
+		// Событие может перейти к другому пользователю: блокируем оба шарда в порядке индексов,
+		// чтобы параллельные переносы не могли заблокировать друг друга
+		unlock := m.lockShards(ownerID, event.UserID)
+		if current, ok := m.owner(event.ID); !ok || current != ownerID {
+			// Владелец сменился или событие удалено, пока ждали блокировку
+			unlock()
+			continue
+		}
+
+		m.unindex(m.shard(ownerID).users[ownerID][event.ID])
+		m.shard(ownerID).drop(ownerID, event.ID)
+		m.storeLocked(event)
+		m.record(w, event)
+		unlock()
+		return nil
+	}
+}
+
+// delete удаляет событие и возвращает его
+func (m *memoryRepository) delete(w EventWrite) (Event, error) {
+	sh, ownerID, ok := m.lockOwner(w.Event.ID)
+	if !ok {
+		return Event{}, ErrEventNotFound
+	}
+	defer sh.mu.Unlock()
+
+	event := sh.users[ownerID][w.Event.ID]
+	m.unindex(event)
+	sh.drop(ownerID, event.ID)
+	m.owners.Delete(event.ID)
+	m.record(w, event)
+	return event, nil
+}
+
+// insert снова сохраняет событие с прежним ID (Restore)
+func (m *memoryRepository) insert(w EventWrite) error {
+	// Событие восстанавливается к тому же владельцу, поэтому проверка и запись под одной блокировкой
+	sh := m.shard(w.Event.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, ok := m.owner(w.Event.ID); ok {
+		return ErrEventExists
+	}
+	m.storeLocked(w.Event)
+	m.record(w, w.Event)
+	return nil
+}
+
+// record сохраняет версию события, переданную с записью w; вызывается под блокировкой шарда
+// владельца, поэтому версии одного события нумеруются в порядке его изменений
+func (m *memoryRepository) record(w EventWrite, event Event) {
+	if w.History != nil {
+		m.history.record(historyEntry(*w.History, w.Op, event))
+	}
+}
+
+// checkWrites проверяет, что записи выполнимы по порядку: обновляемые и удаляемые события есть,
+// а ID восстанавливаемых свободны. Вызывается под блокировкой, исключающей другие записи.
+func (m *memoryRepository) checkWrites(writes []EventWrite) error {
+	// exists — наличие событий с учетом предыдущих записей набора
+	exists := make(map[int]bool)
+	present := func(id int) bool {
+		if ok, changed := exists[id]; changed {
+			return ok
+		}
+		_, ok := m.owner(id)
+		return ok
+	}
+	for _, w := range writes {
+		id := w.Event.ID
+		switch w.Op {
+		case WriteCreate:
+		case WriteRestore:
+			if present(id) {
+				return ErrEventExists
+			}
+			exists[id] = true
+		case WriteUpdate, WriteDelete:
+			if !present(id) {
+				return ErrEventNotFound
+			}
+			exists[id] = w.Op == WriteUpdate
+		default:
+			return fmt.Errorf("unknown write %q", w.Op)
+		}
+	}
+	return nil
+}
+
+// applyLocked выполняет проверенную запись; вызывается под блокировкой всех шардов
+func (m *memoryRepository) applyLocked(w EventWrite) Event {
+	event := w.Event
+	if w.Op == WriteCreate {
+		event.ID = int(m.nextID.Add(1))
+	}
+	if ownerID, ok := m.owner(event.ID); ok {
+		current := m.shard(ownerID).users[ownerID][event.ID]
+		m.unindex(current)
+		m.shard(ownerID).drop(ownerID, event.ID)
+		m.owners.Delete(event.ID)
+		if w.Op == WriteDelete {
+			return current
+		}
+	}
+	m.storeLocked(event)
+	return event
+}
+
+func (m *memoryRepository) UpdateAttendee(id int, attendee Attendee, history *HistoryEntry) (Event, error) {
+	sh, ownerID, ok := m.lockOwner(id)
+	if !ok {
+		return Event{}, ErrEventNotFound
+	}
+	defer sh.mu.Unlock()
+
+	// Состав участников не меняется, поэтому индекс приглашений остается прежним
+	current := sh.users[ownerID][id]
+	event, err := current.withAttendee(attendee)
+	if err != nil {
+		return Event{}, err
+	}
+	sh.put(event)
+	if history != nil {
+		entry := historyEntry(*history, WriteUpdate, event)
+		entry.Before = versionOf(current)
+		m.history.record(entry)
+	}
+	return event, nil
+}
+
+func (m *memoryRepository) Get(id int) (Event, error) {
+	for {
+		ownerID, ok := m.owner(id)
+		if !ok {
+			return Event{}, ErrEventNotFound
+		}
+
+		sh := m.shard(ownerID)
+		sh.mu.RLock()
+		event, ok := sh.users[ownerID][id]
+		sh.mu.RUnlock()
+		if ok {
+			return event, nil
+		}
+		// Событие не нашлось у владельца: либо удалено, либо только что перенесено
+		if current, exists := m.owner(id); !exists || current == ownerID {
+			return Event{}, ErrEventNotFound
+		}
+	}
+}
+
+func (m *memoryRepository) GetByUID(userID int, uid string) (Event, error) {
+	sh := m.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	for _, event := range sh.users[userID] {
+		if event.UID == uid {
+			return event, nil
+		}
+	}
+	return Event{}, ErrEventNotFound
+}
+
+func (m *memoryRepository) ListByUser(userID int, from, to time.Time) ([]Event, error) {
+	sh := m.shard(userID)
+	sh.mu.RLock()
+	result := []Event{}
+	for _, event := range sh.users[userID] {
+		if event.mayOccurIn(from, to) {
+			result = append(result, event)
+		}
+	}
+	sh.mu.RUnlock()
+
+	// Приглашения лежат в шардах владельцев; событие могло измениться после чтения индекса,
+	// поэтому приглашение перепроверяется
+	for _, id := range m.invitations(userID) {
+		event, err := m.Get(id)
+		if err != nil {
+			continue
+		}
+		if _, invited := event.attendee(userID); invited && event.mayOccurIn(from, to) {
+			result = append(result, event)
+		}
+	}
+	sortEvents(result)
+	return result, nil
+}
+
+func (m *memoryRepository) Search(query SearchQuery) ([]Event, error) {
+	events, err := m.ListByUser(query.UserID, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	words := searchWords(query.Text)
+	matched := events[:0]
+	for _, event := range events {
+		if query.After != nil && !searchLess(Event{ID: query.After.ID, Start: query.After.Start}, event, query.Descending) {
+			continue
+		}
+		if containsWords(event.Note, words) {
+			matched = append(matched, event)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return searchLess(matched[i], matched[j], query.Descending) })
+	if len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+	return matched, nil
+}
+
+func (m *memoryRepository) ListWithReminders(from, to time.Time) ([]Event, error) {
+	result := []Event{}
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for _, userEvents := range sh.users {
+			for _, event := range userEvents {
+				if len(event.Reminders) > 0 && event.mayOccurIn(from, to) {
+					result = append(result, event)
+				}
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	sortEvents(result)
+	return result, nil
+}
+
+func (m *memoryRepository) Reminders() ReminderStore {
+	return m.reminders
+}
+
+func (m *memoryRepository) History() HistoryStore {
+	return m.history
+}
+
+func (m *memoryRepository) Count() (int, error) {
+	n := 0
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for _, userEvents := range sh.users {
+			n += len(userEvents)
+		}
+		sh.mu.RUnlock()
+	}
+	return n, nil
+}
+
+func (m *memoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryRepository) Close() error {
+	return nil
+}
+
+// put сохраняет событие с уже присвоенным ID (используется при восстановлении из журнала)
+func (m *memoryRepository) put(event Event) {
+	if err := m.Update(event); err != nil {
+		m.store(event)
+	}
+	for {
+		next := m.nextID.Load()
+		if int64(event.ID) <= next || m.nextID.CompareAndSwap(next, int64(event.ID)) {
+			return
+		}
+	}
+}
+
+// remove удаляет событие без проверки существования (используется при восстановлении из журнала)
+func (m *memoryRepository) remove(id int) {
+	m.Delete(id)
+}
+
+// snapshot возвращает копию всех событий и следующий свободный ID
+func (m *memoryRepository) snapshot() ([]Event, int) {
+	unlock := m.lockAll()
+	defer unlock()
+
+	events := []Event{}
+	for i := range m.shards {
+		for _, userEvents := range m.shards[i].users {
+			for _, event := range userEvents {
+				events = append(events, event)
+			}
+		}
+	}
+	sortEvents(events)
+	return events, int(m.nextID.Load()) + 1
+}
+
+// restore заменяет содержимое хранилища данными из снимка
+func (m *memoryRepository) restore(events []Event, nextID int) {
+	unlock := m.lockAll()
+	defer unlock()
+
+	m.owners.Range(func(key, _ interface{}) bool {
+		m.owners.Delete(key)
+		return true
+	})
+	for i := range m.shards {
+		m.shards[i].users = make(map[int]map[int]Event)
+	}
+	m.invitesMu.Lock()
+	m.invites = make(map[int]map[int]struct{})
+	m.invitesMu.Unlock()
+	m.nextID.Store(int64(nextID - 1))
+	for _, event := range events {
+		m.shard(event.UserID).put(event)
+		m.owners.Store(event.ID, event.UserID)
+		m.index(event)
+		if int64(event.ID) > m.nextID.Load() {
+			m.nextID.Store(int64(event.ID))
+		}
+	}
+}
+
+// store кладет событие в шард владельца и обновляет индекс
+func (m *memoryRepository) store(event Event) {
+	sh := m.shard(event.UserID)
+	sh.mu.Lock()
+	m.storeLocked(event)
+	sh.mu.Unlock()
+}
+
+// storeLocked кладет событие в шард владельца и обновляет индексы; вызывается под блокировкой шарда.
+// Индексы обновляются под той же блокировкой, чтобы Delete не мог их обогнать.
+func (m *memoryRepository) storeLocked(event Event) {
+	m.shard(event.UserID).put(event)
+	m.owners.Store(event.ID, event.UserID)
+	m.index(event)
+}
+
+// index добавляет приглашения события в индекс; вызывается под блокировкой шарда владельца
+func (m *memoryRepository) index(event Event) {
+	if len(event.Attendees) == 0 {
+		return
+	}
+	m.invitesMu.Lock()
+	defer m.invitesMu.Unlock()
+	for _, attendee := range event.Attendees {
+		ids, ok := m.invites[attendee.UserID]
+		if !ok {
+			ids = make(map[int]struct{})
+			m.invites[attendee.UserID] = ids
+		}
+		ids[event.ID] = struct{}{}
+	}
+}
+
+// unindex убирает приглашения события из индекса; вызывается под блокировкой шарда владельца
+func (m *memoryRepository) unindex(event Event) {
+	if len(event.Attendees) == 0 {
+		return
+	}
+	m.invitesMu.Lock()
+	defer m.invitesMu.Unlock()
+	for _, attendee := range event.Attendees {
+		delete(m.invites[attendee.UserID], event.ID)
+		if len(m.invites[attendee.UserID]) == 0 {
+			delete(m.invites, attendee.UserID)
+		}
+	}
+}
+
+// invitations возвращает ID событий, на которые приглашен пользователь
+func (m *memoryRepository) invitations(userID int) []int {
+	m.invitesMu.RLock()
+	defer m.invitesMu.RUnlock()
+	ids := make([]int, 0, len(m.invites[userID]))
+	for id := range m.invites[userID] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// lockOwner блокирует на запись шард владельца события.
+// Индекс владельцев меняется только под блокировкой шарда, поэтому после захвата
+// блокировки он перепроверяется: если владелец успел смениться, поиск повторяется.
+func (m *memoryRepository) lockOwner(id int) (*memoryShard, int, bool) {
+	for {
+		ownerID, ok := m.owner(id)
+		if !ok {
+			return nil, 0, false
+		}
+		sh := m.shard(ownerID)
+		sh.mu.Lock()
+		if current, ok := m.owner(id); ok && current == ownerID {
+			return sh, ownerID, true
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (m *memoryRepository) owner(id int) (int, bool) {
+	ownerID, ok := m.owners.Load(id)
+	if !ok {
+		return 0, false
+	}
+	return ownerID.(int), true
+}
+
+// lockAll блокирует все шарды на запись и возвращает функцию разблокировки
+func (m *memoryRepository) lockAll() func() {
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+	return func() {
+		for i := range m.shards {
+			m.shards[i].mu.Unlock()
+		}
+	}
+}
+
+// lockShards блокирует шарды двух пользователей (возможно, один и тот же) в порядке индексов
+func (m *memoryRepository) lockShards(userA, userB int) func() {
+	i, j := shardIndex(userA), shardIndex(userB)
+	if i == j {
+		m.shards[i].mu.Lock()
+		return m.shards[i].mu.Unlock
+	}
+	if j < i {
+		i, j = j, i
+	}
+	m.shards[i].mu.Lock()
+	m.shards[j].mu.Lock()
+	return func() {
+		m.shards[j].mu.Unlock()
+		m.shards[i].mu.Unlock()
+	}
+}
+
+// put добавляет событие в шард; вызывается под блокировкой шарда
+func (sh *memoryShard) put(event Event) {
+	userEvents, ok := sh.users[event.UserID]
+	if !ok {
+		userEvents = make(map[int]Event)
+		sh.users[event.UserID] = userEvents
+	}
+	userEvents[event.ID] = event
+}
+
+// drop удаляет событие пользователя из шарда; вызывается под блокировкой шарда
+func (sh *memoryShard) drop(userID, id int) {
+	delete(sh.users[userID], id)
+	if len(sh.users[userID]) == 0 {
+		delete(sh.users, userID)
+	}
+}
+
+// sortEvents упорядочивает события по началу, а при совпадении — по ID
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Start.Equal(events[j].Start) {
+			return events[i].Start.Before(events[j].Start)
+		}
+		return events[i].ID < events[j].ID
+	})
+}
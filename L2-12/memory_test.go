@@ -0,0 +1,137 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repo := NewMemoryRepository()
+	day := time.Date(2019, 9, 9, 0, 0, 0, 0, time.UTC)
+
+	start := day.Add(10 * time.Hour)
+	created, err := repo.Create(Event{UserID: 3, Start: start, End: start.Add(time.Hour), Note: "standup"})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при создании: %v", err)
+	}
+	if created.ID == 0 {
+		t.Fatalf("Событию не присвоен ID")
+	}
+
+	moved := created
+	moved.UserID = 4
+	if err := repo.Update(moved); err != nil {
+		t.Fatalf("Неожиданная ошибка при переносе к другому пользователю: %v", err)
+	}
+
+	tests := []struct {
+		userID   int
+		from, to time.Time
+		expected int
+	}{
+		{3, day, day.AddDate(0, 0, 1), 0},
+		{4, day, day.AddDate(0, 0, 1), 1},
+		{4, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2), 0},
+		{4, day.AddDate(0, 0, -1), day, 0},
+		{4, start.Add(30 * time.Minute), start.Add(2 * time.Hour), 1},
+		{4, start.Add(time.Hour), start.Add(2 * time.Hour), 0},
+	}
+	for _, test := range tests {
+		events, err := repo.ListByUser(test.userID, test.from, test.to)
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка: %v", err)
+		}
+		if len(events) != test.expected {
+			t.Errorf("Для user_id=%d [%s, %s) ожидается %d событий, получено %d",
+				test.userID, test.from.Format(time.DateOnly), test.to.Format(time.DateOnly), test.expected, len(events))
+		}
+	}
+
+	if err := repo.Delete(created.ID); err != nil {
+		t.Fatalf("Неожиданная ошибка при удалении: %v", err)
+	}
+	if _, err := repo.Get(created.ID); err != ErrEventNotFound {
+		t.Errorf("После удаления ожидается ErrEventNotFound, получено %v", err)
+	}
+	if err := repo.Delete(created.ID); err != ErrEventNotFound {
+		t.Errorf("Повторное удаление должно вернуть ErrEventNotFound, получено %v", err)
+	}
+}
+
+// TestMemoryRepositoryConcurrent нагружает хранилище параллельными операциями.
+// Смысл теста раскрывается при запуске с детектором гонок: go test -race
+func TestMemoryRepositoryConcurrent(t *testing.T) {
+	const (
+		users          = 8
+		workersPerUser = 4
+		eventsPerUser  = 50
+	)
+	repo := NewMemoryRepository()
+	day := time.Date(2019, 9, 9, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for user := 1; user <= users; user++ {
+		for worker := 0; worker < workersPerUser; worker++ {
+			wg.Add(1)
+			go func(userID int) {
+				defer wg.Done()
+				for i := 0; i < eventsPerUser; i++ {
+					event, err := repo.Create(Event{UserID: userID, Start: day.AddDate(0, 0, i%7), End: day.AddDate(0, 0, i%7+1), AllDay: true})
+					if err != nil {
+						t.Errorf("Неожиданная ошибка при создании: %v", err)
+						return
+					}
+					event.Note = "updated"
+					if err := repo.Update(event); err != nil {
+						t.Errorf("Неожиданная ошибка при обновлении: %v", err)
+					}
+					if _, err := repo.ListByUser(userID, day, day.AddDate(0, 0, 7)); err != nil {
+						t.Errorf("Неожиданная ошибка при чтении: %v", err)
+					}
+					// Каждое второе событие удаляется
+					if i%2 == 0 {
+						if err := repo.Delete(event.ID); err != nil {
+							t.Errorf("Неожиданная ошибка при удалении: %v", err)
+						}
+					}
+				}
+			}(user)
+		}
+	}
+
+	// Параллельно с записью события читаются по ID и перезаписываются.
+	// Перезаписываются только уже обновленные события, чтобы не откатить чужое обновление.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for id := 1; id <= users*workersPerUser*eventsPerUser; id++ {
+			if event, err := repo.Get(id); err == nil && event.Note == "updated" {
+				repo.Update(event)
+			}
+		}
+	}()
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	total := 0
+	for user := 1; user <= users; user++ {
+		events, err := repo.ListByUser(user, day, day.AddDate(0, 0, 7))
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка: %v", err)
+		}
+		for _, event := range events {
+			if seen[event.ID] {
+				t.Errorf("Событие %d встречается дважды", event.ID)
+			}
+			seen[event.ID] = true
+			if event.Note != "updated" {
+				t.Errorf("Событие %d потеряло обновление", event.ID)
+			}
+		}
+		total += len(events)
+	}
+	if expected := users * workersPerUser * eventsPerUser / 2; total != expected {
+		t.Errorf("Ожидается %d событий, получено %d", expected, total)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Метрики в текстовом формате Prometheus. Запросы учитывает LoggingMiddleware,
+// размер хранилища считывается в момент запроса /metrics.
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// unmatchedRoute — метка маршрута для путей, которые не обслуживает ни один обработчик
+const unmatchedRoute = "unmatched"
+
+// otherMethod — метка для нестандартных методов, чтобы клиент не мог плодить метки
+const otherMethod = "other"
+
+// knownMethods — методы, которые попадают в метки как есть
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+	http.MethodConnect: true, http.MethodTrace: true,
+}
+
+// latencyBuckets — границы корзин гистограммы времени ответа в секундах
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// healthRoutes — пробы оркестратора: их ответы 503 означают неготовность сервера,
+// а не ошибки клиентов, поэтому в calendar_http_errors_total они не учитываются
+var healthRoutes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// RequestObserver получает сведения о каждом обработанном запросе.
+// err — ошибка, отданная клиенту через writeError; nil, если ответ записан без нее.
+type RequestObserver interface {
+	ObserveRequest(r *http.Request, status int, err error, latency time.Duration)
+}
+
+type requestLabels struct {
+	route  string
+	method string
+	status int
+}
+
+type latencyLabels struct {
+	route  string
+	status int
+}
+
+// histogram — накопленные значения гистограммы: counts[i] — число наблюдений
+// не больше latencyBuckets[i] (без накопления, суммируются при выводе)
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics собирает метрики HTTP-сервера
+type Metrics struct {
+	// route сопоставляет запросу шаблон маршрута, чтобы число меток не росло от произвольных путей
+	route func(*http.Request) string
+	// countEvents возвращает число событий в хранилище
+	countEvents func() (int, error)
+
+	mu        sync.Mutex
+	requests  map[requestLabels]uint64
+	latencies map[latencyLabels]*histogram
+	errors    map[string]uint64
+}
+
+// NewMetrics создает набор метрик. route определяет маршрут запроса, countEvents — размер хранилища.
+func NewMetrics(route func(*http.Request) string, countEvents func() (int, error)) *Metrics {
+	return &Metrics{
+		route:       route,
+		countEvents: countEvents,
+		requests:    make(map[requestLabels]uint64),
+		latencies:   make(map[latencyLabels]*histogram),
+		errors:      make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) ObserveRequest(r *http.Request, status int, err error, latency time.Duration) {
+	route := m.route(r)
+	seconds := latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestLabels{route: route, method: methodLabel(r.Method), status: status}]++
+
+	h, ok := m.latencies[latencyLabels{route: route, status: status}]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[latencyLabels{route: route, status: status}] = h
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if class := errorClass(status, err); class != "" && !healthRoutes[route] {
+		m.errors[class]++
+	}
+}
+
+// methodLabel возвращает метку метода запроса
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+// muxRoute сопоставляет запросу шаблон маршрута mux. Шаблон "/" совпадает с любым путем,
+// поэтому под ним учитывается только сам корень, а остальные пути без маршрута — как unmatched.
+func muxRoute(mux *http.ServeMux) func(*http.Request) string {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		if pattern == "" || pattern == "/" && r.URL.Path != "/" {
+			return unmatchedRoute
+		}
+		return pattern
+	}
+}
+
+// errorClass относит ответ к классу ошибок: validation — ошибки запроса (400 и другие 4xx),
+// business — ошибки бизнес-логики (DomainError), auth — 401 и 403, rate_limit — 429,
+// internal — остальные 5xx. Бизнес-ошибку отличает от сбоя с тем же кодом 503 только тип err,
+// поэтому ответ без DomainError в business не попадает.
+func errorClass(status int, err error) string {
+	var domainErr *DomainError
+	switch {
+	case status < 400:
+		return ""
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "auth"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit"
+	case status < 500:
+		return "validation"
+	case errors.As(err, &domainErr):
+		return "business"
+	default:
+		return "internal"
+	}
+}
+
+// ServeHTTP отдает метрики в текстовом формате Prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	// Размер хранилища читается до блокировки, чтобы медленное хранилище не задерживало учет запросов
+	events, countErr := m.countEvents()
+	if countErr != nil {
+		slog.Error("count events for metrics", "error", countErr)
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(bw, "# HELP calendar_http_requests_total Number of HTTP requests by route, method and status.")
+	fmt.Fprintln(bw, "# TYPE calendar_http_requests_total counter")
+	requestKeys := make([]requestLabels, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(bw, "calendar_http_requests_total{route=%s,method=%s,status=\"%d\"} %d\n",
+			labelValue(key.route), labelValue(key.method), key.status, m.requests[key])
+	}
+
+	fmt.Fprintln(bw, "# HELP calendar_http_request_duration_seconds HTTP request latency by route and status.")
+	fmt.Fprintln(bw, "# TYPE calendar_http_request_duration_seconds histogram")
+	latencyKeys := make([]latencyLabels, 0, len(m.latencies))
+	for key := range m.latencies {
+		latencyKeys = append(latencyKeys, key)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool {
+		if latencyKeys[i].route != latencyKeys[j].route {
+			return latencyKeys[i].route < latencyKeys[j].route
+		}
+		return latencyKeys[i].status < latencyKeys[j].status
+	})
+	for _, key := range latencyKeys {
+		h := m.latencies[key]
+		labels := fmt.Sprintf("route=%s,status=\"%d\"", labelValue(key.route), key.status)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(bw, "calendar_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(bw, "calendar_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(bw, "calendar_http_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(bw, "calendar_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	fmt.Fprintln(bw, "# HELP calendar_http_errors_total Number of error responses by class.")
+	fmt.Fprintln(bw, "# TYPE calendar_http_errors_total counter")
+	for _, class := range []string{"validation", "business", "auth", "rate_limit", "internal"} {
+		fmt.Fprintf(bw, "calendar_http_errors_total{class=%q} %d\n", class, m.errors[class])
+	}
+
+	if countErr == nil {
+		fmt.Fprintln(bw, "# HELP calendar_events_stored Number of events in the storage.")
+		fmt.Fprintln(bw, "# TYPE calendar_events_stored gauge")
+		fmt.Fprintf(bw, "calendar_events_stored %d\n", events)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue возвращает значение метки в кавычках с экранированием по правилам Prometheus
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsValues(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/events_for_day", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {})
+	stored := 7
+	metrics := NewMetrics(muxRoute(mux), func() (int, error) { return stored, nil })
+
+	observe := func(method, path string, status int, latency time.Duration) {
+		metrics.ObserveRequest(httptest.NewRequest(method, path, nil), status, nil, latency)
+	}
+	observe(http.MethodGet, "/events_for_day", http.StatusOK, 3*time.Millisecond)
+	observe(http.MethodGet, "/events_for_day?user_id=1", http.StatusOK, 40*time.Millisecond)
+	observe(http.MethodGet, "/events_for_day", http.StatusBadRequest, 2*time.Second)
+	observe(http.MethodGet, "/events_for_day", http.StatusOK, 20*time.Second)
+	observe("PROPFIND", "/events_for_day", http.StatusMethodNotAllowed, time.Millisecond)
+	observe(http.MethodGet, "/", http.StatusOK, time.Millisecond)
+	observe(http.MethodGet, "/wp-admin/setup.php", http.StatusOK, time.Millisecond)
+	observe(http.MethodPost, "/no/such/path", http.StatusUnauthorized, time.Millisecond)
+	observe(http.MethodPost, "/events_for_day", http.StatusTooManyRequests, time.Millisecond)
+	observe(http.MethodPost, "/events_for_day", http.StatusInternalServerError, time.Millisecond)
+	// 503 — бизнес-ошибка только с DomainError; без нее это сбой, а у проб — неготовность сервера
+	metrics.ObserveRequest(httptest.NewRequest(http.MethodPost, "/events_for_day", nil),
+		http.StatusServiceUnavailable, ErrEventNotFound, time.Millisecond)
+	observe(http.MethodPost, "/events_for_day", http.StatusServiceUnavailable, time.Millisecond)
+	observe(http.MethodGet, "/readyz", http.StatusServiceUnavailable, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("Ожидается Content-Type %q, получено %q", metricsContentType, ct)
+	}
+	body := rec.Body.String()
+
+	want := []string{
+		`calendar_http_requests_total{route="/",method="GET",status="200"} 1`,
+		`calendar_http_requests_total{route="/events_for_day",method="GET",status="200"} 3`,
+		`calendar_http_requests_total{route="/events_for_day",method="GET",status="400"} 1`,
+		`calendar_http_requests_total{route="/events_for_day",method="other",status="405"} 1`,
+		`calendar_http_requests_total{route="unmatched",method="GET",status="200"} 1`,
+		`calendar_http_requests_total{route="unmatched",method="POST",status="401"} 1`,
+
+		// Корзины накопительные: 3 мс, 40 мс и 20 с при статусе 200
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="200",le="0.005"} 1`,
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="200",le="0.025"} 1`,
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="200",le="0.05"} 2`,
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="200",le="10"} 2`,
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="200",le="+Inf"} 3`,
+		`calendar_http_request_duration_seconds_sum{route="/events_for_day",status="200"} 20.043`,
+		`calendar_http_request_duration_seconds_count{route="/events_for_day",status="200"} 3`,
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="400",le="1"} 0`,
+		`calendar_http_request_duration_seconds_bucket{route="/events_for_day",status="400",le="2.5"} 1`,
+
+		`calendar_http_errors_total{class="validation"} 2`,
+		`calendar_http_errors_total{class="business"} 1`,
+		`calendar_http_errors_total{class="auth"} 1`,
+		`calendar_http_errors_total{class="rate_limit"} 1`,
+		`calendar_http_errors_total{class="internal"} 2`,
+		`calendar_http_requests_total{route="/readyz",method="GET",status="503"} 1`,
+		`calendar_events_stored 7`,
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(body, "\n") {
+		lines[line] = true
+	}
+	for _, line := range want {
+		if !lines[line] {
+			t.Errorf("Нет строки %s", line)
+		}
+	}
+	if strings.Contains(body, "PROPFIND") {
+		t.Error("Нестандартный метод не должен попадать в метки")
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+func TestMetricsThroughMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events_for_day", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, newValidationError("date is required"))
+	})
+	mux.HandleFunc("/delete_event", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, ErrEventNotFound)
+	})
+	mux.HandleFunc("/update_event", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, newStatusError(http.StatusServiceUnavailable, "storage is busy"))
+	})
+	metrics := NewMetrics(muxRoute(mux), func() (int, error) { return 0, errors.New("storage is down") })
+	handler := LoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), metrics, mux)
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events_for_day", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/delete_event", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/update_event", nil))
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`calendar_http_requests_total{route="/events_for_day",method="GET",status="400"} 2`,
+		`calendar_http_request_duration_seconds_count{route="/events_for_day",status="400"} 2`,
+		`calendar_http_errors_total{class="validation"} 2`,
+		// Класс определяется по типу ошибки из writeError, а не по коду 503
+		`calendar_http_errors_total{class="business"} 1`,
+		`calendar_http_errors_total{class="internal"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Нет строки %s в %s", line, body)
+		}
+	}
+	// Без размера хранилища метрика не выводится вовсе, а не показывает 0
+	if strings.Contains(body, "calendar_events_stored") {
+		t.Errorf("При ошибке хранилища calendar_events_stored не выводится: %s", body)
+	}
+}
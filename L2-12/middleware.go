@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// requestIDHeader — заголовок, в котором передается идентификатор запроса
+const requestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	tokenKey
+)
+
+// requestIDFromContext возвращает идентификатор текущего запроса
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// LoggingMiddleware логирует каждый обработанный HTTP-запрос: метод, путь, статус,
+// размер ответа, время обработки, адрес клиента и идентификатор запроса.
+// Идентификатор берется из заголовка X-Request-ID или генерируется и возвращается клиенту.
+// Если передан observer, те же сведения о запросе уходят в метрики.
+func LoggingMiddleware(logger *slog.Logger, observer RequestObserver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, requestID))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		latency := time.Since(start)
+
+		if observer != nil {
+			observer.ObserveRequest(r, rec.status, rec.err, latency)
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", r.URL.RawQuery),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Duration("latency", latency),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+		// Подробности внутренних ошибок клиенту не отдаются, поэтому остаются только в логе
+		if rec.err != nil && errorStatus(rec.err) == http.StatusInternalServerError {
+			logger.LogAttrs(r.Context(), slog.LevelError, "internal error",
+				slog.String("request_id", requestID),
+				slog.String("path", r.URL.Path),
+				slog.Any("error", rec.err),
+			)
+		}
+	})
+}
+
+// statusRecorder запоминает код ответа, количество записанных байт и ошибку, отданную writeError
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+	err         error
+}
+
+// findStatusRecorder находит statusRecorder среди оберток ResponseWriter
+func findStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	for {
+		switch v := w.(type) {
+		case *statusRecorder:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(data)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush нужен потоковым ответам, которые пишутся через обертку
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного ResponseWriter
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// validRequestID не пропускает в логи пустые, слишком длинные и непечатные идентификаторы
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggingMiddlewareLogsInternalErrors(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := LoggingMiddleware(logger, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal":
+			writeError(w, internalError(errStorageDown))
+		default:
+			writeError(w, newValidationError("invalid user_id"))
+		}
+	}))
+
+	for _, path := range []string{"/internal", "/validation"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(requestIDHeader, "req"+strings.TrimPrefix(path, "/"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if strings.Contains(rec.Body.String(), "/var/lib") {
+			t.Errorf("Ответ не должен раскрывать внутреннюю ошибку: %s", rec.Body.String())
+		}
+	}
+
+	var internal []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Неожиданная строка лога %q: %v", line, err)
+		}
+		if entry["msg"] == "internal error" {
+			internal = append(internal, entry)
+		}
+	}
+	// Ошибка валидации не пишется отдельной записью, внутренняя — пишется логгером сервера
+	if len(internal) != 1 {
+		t.Fatalf("Ожидается одна запись о внутренней ошибке, получено %v", internal)
+	}
+	if internal[0]["request_id"] != "reqinternal" || internal[0]["level"] != "ERROR" ||
+		!strings.Contains(internal[0]["error"].(string), errStorageDown.Error()) {
+		t.Errorf("Ожидается ошибка хранилища с идентификатором запроса, получено %v", internal[0])
+	}
+}
+
+// observedRequest — последний запрос, который LoggingMiddleware передал наблюдателю
+type observedRequest struct {
+	path   string
+	status int
+}
+
+func (o *observedRequest) ObserveRequest(r *http.Request, status int, _ error, _ time.Duration) {
+	o.path, o.status = r.URL.Path, status
+}
+
+// lastLogEntry разбирает последнюю JSON-запись лога
+func lastLogEntry(t *testing.T, logs *bytes.Buffer) map[string]any {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("Неожиданная строка лога %q: %v", lines[len(lines)-1], err)
+	}
+	return entry
+}
+
+func TestLoggingMiddlewareRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"client id", "trace-42", true},
+		{"missing id", "", false},
+		{"too long id", strings.Repeat("a", 65), false},
+		{"id with spaces", "trace 42", false},
+		{"id with control characters", "trace\x01", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			var seen string
+			handler := LoggingMiddleware(slog.New(slog.NewJSONHandler(&logs, nil)), nil,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					seen = requestIDFromContext(r.Context())
+				}))
+			req := httptest.NewRequest(http.MethodGet, "/events_for_day", nil)
+			if test.header != "" {
+				req.Header.Set(requestIDHeader, test.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(requestIDHeader)
+			if test.keep && id != test.header {
+				t.Errorf("Ожидается идентификатор клиента %q, получено %q", test.header, id)
+			}
+			if !test.keep && (id == test.header || !validRequestID(id)) {
+				t.Errorf("Ожидается новый идентификатор вместо %q, получено %q", test.header, id)
+			}
+			if seen != id {
+				t.Errorf("Обработчик должен видеть идентификатор %q, получено %q", id, seen)
+			}
+			if got := lastLogEntry(t, &logs)["request_id"]; got != id {
+				t.Errorf("В логе ожидается идентификатор %q, получено %v", id, got)
+			}
+		})
+	}
+}
+
+func TestLoggingMiddlewareStatusAndBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   int
+	}{
+		{"empty response", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+		{"body without header", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}, http.StatusOK, 5},
+		{"explicit status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+			w.Write([]byte("\n"))
+		}, http.StatusCreated, 3},
+		{"second status is ignored", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusNotFound, 0},
+		{"status after body is ignored", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+			w.WriteHeader(http.StatusBadRequest)
+		}, http.StatusOK, 2},
+		{"error response", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, newValidationError("invalid user_id"))
+		}, http.StatusBadRequest, len(`{"error":"invalid user_id"}`) + 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			var observed observedRequest
+			handler := LoggingMiddleware(slog.New(slog.NewJSONHandler(&logs, nil)), &observed, test.handler)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/create_event?user_id=1", nil))
+
+			if observed.status != test.status || observed.path != "/create_event" {
+				t.Errorf("Наблюдатель должен получить %d для /create_event, получено %+v", test.status, observed)
+			}
+			entry := lastLogEntry(t, &logs)
+			if entry["status"] != float64(test.status) || entry["bytes"] != float64(test.bytes) {
+				t.Errorf("В логе ожидается статус %d и %d байт, получено %v и %v",
+					test.status, test.bytes, entry["status"], entry["bytes"])
+			}
+			if entry["method"] != http.MethodPost || entry["path"] != "/create_event" || entry["query"] != "user_id=1" {
+				t.Errorf("В логе ожидаются метод, путь и запрос, получено %v", entry)
+			}
+			if rec.Body.Len() != test.bytes {
+				t.Errorf("Клиент должен получить %d байт, получено %d", test.bytes, rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestStatusRecorderPassThrough(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var checks []string
+	handler := LoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), nil,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Потоковые ответы сбрасывают буфер и через интерфейс, и через ResponseController
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			if !rec.Flushed {
+				checks = append(checks, "Flush должен дойти до исходного ResponseWriter")
+			}
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				checks = append(checks, "ResponseController.Flush: "+err.Error())
+			}
+			if inner := w.(interface{ Unwrap() http.ResponseWriter }).Unwrap(); inner != rec {
+				checks = append(checks, "Unwrap должен вернуть исходный ResponseWriter")
+			}
+			if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				checks = append(checks, "Hijack без поддержки в исходном ResponseWriter должен вернуть ошибку")
+			}
+			if findStatusRecorder(w) == nil {
+				checks = append(checks, "writeError должна находить statusRecorder")
+			}
+		}))
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events/stream", nil))
+	for _, check := range checks {
+		t.Error(check)
+	}
+	if rec.Body.String() != "data: 1\n\n" {
+		t.Errorf("Ожидается тело потока, получено %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec — описание API в формате OpenAPI 3. Документ поддерживается вручную:
+// новый метод API добавляется и в openapi.json, и в пакет client. Расхождения описания
+// с маршрутами сервера и методами клиента ловит TestOpenAPIMatchesRoutesAndClient.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Описание API для генераторов клиентов и Swagger UI
+func (s *server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(openAPISpec)
+}
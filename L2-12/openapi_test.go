@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ZnNr/wb-l2/L2-12/client"
+)
+
+// specOperation — операция из openapi.json
+type specOperation struct {
+	path   string
+	method string
+	id     string
+	tags   []string
+}
+
+// specOperations возвращает все операции из описания API
+func specOperations(t *testing.T) []specOperation {
+	t.Helper()
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string   `json:"operationId"`
+			Tags        []string `json:"tags"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json не разбирается: %v", err)
+	}
+	var operations []specOperation
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			operations = append(operations, specOperation{path: path, method: strings.ToUpper(method), id: op.OperationID, tags: op.Tags})
+		}
+	}
+	return operations
+}
+
+// clientMethodNames — методы пакета client, названные не по operationId
+var clientMethodNames = map[string]string{
+	"rsvpEvent": "RespondToEvent",
+}
+
+// clientMethod возвращает имя метода client.Client для операции с тегом events; "" — операции нет в клиенте.
+// Клиент покрывает методы событий, кроме потока SSE: его читают через EventSource.
+func clientMethod(op specOperation) string {
+	if len(op.tags) == 0 || op.tags[0] != "events" || op.id == "streamEvents" {
+		return ""
+	}
+	if name, ok := clientMethodNames[op.id]; ok {
+		return name
+	}
+	return strings.ToUpper(op.id[:1]) + op.id[1:]
+}
+
+// Описание API, маршруты сервера и пакет client поддерживаются вручную; тест ловит расхождения между ними
+func TestOpenAPIMatchesRoutesAndClient(t *testing.T) {
+	env := newTestEnv(t, nil)
+	mux, _ := env.srv.router()
+	clientType := reflect.TypeOf(&client.Client{})
+	described := make(map[string]bool)
+
+	for _, op := range specOperations(t) {
+		if op.id == "" {
+			t.Errorf("%s %s: нет operationId", op.method, op.path)
+		}
+		// Каждая операция обслуживается своим маршрутом, а не корневым обработчиком
+		if _, pattern := mux.Handler(httptest.NewRequest(op.method, op.path, nil)); pattern != op.path {
+			t.Errorf("%s %s: маршрут не зарегистрирован, запрос попадает в %q", op.method, op.path, pattern)
+		}
+		// Обработчик принимает метод из описания
+		if resp := env.do(t, testRequest{method: op.method, path: op.path}); resp.status == http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: метод не поддерживается обработчиком", op.method, op.path)
+		}
+		if name := clientMethod(op); name != "" {
+			described[name] = true
+			if _, ok := clientType.MethodByName(name); !ok {
+				t.Errorf("%s %s: в пакете client нет метода %s", op.method, op.path, name)
+			}
+		}
+	}
+
+	// И наоборот: у каждого метода клиента есть операция в описании
+	for i := 0; i < clientType.NumMethod(); i++ {
+		if name := clientType.Method(i).Name; !described[name] {
+			t.Errorf("Метод client.%s не соответствует ни одной операции openapi.json", name)
+		}
+	}
+}
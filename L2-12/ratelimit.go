@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ограничение частоты запросов: у каждого IP-адреса общее «ведро токенов», а у каждого клиента —
+// свои ведра для чтения и для записи. Клиент определяется по токену аутентификации, а без него — по IP-адресу.
+
+// rateLimiterSweepInterval — как часто из памяти удаляются ведра неактивных клиентов
+const rateLimiterSweepInterval = time.Minute
+
+// tokenBucket — ведро клиента: tokens пополняются со скоростью rate до burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter — набор ведер одного класса запросов
+type rateLimiter struct {
+	rate  float64 // запросов в секунду
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{rate: limit.RPS, burst: float64(limit.Burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// allow списывает токен из ведра клиента key. Если токенов нет, возвращает false и время,
+// через которое появится следующий.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep удаляет ведра, которые успели наполниться: для них новое ведро ничем не отличается
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// RateLimiter ограничивает частоту запросов в два этапа: до аутентификации — по IP-адресу,
+// чтобы запросы без токена или с неверным токеном тоже упирались в лимит, и после нее —
+// по клиенту, с отдельными лимитами для чтения и записи
+type RateLimiter struct {
+	addresses, reads, writes *rateLimiter
+	now                      func() time.Time
+}
+
+// NewRateLimiter создает ограничитель; now задает часы, по которым пополняются ведра
+func NewRateLimiter(cfg RateLimitConfig, now func() time.Time) *RateLimiter {
+	return &RateLimiter{
+		addresses: newRateLimiter(cfg.Address),
+		reads:     newRateLimiter(cfg.Read),
+		writes:    newRateLimiter(cfg.Write),
+		now:       now,
+	}
+}
+
+// ByAddress ограничивает все запросы с одного IP-адреса. Должен стоять перед AuthMiddleware.
+func (l *RateLimiter) ByAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.addresses.allow(remoteIP(r), l.now()); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ByClient ограничивает запросы каждого клиента. GET и HEAD считаются чтением, остальные
+// методы — записью; у классов отдельные лимиты. Должен стоять после AuthMiddleware,
+// чтобы аутентифицированные клиенты учитывались по токену, а не по адресу.
+func (l *RateLimiter) ByClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limiter := l.writes
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			limiter = l.reads
+		}
+		if ok, wait := limiter.allow(rateLimitKey(r), l.now()); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooManyRequests отвечает 429 с заголовком Retry-After в целых секундах
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, newStatusError(http.StatusTooManyRequests,
+		"rate limit exceeded, retry in "+strconv.Itoa(seconds)+"s"))
+}
+
+// rateLimitKey определяет клиента: по ID токена, если запрос аутентифицирован, иначе по IP
+func rateLimitKey(r *http.Request) string {
+	if token, ok := tokenFromContext(r.Context()); ok {
+		return "token:" + token.ID
+	}
+	return "ip:" + remoteIP(r)
+}
+
+// remoteIP возвращает адрес клиента без порта
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
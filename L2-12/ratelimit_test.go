@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock — часы, которые двигает сам тест
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// limitedRequest выполняет запрос с адреса ip и возвращает ответ
+func limitedRequest(handler http.Handler, method, ip, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/events_for_day", nil)
+	req.RemoteAddr = ip + ":40000"
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimiterByClient(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read:    RateLimit{RPS: 1, Burst: 2},
+		Write:   RateLimit{RPS: 0.5, Burst: 1},
+		Address: RateLimit{RPS: 1000, Burst: 1000},
+	}, clock.Now)
+	handler := limiter.ByClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		method, ip string
+		status     int
+		retryAfter string
+	}{
+		{"first read", 0, http.MethodGet, "10.0.0.1", http.StatusOK, ""},
+		{"burst read", 0, http.MethodGet, "10.0.0.1", http.StatusOK, ""},
+		{"read over burst", 0, http.MethodGet, "10.0.0.1", http.StatusTooManyRequests, "1"},
+		{"write has its own bucket", 0, http.MethodPost, "10.0.0.1", http.StatusOK, ""},
+		{"write over burst", 0, http.MethodPost, "10.0.0.1", http.StatusTooManyRequests, "2"},
+		{"another client", 0, http.MethodGet, "10.0.0.2", http.StatusOK, ""},
+		// Половина токена — ждать еще полсекунды, Retry-After округляется вверх
+		{"read before refill", 500 * time.Millisecond, http.MethodGet, "10.0.0.1", http.StatusTooManyRequests, "1"},
+		{"read after refill", 500 * time.Millisecond, http.MethodGet, "10.0.0.1", http.StatusOK, ""},
+		{"refilled token is spent", 0, http.MethodGet, "10.0.0.1", http.StatusTooManyRequests, "1"},
+		{"write after refill", time.Second, http.MethodPost, "10.0.0.1", http.StatusOK, ""},
+		// Ведро не наполняется больше burst, сколько бы ни прошло времени
+		{"burst after long pause", time.Hour, http.MethodGet, "10.0.0.1", http.StatusOK, ""},
+		{"second of burst after long pause", 0, http.MethodGet, "10.0.0.1", http.StatusOK, ""},
+		{"over burst after long pause", 0, http.MethodGet, "10.0.0.1", http.StatusTooManyRequests, "1"},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		rec := limitedRequest(handler, step.method, step.ip, "")
+		if rec.Code != step.status {
+			t.Fatalf("%s: ожидается код %d, получено %d: %s", step.name, step.status, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("%s: ожидается Retry-After %q, получено %q", step.name, step.retryAfter, got)
+		}
+	}
+
+	// Публичные пути не ограничиваются
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "10.0.0.1:40000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Публичный путь не должен ограничиваться, получено %d", rec.Code)
+	}
+}
+
+func TestRateLimiterByAddressBeforeAuth(t *testing.T) {
+	tokens, err := openTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии файла токенов: %v", err)
+	}
+	secret, _, _ := tokens.Issue(1, false)
+
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read:    RateLimit{RPS: 1, Burst: 2},
+		Write:   RateLimit{RPS: 1, Burst: 2},
+		Address: RateLimit{RPS: 1, Burst: 3},
+	}, clock.Now)
+	handler := limiter.ByAddress(AuthMiddleware(tokens, limiter.ByClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))))
+
+	steps := []struct {
+		name       string
+		ip, secret string
+		status     int
+	}{
+		{"unknown token", "10.0.0.1", tokenPrefix + "guess1", http.StatusUnauthorized},
+		{"another unknown token", "10.0.0.1", tokenPrefix + "guess2", http.StatusUnauthorized},
+		{"missing token", "10.0.0.1", "", http.StatusUnauthorized},
+		// Адрес исчерпал лимит на неудачных попытках: до проверки токена дело не доходит
+		{"guessing over address limit", "10.0.0.1", tokenPrefix + "guess3", http.StatusTooManyRequests},
+		{"valid token from limited address", "10.0.0.1", secret, http.StatusTooManyRequests},
+		// Лимит клиента считается по токену, а не по адресу
+		{"valid token from another address", "10.0.0.2", secret, http.StatusOK},
+		{"same token from third address", "10.0.0.3", secret, http.StatusOK},
+		{"token over client limit", "10.0.0.4", secret, http.StatusTooManyRequests},
+	}
+	for _, step := range steps {
+		rec := limitedRequest(handler, http.MethodGet, step.ip, step.secret)
+		if rec.Code != step.status {
+			t.Fatalf("%s: ожидается код %d, получено %d: %s", step.name, step.status, rec.Code, rec.Body)
+		}
+		if step.status == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: ответ 429 должен содержать Retry-After", step.name)
+		}
+	}
+
+	clock.Advance(time.Second)
+	if rec := limitedRequest(handler, http.MethodGet, "10.0.0.1", secret); rec.Code != http.StatusOK {
+		t.Errorf("После пополнения ведра адреса запрос должен пройти, получено %d", rec.Code)
+	}
+}
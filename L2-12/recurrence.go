@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Частоты повторения (подмножество FREQ из RFC 5545)
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// maxRecurrencePeriods ограничивает перебор периодов при разворачивании правила,
+// чтобы запрос за далекую дату не мог занять сервер надолго
+const maxRecurrencePeriods = 100000
+
+// maxMonthlyByDay ограничивает BYDAY ежемесячного правила: каждый день списка множит
+// повторения в каждом периоде. У еженедельного правила различных дней не больше семи.
+const maxMonthlyByDay = 10
+
+// Recurrence — правило повторения события в духе RRULE из RFC 5545
+type Recurrence struct {
+	// Freq — DAILY, WEEKLY, MONTHLY или YEARLY
+	Freq string `json:"freq"`
+	// Interval — шаг повторения в единицах Freq, по умолчанию 1
+	Interval int `json:"interval,omitempty"`
+	// ByDay — дни недели: "MO", "WE" для WEEKLY; для MONTHLY допускается номер: "1MO", "-1FR"
+	ByDay []WeekdayNum `json:"by_day,omitempty"`
+	// Count — сколько всего раз повторяется событие (0 — без ограничения)
+	Count int `json:"count,omitempty"`
+	// Until — момент, после которого повторений нет; повторение ровно в Until еще происходит
+	Until *time.Time `json:"until,omitempty"`
+	// Exceptions — начала пропускаемых повторений (EXDATE)
+	Exceptions []time.Time `json:"exceptions,omitempty"`
+}
+
+// WeekdayNum — день недели с необязательным порядковым номером внутри месяца
+// (1 — первый, -1 — последний, 0 — каждый)
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func (w WeekdayNum) String() string {
+	code := strings.ToUpper(w.Weekday.String()[:2])
+	if w.N != 0 {
+		return strconv.Itoa(w.N) + code
+	}
+	return code
+}
+
+func (w WeekdayNum) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w *WeekdayNum) UnmarshalText(text []byte) error {
+	parsed, err := parseWeekdayNum(string(text))
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid weekday %q", s)
+	}
+	weekday, ok := weekdayCodes[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid weekday %q", s)
+	}
+	n := 0
+	if prefix := s[:len(s)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("invalid weekday %q", s)
+		}
+	}
+	return WeekdayNum{Weekday: weekday, N: n}, nil
+}
+
+// parseRecurrence разбирает параметры rrule (например "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10")
+// и exdate (значения через запятую) события, начинающегося в start. Пустой rrule означает
+// событие без повторения. UNTIL, заданный датой, включает весь этот день, а дата в exdate
+// означает повторение этого дня во время начала события.
+func parseRecurrence(rrule, exdate string, start time.Time) (*Recurrence, error) {
+	loc := start.Location()
+	rrule = strings.TrimPrefix(strings.TrimSpace(rrule), "RRULE:")
+	if rrule == "" {
+		if exdate != "" {
+			return nil, newValidationError("exdate requires rrule")
+		}
+		return nil, nil
+	}
+
+	rec := &Recurrence{}
+	for _, part := range strings.Split(rrule, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, newValidationError(fmt.Sprintf("invalid rrule part %q", part))
+		}
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rec.Freq = strings.ToUpper(value)
+		case "INTERVAL":
+			rec.Interval, err = strconv.Atoi(value)
+		case "COUNT":
+			rec.Count, err = strconv.Atoi(value)
+		case "UNTIL":
+			until, isDate, parseErr := parseRecurrenceDate(value, loc)
+			if isDate {
+				until = endOfDay(until)
+			}
+			rec.Until, err = &until, parseErr
+		case "BYDAY":
+			// Повторы дня ничего не добавляют к правилу и отбрасываются
+			seen := make(map[WeekdayNum]bool)
+			for _, day := range strings.Split(value, ",") {
+				var wd WeekdayNum
+				wd, err = parseWeekdayNum(day)
+				if err != nil {
+					break
+				}
+				if !seen[wd] {
+					seen[wd] = true
+					rec.ByDay = append(rec.ByDay, wd)
+				}
+			}
+		case "WKST":
+			// Недели всегда начинаются с понедельника; WKST принимается ради совместимости с .ics
+		default:
+			return nil, newValidationError(fmt.Sprintf("unsupported rrule part %q", key))
+		}
+		if err != nil {
+			return nil, newValidationError(fmt.Sprintf("invalid rrule %s: %v", strings.ToUpper(key), err))
+		}
+	}
+
+	if exdate != "" {
+		for _, value := range strings.Split(exdate, ",") {
+			ex, isDate, err := parseRecurrenceDate(value, loc)
+			if err != nil {
+				return nil, newValidationError(fmt.Sprintf("invalid exdate %q", value))
+			}
+			if isDate {
+				ex = time.Date(ex.Year(), ex.Month(), ex.Day(), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), loc)
+			}
+			rec.Exceptions = append(rec.Exceptions, ex)
+		}
+	}
+
+	if err := rec.Validate(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// parseRecurrenceDate принимает даты (2006-01-02, 20060102) и моменты времени
+// (20060102T150405Z, 20060102T150405 и 2006-01-02T15:04:05 в поясе loc, RFC 3339).
+// Второй результат сообщает, что значение — дата без времени.
+func parseRecurrenceDate(value string, loc *time.Location) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, true, nil
+		}
+	}
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t.In(loc), false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), false, nil
+	}
+	for _, layout := range []string{"20060102T150405", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+}
+
+// Validate проверяет правило повторения
+func (r *Recurrence) Validate() error {
+	switch r.Freq {
+	case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+	case "":
+		return newValidationError("rrule FREQ is required")
+	default:
+		return newValidationError(fmt.Sprintf("unsupported rrule FREQ %q", r.Freq))
+	}
+	if r.Interval < 0 {
+		return newValidationError("rrule INTERVAL must be positive")
+	}
+	if r.Count < 0 {
+		return newValidationError("rrule COUNT must be positive")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return newValidationError("rrule COUNT and UNTIL are mutually exclusive")
+	}
+	seen := make(map[WeekdayNum]bool, len(r.ByDay))
+	for _, day := range r.ByDay {
+		if day.N != 0 && r.Freq != FreqMonthly {
+			return newValidationError("numbered BYDAY is supported only for MONTHLY rrule")
+		}
+		// Повторяющийся день давал бы одно и то же повторение дважды
+		if seen[day] {
+			return newValidationError(fmt.Sprintf("duplicate rrule BYDAY %s", day))
+		}
+		seen[day] = true
+	}
+	if len(r.ByDay) > 0 && (r.Freq == FreqDaily || r.Freq == FreqYearly) {
+		return newValidationError("BYDAY is supported only for WEEKLY and MONTHLY rrule")
+	}
+	if r.Freq == FreqMonthly && len(r.ByDay) > maxMonthlyByDay {
+		return newValidationError(fmt.Sprintf("rrule BYDAY allows at most %d days for MONTHLY rrule", maxMonthlyByDay))
+	}
+	return nil
+}
+
+// String возвращает правило в синтаксисе RRULE (без EXDATE).
+// UNTIL в конце дня записывается датой, остальные — моментом в UTC.
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		if r.Until.Equal(endOfDay(*r.Until)) {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences возвращает начала повторений события, начинающегося в start, попадающие в [from, to).
+// Повторения отсчитываются от start, поэтому COUNT учитывает и те, что раньше from.
+func (r *Recurrence) Occurrences(start, from, to time.Time) []time.Time {
+	return r.occurrences(start, from, to, 0)
+}
+
+// occurrences — Occurrences, останавливающийся после limit найденных повторений (0 — без ограничения)
+func (r *Recurrence) occurrences(start, from, to time.Time, limit int) []time.Time {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 1
+	}
+
+	// Без COUNT периоды до from не влияют на результат, и перебор начинается с периода,
+	// предшествующего from: так запрос за далекую дату не упирается в maxRecurrencePeriods.
+	first := 0
+	if r.Count == 0 && from.After(start) {
+		first = max(r.periodsBetween(start, from)/interval-1, 0)
+	}
+
+	var result []time.Time
+	emitted := 0
+	for period := first; period < first+maxRecurrencePeriods; period++ {
+		candidates := r.periodCandidates(start, period*interval)
+		if len(candidates) == 0 && r.periodStart(start, period*interval).After(to) {
+			break
+		}
+		for _, t := range candidates {
+			if t.Before(start) {
+				continue
+			}
+			if !t.Before(to) || (r.Until != nil && t.After(*r.Until)) {
+				return result
+			}
+			emitted++
+			if r.Count > 0 && emitted > r.Count {
+				return result
+			}
+			if !t.Before(from) && !r.isException(t) {
+				result = append(result, t)
+				if len(result) == limit {
+					return result
+				}
+			}
+		}
+	}
+	return result
+}
+
+// mayOccurIn сообщает, может ли событие с учетом повторений пересечься с [from, to).
+// Хранилища отбирают по нему кандидатов, а точное разворачивание повторений делает сервис.
+func (e Event) mayOccurIn(from, to time.Time) bool {
+	if !e.Start.Before(to) {
+		return false
+	}
+	if e.Recurrence == nil {
+		return e.End.After(from)
+	}
+	return e.Recurrence.Until == nil || e.Recurrence.lastEnd(e.Duration()).After(from)
+}
+
+// occursIn сообщает, пересекается ли с [from, to) событие или хотя бы одно его повторение
+func (e Event) occursIn(from, to time.Time) bool {
+	if e.Recurrence == nil {
+		return e.Start.Before(to) && e.End.After(from)
+	}
+	since := from.Add(-e.Duration() + time.Nanosecond)
+	return len(e.Recurrence.occurrences(e.Start, since, to, 1)) > 0
+}
+
+// lastEnd возвращает момент, позже которого не может закончиться ни одно повторение
+// длительностью duration; для правила без UNTIL не вызывается
+func (r *Recurrence) lastEnd(duration time.Duration) time.Time {
+	return r.Until.Add(duration)
+}
+
+// expandOccurrences разворачивает события в отдельные повторения, пересекающиеся с [from, to).
+// Повторение — копия события с тем же ID, началом и концом конкретного повторения.
+func expandOccurrences(events []Event, from, to time.Time) []Event {
+	result := []Event{}
+	for _, event := range events {
+		if event.Recurrence == nil {
+			if event.Start.Before(to) && event.End.After(from) {
+				result = append(result, event)
+			}
+			continue
+		}
+		// Повторение, начавшееся раньше from, попадает в интервал, если еще не закончилось
+		since := from.Add(-event.Duration() + time.Nanosecond)
+		for _, start := range event.Recurrence.Occurrences(event.Start, since, to) {
+			result = append(result, event.occurrenceAt(start))
+		}
+	}
+	sortEvents(result)
+	return result
+}
+
+// occurrenceAt возвращает повторение события, начинающееся в start.
+// События на весь день сдвигаются на календарные дни, чтобы переход на летнее время
+// не сдвигал их границы с полуночи.
+func (e Event) occurrenceAt(start time.Time) Event {
+	occurrence := e
+	occurrence.Start = start
+	if e.AllDay {
+		days := int(math.Round(e.End.Sub(e.Start).Hours() / 24))
+		occurrence.End = start.AddDate(0, 0, days)
+	} else {
+		occurrence.End = start.Add(e.Duration())
+	}
+	return occurrence
+}
+
+// periodsBetween возвращает номер периода (в единицах Freq), в который попадает t
+func (r *Recurrence) periodsBetween(start, t time.Time) int {
+	t = t.In(start.Location())
+	switch r.Freq {
+	case FreqDaily:
+		return daysBetween(start, t)
+	case FreqWeekly:
+		startWeek, _ := weekRange(start)
+		week, _ := weekRange(t)
+		return daysBetween(startWeek, week) / 7
+	case FreqMonthly:
+		return (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+	default:
+		return t.Year() - start.Year()
+	}
+}
+
+// daysBetween возвращает число календарных дней от даты a до даты b
+func daysBetween(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da).Hours() / 24)
+}
+
+// periodStart возвращает начало периода с номером offset (в единицах Freq) от начала события
+func (r *Recurrence) periodStart(start time.Time, offset int) time.Time {
+	switch r.Freq {
+	case FreqDaily:
+		return start.AddDate(0, 0, offset)
+	case FreqWeekly:
+		weekStart, _ := weekRange(start)
+		return weekStart.AddDate(0, 0, 7*offset)
+	case FreqMonthly:
+		return time.Date(start.Year(), start.Month()+time.Month(offset), 1, 0, 0, 0, 0, start.Location())
+	default:
+		return time.Date(start.Year()+offset, 1, 1, 0, 0, 0, 0, start.Location())
+	}
+}
+
+// periodCandidates возвращает упорядоченные начала повторений внутри периода
+func (r *Recurrence) periodCandidates(start time.Time, offset int) []time.Time {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	}
+	ps := r.periodStart(start, offset)
+
+	switch r.Freq {
+	case FreqDaily:
+		return []time.Time{ps}
+	case FreqWeekly:
+		if len(r.ByDay) == 0 {
+			return []time.Time{start.AddDate(0, 0, 7*offset)}
+		}
+		var result []time.Time
+		for _, day := range r.ByDay {
+			d := ps.AddDate(0, 0, (int(day.Weekday)+6)%7)
+			result = append(result, at(d.Year(), d.Month(), d.Day()))
+		}
+		sortTimes(result)
+		return result
+	case FreqMonthly:
+		if len(r.ByDay) == 0 {
+			// Месяцы, в которых нет нужного числа (например, 31-го), пропускаются, как в RFC 5545
+			if start.Day() > daysIn(ps.Year(), ps.Month()) {
+				return nil
+			}
+			return []time.Time{at(ps.Year(), ps.Month(), start.Day())}
+		}
+		var result []time.Time
+		for _, day := range r.ByDay {
+			for _, d := range weekdaysInMonth(ps.Year(), ps.Month(), day) {
+				result = append(result, at(ps.Year(), ps.Month(), d))
+			}
+		}
+		sortTimes(result)
+		return result
+	default:
+		// 29 февраля повторяется только в високосные годы
+		if start.Day() > daysIn(ps.Year(), start.Month()) {
+			return nil
+		}
+		return []time.Time{at(ps.Year(), start.Month(), start.Day())}
+	}
+}
+
+func (r *Recurrence) isException(t time.Time) bool {
+	for _, ex := range r.Exceptions {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// weekdaysInMonth возвращает числа месяца, соответствующие дню недели с учетом порядкового номера
+func weekdaysInMonth(year int, month time.Month, day WeekdayNum) []int {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	firstDay := 1 + (int(day.Weekday)-int(first.Weekday())+7)%7
+	var days []int
+	for d := firstDay; d <= daysIn(year, month); d += 7 {
+		days = append(days, d)
+	}
+	switch {
+	case day.N > 0 && day.N <= len(days):
+		return []int{days[day.N-1]}
+	case day.N < 0 && -day.N <= len(days):
+		return []int{days[len(days)+day.N]}
+	case day.N == 0:
+		return days
+	default:
+		return nil
+	}
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func endOfDay(t time.Time) time.Time {
+	_, end := dayRange(t)
+	return end.Add(-time.Nanosecond)
+}
+
+func sortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+}
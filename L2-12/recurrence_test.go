@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Нет базы часовых поясов: %v", err)
+	}
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		rrule    string
+		exdate   string
+		from, to time.Time
+		want     []string
+	}{
+		{"last friday of month", utc("2024-01-26T10:00:00Z"), "FREQ=MONTHLY;BYDAY=-1FR", "",
+			utc("2024-01-01T00:00:00Z"), utc("2024-06-01T00:00:00Z"),
+			[]string{"2024-01-26T10:00:00Z", "2024-02-23T10:00:00Z", "2024-03-29T10:00:00Z", "2024-04-26T10:00:00Z", "2024-05-31T10:00:00Z"}},
+		{"second tuesday every other month", utc("2024-01-09T10:00:00Z"), "FREQ=MONTHLY;INTERVAL=2;BYDAY=2TU", "",
+			utc("2024-01-01T00:00:00Z"), utc("2024-07-01T00:00:00Z"),
+			[]string{"2024-01-09T10:00:00Z", "2024-03-12T10:00:00Z", "2024-05-14T10:00:00Z"}},
+		{"yearly from february 29", utc("2024-02-29T12:00:00Z"), "FREQ=YEARLY", "",
+			utc("2024-01-01T00:00:00Z"), utc("2033-01-01T00:00:00Z"),
+			[]string{"2024-02-29T12:00:00Z", "2028-02-29T12:00:00Z", "2032-02-29T12:00:00Z"}},
+		{"monthly on the 31st", utc("2024-01-31T08:00:00Z"), "FREQ=MONTHLY", "",
+			utc("2024-01-01T00:00:00Z"), utc("2024-08-01T00:00:00Z"),
+			[]string{"2024-01-31T08:00:00Z", "2024-03-31T08:00:00Z", "2024-05-31T08:00:00Z", "2024-07-31T08:00:00Z"}},
+		{"count includes exceptions", utc("2024-03-01T09:00:00Z"), "FREQ=DAILY;COUNT=4", "2024-03-02",
+			utc("2024-03-01T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-01T09:00:00Z", "2024-03-03T09:00:00Z", "2024-03-04T09:00:00Z"}},
+		{"count is counted from start", utc("2024-03-01T09:00:00Z"), "FREQ=WEEKLY;COUNT=3", "",
+			utc("2024-03-10T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-15T09:00:00Z"}},
+		{"exdate at occurrence instant", utc("2024-03-01T09:00:00Z"), "FREQ=DAILY;COUNT=3", "20240302T090000Z",
+			utc("2024-03-01T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-01T09:00:00Z", "2024-03-03T09:00:00Z"}},
+		{"exdate at another time of day", utc("2024-03-01T09:00:00Z"), "FREQ=DAILY;COUNT=3", "20240302T100000Z",
+			utc("2024-03-01T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-01T09:00:00Z", "2024-03-02T09:00:00Z", "2024-03-03T09:00:00Z"}},
+		{"until at occurrence instant", utc("2024-03-01T09:00:00Z"), "FREQ=DAILY;UNTIL=20240303T090000Z", "",
+			utc("2024-03-01T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-01T09:00:00Z", "2024-03-02T09:00:00Z", "2024-03-03T09:00:00Z"}},
+		{"until before occurrence on the same day", utc("2024-03-01T09:00:00Z"), "FREQ=DAILY;UNTIL=20240303T085959Z", "",
+			utc("2024-03-01T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-01T09:00:00Z", "2024-03-02T09:00:00Z"}},
+		{"until date includes the whole day", utc("2024-03-01T09:00:00Z"), "FREQ=DAILY;UNTIL=20240303", "",
+			utc("2024-03-01T00:00:00Z"), utc("2024-04-01T00:00:00Z"),
+			[]string{"2024-03-01T09:00:00Z", "2024-03-02T09:00:00Z", "2024-03-03T09:00:00Z"}},
+		// Время повторений сохраняется по местным часам при переходе на летнее время
+		{"daily across dst", time.Date(2024, 3, 9, 9, 30, 0, 0, newYork), "FREQ=DAILY", "",
+			time.Date(2024, 3, 9, 0, 0, 0, 0, newYork), time.Date(2024, 3, 12, 0, 0, 0, 0, newYork),
+			[]string{"2024-03-09T09:30:00-05:00", "2024-03-10T09:30:00-04:00", "2024-03-11T09:30:00-04:00"}},
+		{"weekly across dst", time.Date(2024, 10, 28, 18, 0, 0, 0, newYork), "FREQ=WEEKLY;BYDAY=MO,FR", "",
+			time.Date(2024, 10, 28, 0, 0, 0, 0, newYork), time.Date(2024, 11, 9, 0, 0, 0, 0, newYork),
+			[]string{"2024-10-28T18:00:00-04:00", "2024-11-01T18:00:00-04:00", "2024-11-04T18:00:00-05:00", "2024-11-08T18:00:00-05:00"}},
+		// Запрос далеко от начала не должен упираться в ограничение перебора периодов
+		{"far from start", utc("1800-01-01T07:00:00Z"), "FREQ=DAILY", "",
+			utc("2100-01-01T00:00:00Z"), utc("2100-01-03T00:00:00Z"),
+			[]string{"2100-01-01T07:00:00Z", "2100-01-02T07:00:00Z"}},
+		{"far from start with interval", utc("1800-01-06T07:00:00Z"), "FREQ=WEEKLY;INTERVAL=3;BYDAY=MO", "",
+			utc("2100-01-01T00:00:00Z"), utc("2100-02-01T00:00:00Z"),
+			[]string{"2100-01-11T07:00:00Z"}},
+		// Повтор дня в BYDAY не дублирует повторения и не расходует COUNT
+		{"duplicate byday", utc("2024-01-01T10:00:00Z"), "FREQ=WEEKLY;BYDAY=MO,mo,MO;COUNT=4", "",
+			utc("2024-01-01T00:00:00Z"), utc("2024-03-01T00:00:00Z"),
+			[]string{"2024-01-01T10:00:00Z", "2024-01-08T10:00:00Z", "2024-01-15T10:00:00Z", "2024-01-22T10:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := parseRecurrence(tt.rrule, tt.exdate, tt.start)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при разборе правила: %v", err)
+			}
+			got := rec.Occurrences(tt.start, tt.from, tt.to)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Ожидается %d повторений, получено %d: %v", len(tt.want), len(got), got)
+			}
+			for i, want := range tt.want {
+				if got[i].Format(time.RFC3339) != want {
+					t.Errorf("Повторение %d: ожидается %s, получено %s", i, want, got[i].Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
+
+func TestParseRecurrenceErrors(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name, rrule, exdate string
+	}{
+		{"missing freq", "INTERVAL=2", ""},
+		{"unknown freq", "FREQ=HOURLY", ""},
+		{"count with until", "FREQ=DAILY;COUNT=2;UNTIL=20240310", ""},
+		{"numbered byday in weekly rule", "FREQ=WEEKLY;BYDAY=1MO", ""},
+		{"ordinal out of range", "FREQ=MONTHLY;BYDAY=6FR", ""},
+		{"too many monthly days", "FREQ=MONTHLY;BYDAY=1MO,2MO,3MO,4MO,-1MO,1TU,2TU,3TU,4TU,-1TU,1WE", ""},
+		{"invalid until", "FREQ=DAILY;UNTIL=tomorrow", ""},
+		{"invalid exdate", "FREQ=DAILY", "2024-13-01"},
+		{"exdate without rrule", "", "2024-03-02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseRecurrence(tt.rrule, tt.exdate, start); errorStatus(err) != http.StatusBadRequest {
+				t.Errorf("Ожидается ошибка валидации, получено %v", err)
+			}
+		})
+	}
+}
+
+// Правило из JSON не проходит через parseRecurrence, поэтому Validate сама отвергает повторы и длинные списки
+func TestRecurrenceValidateByDay(t *testing.T) {
+	days := func(codes ...string) []WeekdayNum {
+		result := make([]WeekdayNum, len(codes))
+		for i, code := range codes {
+			day, err := parseWeekdayNum(code)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка при разборе дня: %v", err)
+			}
+			result[i] = day
+		}
+		return result
+	}
+	tests := []struct {
+		name  string
+		rec   Recurrence
+		valid bool
+	}{
+		{"all weekdays", Recurrence{Freq: FreqWeekly, ByDay: days("MO", "TU", "WE", "TH", "FR", "SA", "SU")}, true},
+		{"duplicate weekly day", Recurrence{Freq: FreqWeekly, ByDay: days("MO", "MO")}, false},
+		{"eight weekly days", Recurrence{Freq: FreqWeekly, ByDay: days("MO", "TU", "WE", "TH", "FR", "SA", "SU", "MO")}, false},
+		{"same weekday with different ordinals", Recurrence{Freq: FreqMonthly, ByDay: days("1MO", "-1MO", "MO")}, true},
+		{"duplicate monthly day", Recurrence{Freq: FreqMonthly, ByDay: days("-1FR", "-1FR")}, false},
+		{"monthly limit", Recurrence{Freq: FreqMonthly,
+			ByDay: days("1MO", "2MO", "3MO", "4MO", "-1MO", "1TU", "2TU", "3TU", "4TU", "-1TU")}, true},
+		{"over monthly limit", Recurrence{Freq: FreqMonthly,
+			ByDay: days("1MO", "2MO", "3MO", "4MO", "-1MO", "1TU", "2TU", "3TU", "4TU", "-1TU", "1WE")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rec.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Неожиданная ошибка: %v", err)
+			}
+			if !tt.valid && errorStatus(err) != http.StatusBadRequest {
+				t.Errorf("Ожидается ошибка валидации, получено %v", err)
+			}
+		})
+	}
+}
+
+func TestRecurrenceString(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, moscow)
+	for rrule, want := range map[string]string{
+		"FREQ=MONTHLY;BYDAY=MO,-1FR;INTERVAL=2": "FREQ=MONTHLY;INTERVAL=2;BYDAY=MO,-1FR",
+		"FREQ=DAILY;UNTIL=20240310":             "FREQ=DAILY;UNTIL=20240310",
+		"FREQ=DAILY;UNTIL=20240310T060000Z":     "FREQ=DAILY;UNTIL=20240310T060000Z",
+	} {
+		rec, err := parseRecurrence(rrule, "", start)
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка при разборе %q: %v", rrule, err)
+		}
+		if got := rec.String(); got != want {
+			t.Errorf("Ожидается %q, получено %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Напоминания о событиях: фоновый планировщик находит наступившие напоминания
+// и отправляет их через Notifier. Состояние доставки хранится в ReminderStore,
+// чтобы после перезапуска напоминания не терялись и не отправлялись повторно.
+
+const (
+	// maxReminders — сколько напоминаний можно задать одному событию
+	maxReminders = 5
+	// maxReminderBefore — самое раннее напоминание относительно начала события
+	maxReminderBefore = 7 * 24 * time.Hour
+)
+
+// parseReminders разбирает список напоминаний вида "15m,1h" (за сколько до начала события)
+func parseReminders(value string) ([]Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var reminders []Duration
+	for _, part := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, newValidationError(fmt.Sprintf("invalid reminder %q", part))
+		}
+		reminders = append(reminders, Duration(d))
+	}
+	return reminders, nil
+}
+
+// validateReminders проверяет напоминания события; дубликаты удаляются, порядок — от раннего к позднему
+func validateReminders(reminders []Duration) ([]Duration, error) {
+	if len(reminders) > maxReminders {
+		return nil, newValidationError(fmt.Sprintf("at most %d reminders per event", maxReminders))
+	}
+	seen := make(map[Duration]bool, len(reminders))
+	var result []Duration
+	for _, d := range reminders {
+		if d < 0 || time.Duration(d) > maxReminderBefore {
+			return nil, newValidationError(fmt.Sprintf("reminder must be between 0s and %s before start", maxReminderBefore))
+		}
+		if !seen[d] {
+			seen[d] = true
+			result = append(result, d)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] > result[j] })
+	return result, nil
+}
+
+// ReminderStore хранит состояние доставки напоминаний
+type ReminderStore interface {
+	// Watermark возвращает момент, до которого включительно все напоминания обработаны;
+	// нулевое время — планировщик еще ни разу не запускался
+	Watermark() (time.Time, error)
+	// Delivered сообщает, было ли уже доставлено напоминание с ключом key
+	Delivered(key string) (bool, error)
+	// MarkDelivered записывает доставку напоминания, которое срабатывало в fireAt
+	MarkDelivered(key string, fireAt time.Time) error
+	// Advance сдвигает watermark и забывает доставки, сработавшие не позже него
+	Advance(watermark time.Time) error
+}
+
+// memoryReminderStore хранит состояние доставки в памяти процесса
+type memoryReminderStore struct {
+	mu        sync.Mutex
+	watermark time.Time
+	delivered map[string]time.Time // ключ -> время срабатывания
+}
+
+func newMemoryReminderStore() *memoryReminderStore {
+	return &memoryReminderStore{delivered: make(map[string]time.Time)}
+}
+
+func (m *memoryReminderStore) Watermark() (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.watermark, nil
+}
+
+func (m *memoryReminderStore) Delivered(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.delivered[key]
+	return ok, nil
+}
+
+func (m *memoryReminderStore) MarkDelivered(key string, fireAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delivered[key] = fireAt
+	return nil
+}
+
+func (m *memoryReminderStore) Advance(watermark time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advanceLocked(watermark)
+	return nil
+}
+
+func (m *memoryReminderStore) advanceLocked(watermark time.Time) {
+	m.watermark = watermark
+	for key, fireAt := range m.delivered {
+		if !fireAt.After(watermark) {
+			delete(m.delivered, key)
+		}
+	}
+}
+
+// Notification — сработавшее напоминание
+type Notification struct {
+	// ID одинаков при повторной отправке того же напоминания: по нему получатель отбрасывает дубликаты
+	ID     string    `json:"id"`
+	Event  Event     `json:"event"`
+	Before Duration  `json:"before"`
+	FireAt time.Time `json:"fire_at"`
+}
+
+// Notifier доставляет напоминания
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// logNotifier пишет напоминания в лог
+type logNotifier struct {
+	logger *slog.Logger
+}
+
+func (l logNotifier) Notify(ctx context.Context, n Notification) error {
+	l.logger.InfoContext(ctx, "reminder",
+		"id", n.ID, "event_id", n.Event.ID, "user_id", n.Event.UserID,
+		"start", n.Event.Start, "before", time.Duration(n.Before).String(), "note", n.Event.Note)
+	return nil
+}
+
+// webhookNotifier отправляет напоминание POST-запросом с JSON-телом.
+// Успехом считается любой ответ 2xx.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (wh webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set("Idempotency-Key", n.ID)
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// newNotifier создает доставщик напоминаний, выбранный в конфигурации
+func newNotifier(cfg RemindersConfig, logger *slog.Logger) Notifier {
+	if cfg.Notifier == "webhook" {
+		return webhookNotifier{url: cfg.WebhookURL, client: &http.Client{Timeout: time.Duration(cfg.WebhookTimeout)}}
+	}
+	return logNotifier{logger: logger}
+}
+
+// ReminderScheduler периодически отправляет наступившие напоминания.
+// Доставка «хотя бы один раз»: напоминание отмечается доставленным после успешной отправки,
+// поэтому при падении между отправкой и записью оно уйдет повторно с тем же ID.
+type ReminderScheduler struct {
+	service  EventService
+	store    ReminderStore
+	notifier Notifier
+	interval time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+// NewReminderScheduler создает планировщик, проверяющий напоминания раз в interval
+func NewReminderScheduler(service EventService, store ReminderStore, notifier Notifier, interval time.Duration) *ReminderScheduler {
+	return &ReminderScheduler{
+		service:  service,
+		store:    store,
+		notifier: notifier,
+		interval: interval,
+		logger:   slog.Default(),
+		now:      time.Now,
+	}
+}
+
+// Run обрабатывает напоминания до отмены ctx
+func (s *ReminderScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.tick(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("reminder scheduler", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick отправляет напоминания, сработавшие после watermark. Напоминание имеет смысл,
+// пока повторение события не закончилось: более старые (например, пропущенные за время
+// остановки сервера) отбрасываются, а неудачные отправки повторяются на следующих тиках.
+func (s *ReminderScheduler) tick(ctx context.Context) error {
+	now := s.now()
+	watermark, err := s.store.Watermark()
+	if err != nil {
+		return err
+	}
+	if watermark.IsZero() {
+		// Первый запуск: старые напоминания не рассылаются
+		return s.store.Advance(now)
+	}
+
+	occurrences, err := s.service.EventsWithReminders(now, now.Add(maxReminderBefore+time.Nanosecond))
+	if err != nil {
+		return err
+	}
+
+	next := now
+	for _, n := range dueNotifications(occurrences, watermark, now) {
+		delivered, err := s.store.Delivered(n.ID)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			continue
+		}
+		if err := s.notifier.Notify(ctx, n); err != nil {
+			s.logger.Warn("reminder delivery failed, will retry", "id", n.ID, "error", err)
+			// watermark не должен пройти мимо недоставленного напоминания
+			if retry := n.FireAt.Add(-time.Nanosecond); retry.Before(next) {
+				next = retry
+			}
+			continue
+		}
+		if err := s.store.MarkDelivered(n.ID, n.FireAt); err != nil {
+			return err
+		}
+	}
+	return s.store.Advance(next)
+}
+
+// dueNotifications возвращает напоминания, сработавшие в (after, now], по повторениям,
+// которые еще не закончились, в порядке срабатывания
+func dueNotifications(occurrences []Event, after, now time.Time) []Notification {
+	var due []Notification
+	for _, occ := range occurrences {
+		if !occ.End.After(now) {
+			continue
+		}
+		for _, before := range occ.Reminders {
+			fireAt := occ.Start.Add(-time.Duration(before))
+			if fireAt.After(after) && !fireAt.After(now) {
+				due = append(due, Notification{
+					ID:     fmt.Sprintf("%d-%d-%d", occ.ID, occ.Start.Unix(), int64(time.Duration(before).Seconds())),
+					Event:  occ,
+					Before: before,
+					FireAt: fireAt,
+				})
+			}
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].FireAt.Before(due[j].FireAt) })
+	return due
+}
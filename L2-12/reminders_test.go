@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier запоминает отправленные напоминания и отказывает в доставке заданное число раз
+type fakeNotifier struct {
+	mu       sync.Mutex
+	failures map[string]int // ID напоминания -> сколько попыток еще завершится ошибкой
+	attempts map[string]int
+	sent     []Notification
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{failures: make(map[string]int), attempts: make(map[string]int)}
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, n Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts[n.ID]++
+	if f.failures[n.ID] > 0 {
+		f.failures[n.ID]--
+		return errors.New("receiver is down")
+	}
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// take возвращает ID отправленных с прошлого вызова напоминаний
+func (f *fakeNotifier) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.sent))
+	for _, n := range f.sent {
+		ids = append(ids, n.ID)
+	}
+	f.sent = nil
+	return ids
+}
+
+// newTestScheduler создает планировщик над repo с часами clock
+func newTestScheduler(repo EventRepository, notifier Notifier, clock *fakeClock) (*ReminderScheduler, EventService) {
+	service := NewEventService(repo, ServiceOptions{Location: time.UTC})
+	scheduler := NewReminderScheduler(service, repo.Reminders(), notifier, time.Minute)
+	scheduler.now = clock.Now
+	scheduler.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return scheduler, service
+}
+
+// tickAt переводит часы на at, выполняет тик и сверяет отправленные напоминания с want
+func tickAt(t *testing.T, scheduler *ReminderScheduler, clock *fakeClock, notifier *fakeNotifier, at time.Time, want ...string) {
+	t.Helper()
+	clock.now = at
+	if err := scheduler.tick(context.Background()); err != nil {
+		t.Fatalf("Тик в %s: неожиданная ошибка %v", at.Format(time.Kitchen), err)
+	}
+	got := notifier.take()
+	if len(got) != len(want) {
+		t.Fatalf("Тик в %s: ожидаются напоминания %v, отправлены %v", at.Format(time.Kitchen), want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Тик в %s: ожидаются напоминания %v, отправлены %v", at.Format(time.Kitchen), want, got)
+		}
+	}
+}
+
+// at возвращает время 1 марта 2024 года в UTC
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestReminderSchedulerTicksAndRestart(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			dir := t.TempDir()
+			repo := repository.open(t, dir)
+			clock := &fakeClock{}
+			notifier := newFakeNotifier()
+			scheduler, service := newTestScheduler(repo, notifier, clock)
+
+			first, err := service.CreateEvent(Event{UserID: 1, Start: at(10, 0), End: at(11, 0),
+				Reminders: []Duration{Duration(30 * time.Minute), Duration(10 * time.Minute)}})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка: %v", err)
+			}
+			second, err := service.CreateEvent(Event{UserID: 1, Start: at(10, 30), End: at(11, 0),
+				Reminders: []Duration{Duration(28 * time.Minute)}})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка: %v", err)
+			}
+			early := notificationID(first, 30*time.Minute)
+			late := notificationID(first, 10*time.Minute)
+
+			// Первый запуск только запоминает момент старта: напоминание 9:30 уже в прошлом
+			tickAt(t, scheduler, clock, notifier, at(9, 45))
+			tickAt(t, scheduler, clock, notifier, at(9, 49))
+			tickAt(t, scheduler, clock, notifier, at(9, 50), late)
+			// Повторный тик в то же время ничего не отправляет
+			tickAt(t, scheduler, clock, notifier, at(9, 50))
+			if watermark, _ := repo.Reminders().Watermark(); !watermark.Equal(at(9, 50)) {
+				t.Errorf("Ожидается watermark 9:50, получено %v", watermark)
+			}
+			if notifier.attempts[early] != 0 {
+				t.Errorf("Напоминание до первого запуска не должно отправляться, попыток %d", notifier.attempts[early])
+			}
+
+			if !repository.persistent {
+				return
+			}
+			// После перезапуска watermark сохраняется: напоминание, сработавшее во время простоя,
+			// уходит один раз, а уже отправленные не повторяются
+			if err := repo.Close(); err != nil {
+				t.Fatalf("Неожиданная ошибка при закрытии: %v", err)
+			}
+			repo = repository.open(t, dir)
+			defer repo.Close()
+			notifier = newFakeNotifier()
+			scheduler, _ = newTestScheduler(repo, notifier, clock)
+			tickAt(t, scheduler, clock, notifier, at(10, 5), notificationID(second, 28*time.Minute))
+			tickAt(t, scheduler, clock, notifier, at(10, 6))
+		})
+	}
+}
+
+func TestReminderSchedulerRetriesAndDedupe(t *testing.T) {
+	for _, repository := range testRepositories {
+		t.Run(repository.name, func(t *testing.T) {
+			repo := repository.open(t, t.TempDir())
+			defer repo.Close()
+			clock := &fakeClock{}
+			notifier := newFakeNotifier()
+			scheduler, service := newTestScheduler(repo, notifier, clock)
+
+			event, err := service.CreateEvent(Event{UserID: 1, Start: at(12, 0), End: at(13, 0),
+				Reminders: []Duration{Duration(10 * time.Minute), Duration(5 * time.Minute)}})
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка: %v", err)
+			}
+			failing := notificationID(event, 10*time.Minute)
+			delivered := notificationID(event, 5*time.Minute)
+			notifier.failures[failing] = 2
+
+			tickAt(t, scheduler, clock, notifier, at(11, 0))
+			// Первое напоминание не доставлено, второе ушло
+			tickAt(t, scheduler, clock, notifier, at(11, 56), delivered)
+			// watermark остается перед недоставленным напоминанием
+			if watermark, _ := repo.Reminders().Watermark(); !watermark.Equal(at(11, 50).Add(-time.Nanosecond)) {
+				t.Errorf("Ожидается watermark перед 11:50, получено %v", watermark)
+			}
+			// Повторная попытка снова неудачна, доставленное напоминание не дублируется
+			tickAt(t, scheduler, clock, notifier, at(11, 57))
+			tickAt(t, scheduler, clock, notifier, at(11, 58), failing)
+			tickAt(t, scheduler, clock, notifier, at(11, 59))
+
+			if notifier.attempts[failing] != 3 || notifier.attempts[delivered] != 1 {
+				t.Errorf("Ожидается 3 попытки и 1 доставка, получено %v", notifier.attempts)
+			}
+			if watermark, _ := repo.Reminders().Watermark(); !watermark.Equal(at(11, 59)) {
+				t.Errorf("Ожидается watermark 11:59, получено %v", watermark)
+			}
+		})
+	}
+}
+
+func TestReminderSchedulerDropsFinishedOccurrences(t *testing.T) {
+	repo := NewMemoryRepository()
+	clock := &fakeClock{}
+	notifier := newFakeNotifier()
+	scheduler, service := newTestScheduler(repo, notifier, clock)
+
+	event, err := service.CreateEvent(Event{UserID: 1, Start: at(10, 0), End: at(10, 30),
+		Reminders: []Duration{Duration(15 * time.Minute)}})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	id := notificationID(event, 15*time.Minute)
+	notifier.failures[id] = 100
+
+	tickAt(t, scheduler, clock, notifier, at(9, 0))
+	tickAt(t, scheduler, clock, notifier, at(9, 46))
+	// Пока событие идет, недоставленное напоминание повторяется, после его окончания — нет
+	tickAt(t, scheduler, clock, notifier, at(10, 29))
+	tickAt(t, scheduler, clock, notifier, at(10, 30))
+	if notifier.attempts[id] != 2 {
+		t.Errorf("Ожидается 2 попытки, получено %d", notifier.attempts[id])
+	}
+	if watermark, _ := repo.Reminders().Watermark(); !watermark.Equal(at(10, 30)) {
+		t.Errorf("Ожидается watermark 10:30, получено %v", watermark)
+	}
+}
+
+// notificationID — ID напоминания за before до начала события
+func notificationID(event Event, before time.Duration) string {
+	return dueNotifications([]Event{event}, event.Start.Add(-before-time.Second), event.Start.Add(-before))[0].ID
+}
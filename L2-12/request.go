@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Разбор тел POST-запросов и выбор формата ответа по заголовку Accept
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeForm     = "application/x-www-form-urlencoded"
+	contentTypeCalendar = "text/calendar"
+)
+
+// eventResponseTypes — форматы, в которых API отдает результаты: события можно получить
+// и в iCalendar, остальные ответы всегда в JSON
+var eventResponseTypes = []string{contentTypeJSON, contentTypeCalendar}
+
+// defaultMaxBodyBytes — ограничение размера тела запроса по умолчанию
+const defaultMaxBodyBytes = 1 << 20
+
+// Поля тел POST-запросов. Неизвестные поля отклоняются, чтобы опечатка в имени
+// параметра не превращалась молча в пустое значение.
+var (
+	eventFields       = []string{"user_id", "date", "start", "end", "tz", "note", "rrule", "exdate", "reminders", "attendees"}
+	updateEventFields = append([]string{"id"}, eventFields...)
+	deleteEventFields = []string{"id", "user_id"}
+)
+
+// readParams читает параметры POST-запроса из тела application/x-www-form-urlencoded
+// или application/json. Параметры из queryString тоже учитываются, но тело имеет приоритет.
+// Тело ограничено maxBytes байтами; поля не из списка fields считаются ошибкой.
+func readParams(w http.ResponseWriter, r *http.Request, maxBytes int64, fields ...string) (url.Values, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	mediaType := contentTypeForm
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, newStatusError(http.StatusUnsupportedMediaType, "invalid Content-Type")
+		}
+	}
+
+	var body url.Values
+	switch mediaType {
+	case contentTypeForm:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			if tooLarge := bodyTooLarge(err); tooLarge != nil {
+				return nil, tooLarge
+			}
+			return nil, newValidationError("invalid form body")
+		}
+		if body, err = url.ParseQuery(string(data)); err != nil {
+			return nil, newValidationError("invalid form body")
+		}
+	case contentTypeJSON:
+		var err error
+		if body, err = decodeJSONParams(r.Body); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, newStatusError(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("unsupported Content-Type %q, use %s or %s", mediaType, contentTypeForm, contentTypeJSON))
+	}
+
+	allowed := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		allowed[field] = true
+	}
+	var unknown []string
+	for key := range body {
+		if !allowed[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, newValidationError("unknown fields: " + strings.Join(unknown, ", "))
+	}
+
+	params := r.URL.Query()
+	for key, values := range body {
+		params[key] = values
+	}
+	return params, nil
+}
+
+// decodeJSONParams читает JSON-объект с простыми значениями: строками, числами, булевыми
+// значениями или массивами строк и чисел (они склеиваются через запятую, как exdate в форме)
+func decodeJSONParams(body io.Reader) (url.Values, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		if tooLarge := bodyTooLarge(err); tooLarge != nil {
+			return nil, tooLarge
+		}
+		return nil, newValidationError("invalid JSON body")
+	}
+	if decoder.More() {
+		return nil, newValidationError("invalid JSON body: unexpected data after object")
+	}
+
+	params := make(url.Values, len(raw))
+	for key, value := range raw {
+		s, err := jsonParamString(value)
+		if err != nil {
+			return nil, newValidationError(fmt.Sprintf("field %s: %v", key, err))
+		}
+		params.Set(key, s)
+	}
+	return params, nil
+}
+
+func jsonParamString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			switch item := item.(type) {
+			case string:
+				parts[i] = item
+			case json.Number:
+				parts[i] = item.String()
+			default:
+				return "", errors.New("array items must be strings or numbers")
+			}
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return "", errors.New("must be a string, number or array")
+	}
+}
+
+// bodyTooLarge возвращает ошибку с кодом 413, если чтение тела прервано из-за лимита размера
+func bodyTooLarge(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return newStatusError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", maxErr.Limit))
+	}
+	return nil
+}
+
+// acceptRange — элемент заголовка Accept с весом q
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate выбирает из offers формат ответа, наиболее предпочтительный для клиента.
+// Пустой или отсутствующий Accept означает первый из offers; пустая строка — ни один не подходит.
+func negotiate(r *http.Request, offers ...string) string {
+	header := r.Header.Values("Accept")
+	if len(header) == 0 {
+		return offers[0]
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(strings.Join(header, ","), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	if len(ranges) == 0 {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, ar := range ranges {
+			if s := acceptMatch(ar.mediaType, offer); s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptMatch возвращает точность совпадения диапазона Accept с типом: 2 — точное,
+// 1 — type/*, 0 — */*, -1 — не совпадает
+func acceptMatch(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// errNotAcceptable — ни один из форматов ответа не подходит клиенту
+func errNotAcceptable(offers ...string) error {
+	return newStatusError(http.StatusNotAcceptable, "acceptable response types: "+strings.Join(offers, ", "))
+}
+
+// requireAccept отвечает 406 до вызова обработчика, если клиент не принимает ни один из offers.
+// Проверка идет заранее, чтобы запрос на изменение не выполнялся, когда ответ все равно не отдать.
+func requireAccept(next http.HandlerFunc, offers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if negotiate(r, offers...) == "" {
+			writeError(w, errNotAcceptable(offers...))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// respond отправляет результат в формате, выбранном по Accept: события — в iCalendar,
+// если клиент предпочитает его, все остальное — JSON-документом {"result": ...}.
+// Результат, который нельзя отдать в принятом клиентом формате, заменяется ответом 406.
+func respond(w http.ResponseWriter, r *http.Request, result interface{}) {
+	var events []Event
+	switch v := result.(type) {
+	case []Event:
+		events = v
+	case Event:
+		events = []Event{v}
+	default:
+		if negotiate(r, contentTypeJSON) == "" {
+			writeError(w, errNotAcceptable(contentTypeJSON))
+			return
+		}
+		writeResult(w, result)
+		return
+	}
+
+	if negotiate(r, eventResponseTypes...) != contentTypeCalendar {
+		writeResult(w, result)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeICS(&buf, events, time.Now()); err != nil {
+		writeError(w, internalError(err))
+		return
+	}
+	w.Header().Set("Content-Type", icsContentType)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRespondNegotiation(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := Event{ID: 1, UserID: 1, Start: start, End: start.Add(time.Hour), Note: "planning"}
+	tests := []struct {
+		name        string
+		accept      string
+		result      interface{}
+		wantStatus  int
+		wantType    string
+		wantContain string
+	}{
+		{"event as json", contentTypeJSON, event, http.StatusOK, contentTypeJSON, `"note":"planning"`},
+		{"event as calendar", contentTypeCalendar, event, http.StatusOK, contentTypeCalendar, "SUMMARY:planning"},
+		{"message as json", "", "event deleted", http.StatusOK, contentTypeJSON, "event deleted"},
+		{"message with any type", "text/calendar, */*;q=0.1", "event deleted", http.StatusOK, contentTypeJSON, "event deleted"},
+		{"message as calendar", contentTypeCalendar, "event deleted", http.StatusNotAcceptable, contentTypeJSON, contentTypeJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			respond(w, r, tt.result)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Ожидается статус %d, получено %d", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("Ожидается Content-Type %s, получено %q", tt.wantType, got)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantContain) {
+				t.Errorf("Ответ должен содержать %q, получено %s", tt.wantContain, w.Body.String())
+			}
+		})
+	}
+}
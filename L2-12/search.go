@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поиск событий с постраничной выдачей. Курсор — непрозрачная для клиента строка с позицией
+// последнего события страницы и отпечатком параметров поиска, с которыми он выдан.
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// searchCursor — содержимое курсора
+type searchCursor struct {
+	Start time.Time `json:"s"`
+	ID    int       `json:"i"`
+	// Query — отпечаток параметров поиска: курсор нельзя применить к другому запросу
+	Query string `json:"q"`
+}
+
+// searchResponse — результат поиска
+type searchResponse struct {
+	Events []Event `json:"events"`
+	// NextCursor передается в параметре cursor для получения следующей страницы
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func encodeSearchCursor(pos SearchPosition, fingerprint string) string {
+	data, _ := json.Marshal(searchCursor{Start: pos.Start, ID: pos.ID, Query: fingerprint})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(value, fingerprint string) (*SearchPosition, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, newValidationError("invalid cursor")
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, newValidationError("invalid cursor")
+	}
+	if cursor.Query != fingerprint {
+		return nil, newValidationError("cursor does not match the search parameters")
+	}
+	return &SearchPosition{Start: cursor.Start, ID: cursor.ID}, nil
+}
+
+// searchFingerprint — отпечаток параметров, от которых зависит порядок и состав выдачи
+func searchFingerprint(query SearchQuery) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		strconv.Itoa(query.UserID),
+		strings.Join(strings.Fields(strings.ToLower(query.Text)), " "),
+		query.From.UTC().Format(time.RFC3339Nano),
+		query.To.UTC().Format(time.RFC3339Nano),
+		strconv.FormatBool(query.Descending),
+	}, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseSearchTime разбирает границу диапазона: дату 2006-01-02 (полночь в поясе loc)
+// или время в форматах parseEventTime
+func parseSearchTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, loc); err == nil {
+		return t, nil
+	}
+	return parseEventTime(value, loc)
+}
+
+// Поиск событий пользователя: q — слова из заметки, from и to — диапазон [from, to),
+// sort — start (по умолчанию) или -start, limit — размер страницы, cursor — продолжение выдачи
+func (s *server) searchEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	params := r.URL.Query()
+	loc, err := parseLocation(params.Get("tz"), s.loc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := parseUserID(params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := SearchQuery{UserID: userID, Text: params.Get("q"), Limit: defaultSearchLimit}
+	if value := params.Get("from"); value != "" {
+		if query.From, err = parseSearchTime(value, loc); err != nil {
+			writeError(w, newValidationError("invalid from"))
+			return
+		}
+	}
+	if value := params.Get("to"); value != "" {
+		if query.To, err = parseSearchTime(value, loc); err != nil {
+			writeError(w, newValidationError("invalid to"))
+			return
+		}
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		writeError(w, newValidationError("invalid date range"))
+		return
+	}
+	switch params.Get("sort") {
+	case "", "start":
+	case "-start":
+		query.Descending = true
+	default:
+		writeError(w, newValidationError("sort must be start or -start"))
+		return
+	}
+	if value := params.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 1 || query.Limit > maxSearchLimit {
+			writeError(w, newValidationError("limit must be between 1 and "+strconv.Itoa(maxSearchLimit)))
+			return
+		}
+	}
+	fingerprint := searchFingerprint(query)
+	if value := params.Get("cursor"); value != "" {
+		if query.After, err = decodeSearchCursor(value, fingerprint); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	page, err := s.service.SearchEvents(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := searchResponse{Events: page.Events}
+	if page.Next != nil {
+		result.NextCursor = encodeSearchCursor(*page.Next, fingerprint)
+	}
+	respond(w, r, result)
+}
@@ -0,0 +1,997 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minTime и maxTime — границы диапазона «за все время»
+var (
+	minTime = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// conflictHorizon — насколько вперед проверяются пересечения повторяющихся событий
+const conflictHorizon = 366 * 24 * time.Hour
+
+// Политики обработки пересекающихся событий одного пользователя
+const (
+	// ConflictIgnore — пересечения не проверяются
+	ConflictIgnore = "ignore"
+	// ConflictFlag — событие сохраняется, а ID пересекающихся событий возвращаются в поле conflicts
+	ConflictFlag = "flag"
+	// ConflictReject — событие, пересекающееся с другими, не сохраняется
+	ConflictReject = "reject"
+)
+
+var (
+	// ErrEventNotFound возвращается, если события с таким ID нет
+	ErrEventNotFound = newDomainError("event not found")
+	// ErrEventForbidden возвращается при попытке изменить событие другого пользователя
+	ErrEventForbidden = newForbiddenError("event belongs to another user")
+)
+
+// Типы изменений событий
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// EventChange — сохраненное изменение события
+type EventChange struct {
+	Type string `json:"type"`
+	// Event — событие после изменения, для удаления — удаленное событие
+	Event Event `json:"event"`
+	// Before — событие до изменения, если изменение могло убрать из него участников; иначе nil.
+	// Нужно, чтобы сообщить об изменении тем, кто перестал видеть событие.
+	Before *Event    `json:"-"`
+	At     time.Time `json:"at"`
+}
+
+// participants возвращает пользователей, которые видели событие до изменения или видят после
+func (c EventChange) participants() []int {
+	users := c.Event.participants()
+	if c.Before == nil {
+		return users
+	}
+	for _, userID := range c.Before.participants() {
+		if !c.Event.involves(userID) {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
+
+// forUser возвращает изменение так, как его видит пользователь. Тому, кого убрали из события,
+// оно приходит удалением с событием до изменения: в его календаре события больше нет.
+// ok = false, если пользователь не видел событие ни до, ни после изменения.
+func (c EventChange) forUser(userID int) (change EventChange, ok bool) {
+	switch {
+	case c.Event.involves(userID):
+		return c, true
+	case c.Before != nil && c.Before.involves(userID):
+		return EventChange{Type: ChangeDeleted, Event: *c.Before, At: c.At}, true
+	default:
+		return EventChange{}, false
+	}
+}
+
+// ChangeListener получает изменения событий после их сохранения в хранилище.
+// Вызывается синхронно в обработчике запроса, поэтому долгую работу выполняет в фоне.
+type ChangeListener interface {
+	EventChanged(change EventChange)
+}
+
+// Операции записи события в хранилище (EventWrite.Op)
+const (
+	// WriteCreate сохраняет новое событие, ID присваивает хранилище
+	WriteCreate = "create"
+	// WriteRestore сохраняет событие с прежним ID; ErrEventExists, если ID занят
+	WriteRestore = "restore"
+	// WriteUpdate заменяет событие с тем же ID; ErrEventNotFound, если его нет
+	WriteUpdate = "update"
+	// WriteDelete удаляет событие по ID; ErrEventNotFound, если его нет
+	WriteDelete = "delete"
+)
+
+// EventWrite — одна запись события в EventRepository.Write
+type EventWrite struct {
+	Op string
+	// Event — сохраняемое событие; для удаления используется только ID
+	Event Event
+	// History — версия события, которая сохраняется в той же операции; nil, если не нужна.
+	// Type, ChangedBy, At, Before и RevertedTo заполняет сервис, остальное — хранилище.
+	History *HistoryEntry
+}
+
+// writeOne выполняет одну запись через Write и возвращает событие после нее
+func writeOne(repo EventRepository, w EventWrite) (Event, error) {
+	events, err := repo.Write([]EventWrite{w})
+	if err != nil {
+		return Event{}, err
+	}
+	return events[0], nil
+}
+
+// EventRepository описывает хранилище событий.
+// Бизнес-логика работает только с этим интерфейсом и не знает, где физически лежат данные.
+type EventRepository interface {
+	// Create сохраняет новое событие и возвращает его с присвоенным ID
+	Create(event Event) (Event, error)
+	// Update заменяет существующее событие с тем же ID
+	Update(event Event) error
+	// Delete удаляет событие по ID
+	Delete(id int) error
+	// Write атомарно выполняет записи по порядку: сохраняются либо все, либо ни одна.
+	// Возвращает события после записи в том же порядке, для удаления — удаленное событие.
+	// Create, Update, Delete и Restore — то же, что Write с одной записью.
+	Write(writes []EventWrite) ([]Event, error)
+	// Get возвращает событие по ID
+	Get(id int) (Event, error)
+	// GetByUID возвращает событие пользователя с идентификатором из .ics. ErrEventNotFound, если его нет.
+	GetByUID(userID int, uid string) (Event, error)
+	// ListByUser возвращает события пользователя — созданные им и те, на которые он приглашен, —
+	// которые могут пересечься с полуинтервалом [from, to):
+	// обычные события, пересекающиеся с интервалом, и повторяющиеся события, начавшиеся до to,
+	// последнее повторение которых закончилось не раньше from. Повторения разворачивает сервис.
+	ListByUser(userID int, from, to time.Time) ([]Event, error)
+	// Search возвращает не больше query.Limit событий пользователя в порядке выдачи поиска,
+	// следующих за query.After: заметка содержит все слова query.Text без учета регистра,
+	// а событие может пересечься с [query.From, query.To) по правилам ListByUser.
+	// Попадает ли в диапазон хотя бы одно повторение, проверяет сервис.
+	Search(query SearchQuery) ([]Event, error)
+	// UpdateAttendee атомарно заменяет участника события с тем же UserID и возвращает событие.
+	// ErrNotInvited, если такого участника нет. Если history не nil, в той же операции
+	// сохраняется версия события, Before и After в ней заполняет хранилище.
+	UpdateAttendee(id int, attendee Attendee, history *HistoryEntry) (Event, error)
+	// Count возвращает общее число хранимых событий (повторяющееся событие считается одним)
+	Count() (int, error)
+	// ListWithReminders возвращает события всех пользователей с напоминаниями,
+	// которые могут пересечься с [from, to), по тем же правилам, что и ListByUser
+	ListWithReminders(from, to time.Time) ([]Event, error)
+	// Restore снова сохраняет удаленное событие с его прежним ID. ErrEventExists, если ID занят.
+	Restore(event Event) error
+	// Reminders возвращает хранилище состояния доставки напоминаний
+	Reminders() ReminderStore
+	// History возвращает хранилище истории изменений событий
+	History() HistoryStore
+	// Ping проверяет, что хранилище доступно и может принимать запись
+	Ping(ctx context.Context) error
+	// Close сбрасывает несохраненные данные и освобождает ресурсы хранилища
+	Close() error
+}
+
+// EventService — бизнес-логика календаря. Не зависит от HTTP-сервера.
+// Все методы возвращают типизированные ошибки: ValidationError, DomainError, ForbiddenError
+// или InternalError.
+type EventService interface {
+	CreateEvent(event Event) (Event, error)
+	UpdateEvent(event Event) (Event, error)
+	DeleteEvent(id, userID int) error
+	// RespondToEvent сохраняет ответ приглашенного пользователя на событие
+	RespondToEvent(id, userID int, status string) (Event, error)
+	// ImportEvents сохраняет события из .ics в календарь пользователя: события с уже известным UID
+	// обновляются, остальные создаются, а события, на которые пользователь приглашен, пропускаются.
+	// Если хоть одно событие не проходит проверку или запись не удалась, не сохраняется ни одно.
+	ImportEvents(userID int, events []Event) ([]Event, error)
+	// ListEvents возвращает сохраненные события пользователя, которые могут пересечься с [from, to),
+	// не разворачивая повторения
+	ListEvents(userID int, from, to time.Time) ([]Event, error)
+	EventsInRange(userID int, from, to time.Time) ([]Event, error)
+	EventsForDay(userID int, date time.Time) ([]Event, error)
+	EventsForWeek(userID int, date time.Time) ([]Event, error)
+	EventsForMonth(userID int, date time.Time) ([]Event, error)
+	// CountEvents возвращает число событий всех пользователей
+	CountEvents() (int, error)
+	// EventsWithReminders возвращает повторения событий с напоминаниями всех пользователей,
+	// пересекающиеся с [from, to)
+	EventsWithReminders(from, to time.Time) ([]Event, error)
+	// SearchEvents ищет сохраненные события пользователя по тексту заметки и диапазону дат
+	SearchEvents(query SearchQuery) (SearchPage, error)
+	// EventHistory возвращает версии события по возрастанию номера; смотреть историю может только владелец
+	EventHistory(id, userID int) ([]HistoryEntry, error)
+	// RevertEvent возвращает событию состояние после версии version, удаленное событие восстанавливается
+	RevertEvent(id, userID, version int) (Event, error)
+	// FreeBusy возвращает занятое время пользователей в [from, to) в поясе from
+	FreeBusy(userIDs []int, from, to time.Time) ([]UserBusy, error)
+	// FindSlots ищет рабочее время, свободное у всех пользователей запроса
+	FindSlots(query SlotQuery) ([]TimeSlot, error)
+	// Ping проверяет доступность хранилища
+	Ping(ctx context.Context) error
+}
+
+// SearchQuery — параметры поиска событий
+type SearchQuery struct {
+	UserID int
+	// Text — слова, каждое из которых должно встречаться в заметке (без учета регистра)
+	Text string
+	// From и To оставляют события, которые сами или одним из повторений пересекаются с [From, To);
+	// нулевое время — без ограничения
+	From, To time.Time
+	// Descending — сортировка от поздних событий к ранним
+	Descending bool
+	Limit      int
+	// After — позиция последнего события предыдущей страницы
+	After *SearchPosition
+}
+
+// SearchPosition — место события в порядке выдачи поиска: по началу, при равенстве — по ID
+type SearchPosition struct {
+	Start time.Time
+	ID    int
+}
+
+// SearchPage — страница результатов поиска
+type SearchPage struct {
+	Events []Event
+	// Next — позиция для запроса следующей страницы; nil, если страница последняя
+	Next *SearchPosition
+}
+
+// ServiceOptions — настройки сервиса событий
+type ServiceOptions struct {
+	// Location — часовой пояс событий, для которых свой пояс не указан
+	Location *time.Location
+	// ConflictPolicy — ConflictIgnore, ConflictFlag или ConflictReject
+	ConflictPolicy string
+	// Listeners получают изменения событий
+	Listeners []ChangeListener
+}
+
+// eventService — реализация EventService поверх EventRepository
+type eventService struct {
+	repo           EventRepository
+	loc            *time.Location // часовой пояс событий без своего пояса
+	conflictPolicy string
+	listeners      []ChangeListener
+}
+
+// NewEventService создает сервис событий, работающий с переданным хранилищем
+func NewEventService(repo EventRepository, opts ServiceOptions) EventService {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.ConflictPolicy == "" {
+		opts.ConflictPolicy = ConflictFlag
+	}
+	return &eventService{repo: repo, loc: opts.Location, conflictPolicy: opts.ConflictPolicy, listeners: opts.Listeners}
+}
+
+func (s *eventService) CreateEvent(event Event) (Event, error) {
+	event.ID = 0
+	event, err := s.prepare(event)
+	if err != nil {
+		return Event{}, err
+	}
+	conflicts, err := s.checkConflicts(event)
+	if err != nil {
+		return Event{}, err
+	}
+	created, err := writeOne(s.repo, EventWrite{Op: WriteCreate, Event: event, History: s.change(ChangeCreated, event.UserID)})
+	if err != nil {
+		return Event{}, internalError(err)
+	}
+	created = s.localize(created)
+	created.Conflicts = conflicts
+	s.notify(ChangeCreated, nil, created)
+	return created, nil
+}
+
+func (s *eventService) UpdateEvent(event Event) (Event, error) {
+	event, err := s.prepare(event)
+	if err != nil {
+		return Event{}, err
+	}
+	current, err := s.ownedEvent(event.ID, event.UserID)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Attendees = keepResponses(current, event)
+	// UID задает только импорт, форма изменения события его не передает
+	event.UID = current.UID
+	conflicts, err := s.checkConflicts(event)
+	if err != nil {
+		return Event{}, err
+	}
+	history := s.change(ChangeUpdated, event.UserID)
+	history.Before = versionOf(current)
+	if _, err := writeOne(s.repo, EventWrite{Op: WriteUpdate, Event: event, History: history}); err != nil {
+		return Event{}, internalError(err)
+	}
+	before := s.localize(current)
+	event = s.localize(event)
+	event.Conflicts = conflicts
+	s.notify(ChangeUpdated, &before, event)
+	return event, nil
+}
+
+func (s *eventService) DeleteEvent(id, userID int) error {
+	event, err := s.ownedEvent(id, userID)
+	if err != nil {
+		return err
+	}
+	history := s.change(ChangeDeleted, userID)
+	history.Before = versionOf(event)
+	if _, err := writeOne(s.repo, EventWrite{Op: WriteDelete, Event: Event{ID: id}, History: history}); err != nil {
+		return internalError(err)
+	}
+	s.notify(ChangeDeleted, nil, s.localize(event))
+	return nil
+}
+
+// ImportEvents сначала проверяет все события файла, включая пересечения с календарем и друг с другом,
+// и только потом сохраняет их одной атомарной записью, поэтому повтор после сбоя не создает копий.
+func (s *eventService) ImportEvents(userID int, events []Event) ([]Event, error) {
+	// index — номер события в файле; current — сохраненная версия события, которое обновляется
+	// импортом, nil для нового
+	type importItem struct {
+		index   int
+		event   Event
+		current *Event
+	}
+	invited, err := s.invitedUIDs(userID, events)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]importItem, 0, len(events))
+	uids := make(map[string]int)
+	replaced := make(map[int]bool)
+	for i, event := range events {
+		event.ID, event.UserID = 0, userID
+		if invited[event.UID] {
+			// Чужое событие из экспорта приглашенного: оно уже есть в его календаре
+			continue
+		}
+		if event.UID != "" {
+			if first, ok := uids[event.UID]; ok {
+				return nil, newValidationError(fmt.Sprintf("ics event %d: UID %q is already used by event %d", i+1, event.UID, first+1))
+			}
+			uids[event.UID] = i
+		}
+		current, err := s.importTarget(userID, event.UID)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			// Участники и напоминания в .ics не переносятся и остаются прежними
+			event.ID, event.UID, event.Reminders = current.ID, current.UID, current.Reminders
+			for _, attendee := range current.Attendees {
+				event.Attendees = append(event.Attendees, Attendee{UserID: attendee.UserID})
+			}
+			replaced[current.ID] = true
+		}
+		if event, err = s.prepare(event); err != nil {
+			return nil, importError(i, err)
+		}
+		if current != nil {
+			event.Attendees = keepResponses(*current, event)
+		}
+		items = append(items, importItem{index: i, event: event, current: current})
+	}
+
+	if s.conflictPolicy == ConflictReject {
+		for i, item := range items {
+			conflicts, err := s.overlapping(item.event)
+			if err != nil {
+				return nil, err
+			}
+			// Обновляемые события файла сравниваются с их новыми версиями, а не с сохраненными
+			var stored []int
+			for _, id := range conflicts {
+				if !replaced[id] {
+					stored = append(stored, id)
+				}
+			}
+			if len(stored) > 0 {
+				return nil, importError(item.index, conflictError(stored))
+			}
+			for _, other := range items[:i] {
+				if s.eventsOverlap(item.event, other.event) {
+					return nil, newDomainError(fmt.Sprintf("ics event %d overlaps with ics event %d", item.index+1, other.index+1))
+				}
+			}
+		}
+	}
+
+	if len(items) == 0 {
+		return []Event{}, nil
+	}
+	writes := make([]EventWrite, len(items))
+	for i, item := range items {
+		writes[i] = EventWrite{Op: WriteCreate, Event: item.event, History: s.change(ChangeCreated, userID)}
+		if item.current != nil {
+			writes[i].Op = WriteUpdate
+			writes[i].History.Type, writes[i].History.Before = ChangeUpdated, versionOf(*item.current)
+		}
+	}
+	imported, err := s.repo.Write(writes)
+	if err != nil {
+		return nil, internalError(fmt.Errorf("import events: %w", err))
+	}
+	for i, event := range imported {
+		// Пересечения считаются после записи, чтобы в них попали и другие события файла
+		if s.conflictPolicy == ConflictFlag {
+			var err error
+			if event.Conflicts, err = s.overlapping(event); err != nil {
+				slog.Error("find conflicts of imported event", "event_id", event.ID, "error", err)
+			}
+		}
+		changeType, before := ChangeCreated, (*Event)(nil)
+		if current := items[i].current; current != nil {
+			localized := s.localize(*current)
+			changeType, before = ChangeUpdated, &localized
+		}
+		event = s.localize(event)
+		s.notify(changeType, before, event)
+		imported[i] = event
+	}
+	return imported, nil
+}
+
+// invitedUIDs возвращает UID, под которыми экспорт пользователя выдает события, на которые он приглашен.
+// Такие события принадлежат другим пользователям, и при импорте их не нужно копировать в свой календарь.
+func (s *eventService) invitedUIDs(userID int, events []Event) (map[string]bool, error) {
+	withUID := false
+	for _, event := range events {
+		withUID = withUID || event.UID != ""
+	}
+	if !withUID {
+		return nil, nil
+	}
+	stored, err := s.repo.ListByUser(userID, minTime, maxTime)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	uids := make(map[string]bool)
+	for _, event := range stored {
+		if event.UserID != userID {
+			uids[exportUID(event)] = true
+		}
+	}
+	return uids, nil
+}
+
+// importTarget находит событие пользователя, которое обновляет импорт события с этим UID.
+// Кроме сохраненных UID узнаются UID, которые экспорт присваивает событиям без UID.
+func (s *eventService) importTarget(userID int, uid string) (*Event, error) {
+	if uid == "" {
+		return nil, nil
+	}
+	if id, ok := parseExportUID(uid, userID); ok {
+		event, err := s.repo.Get(id)
+		if err == nil && event.UserID == userID && event.UID == "" {
+			return &event, nil
+		}
+		if err != nil && !errors.Is(err, ErrEventNotFound) {
+			return nil, internalError(err)
+		}
+	}
+	event, err := s.repo.GetByUID(userID, uid)
+	if errors.Is(err, ErrEventNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return &event, nil
+}
+
+// importError добавляет к ошибке проверки номер события в файле, сохраняя тип ошибки
+func importError(i int, err error) error {
+	msg := fmt.Sprintf("ics event %d: %v", i+1, err)
+	var validationErr *ValidationError
+	var domainErr *DomainError
+	switch {
+	case errors.As(err, &validationErr):
+		return newValidationError(msg)
+	case errors.As(err, &domainErr):
+		return newDomainError(msg)
+	default:
+		return err
+	}
+}
+
+func (s *eventService) RespondToEvent(id, userID int, status string) (Event, error) {
+	if !validRSVP(status) {
+		return Event{}, newValidationError("status must be accepted, declined or tentative")
+	}
+	respondedAt := time.Now().UTC()
+	event, err := s.repo.UpdateAttendee(id, Attendee{UserID: userID, Status: status, RespondedAt: &respondedAt},
+		s.change(ChangeUpdated, userID))
+	if err != nil {
+		return Event{}, internalError(err)
+	}
+	event = s.localize(event)
+	// Ответ не меняет состав участников, поэтому состояние до изменения не нужно
+	s.notify(ChangeUpdated, nil, event)
+	return event, nil
+}
+
+func (s *eventService) ListEvents(userID int, from, to time.Time) ([]Event, error) {
+	if !from.Before(to) {
+		return nil, newValidationError("invalid date range")
+	}
+	events, err := s.repo.ListByUser(userID, from, to)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	for i := range events {
+		events[i] = s.localize(events[i])
+	}
+	return events, nil
+}
+
+func (s *eventService) EventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	events, err := s.ListEvents(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return expandOccurrences(events, from, to), nil
+}
+
+func (s *eventService) EventsForDay(userID int, date time.Time) ([]Event, error) {
+	from, to := dayRange(date)
+	return s.EventsInRange(userID, from, to)
+}
+
+func (s *eventService) EventsForWeek(userID int, date time.Time) ([]Event, error) {
+	from, to := weekRange(date)
+	return s.EventsInRange(userID, from, to)
+}
+
+func (s *eventService) EventsForMonth(userID int, date time.Time) ([]Event, error) {
+	from, to := monthRange(date)
+	return s.EventsInRange(userID, from, to)
+}
+
+func (s *eventService) CountEvents() (int, error) {
+	n, err := s.repo.Count()
+	return n, internalError(err)
+}
+
+func (s *eventService) EventsWithReminders(from, to time.Time) ([]Event, error) {
+	events, err := s.repo.ListWithReminders(from, to)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	for i := range events {
+		events[i] = s.localize(events[i])
+	}
+	return expandOccurrences(events, from, to), nil
+}
+
+// SearchEvents возвращает события целиком, не разворачивая повторения: серия попадает
+// в выдачу один раз, если хотя бы одно ее повторение пересекается с диапазоном
+func (s *eventService) SearchEvents(query SearchQuery) (SearchPage, error) {
+	from, to := query.From, query.To
+	if from.IsZero() {
+		from = minTime
+	}
+	if to.IsZero() {
+		to = maxTime
+	}
+	if query.Limit <= 0 {
+		return SearchPage{}, newValidationError("limit must be positive")
+	}
+	if !from.Before(to) {
+		return SearchPage{}, newValidationError("invalid date range")
+	}
+
+	// Хранилище отбирает серии по границам грубо, поэтому серии без повторений в диапазоне
+	// отбрасываются здесь, а недостающие события дочитываются следующими порциями
+	batch := query
+	batch.From, batch.To, batch.Limit = from, to, query.Limit+1
+	var matched []Event
+	for len(matched) <= query.Limit {
+		events, err := s.repo.Search(batch)
+		if err != nil {
+			return SearchPage{}, internalError(err)
+		}
+		for _, event := range events {
+			if event = s.localize(event); event.occursIn(from, to) {
+				matched = append(matched, event)
+			}
+		}
+		if len(events) < batch.Limit {
+			break
+		}
+		last := events[len(events)-1]
+		batch.After = &SearchPosition{Start: last.Start, ID: last.ID}
+	}
+
+	page := SearchPage{Events: matched}
+	if len(matched) > query.Limit {
+		page.Events = matched[:query.Limit]
+		last := page.Events[query.Limit-1]
+		page.Next = &SearchPosition{Start: last.Start, ID: last.ID}
+	}
+	return page, nil
+}
+
+func (s *eventService) EventHistory(id, userID int) ([]HistoryEntry, error) {
+	history, err := s.repo.History().List(id)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if len(history) == 0 {
+		// События, созданные до появления истории, существуют, но версий у них нет
+		if _, err := s.ownedEvent(id, userID); err != nil {
+			return nil, err
+		}
+		return history, nil
+	}
+	if history[len(history)-1].owner() != userID {
+		return nil, ErrEventForbidden
+	}
+	for i, entry := range history {
+		if entry.Before != nil {
+			before := s.localize(*entry.Before)
+			history[i].Before = &before
+		}
+		if entry.After != nil {
+			after := s.localize(*entry.After)
+			history[i].After = &after
+		}
+	}
+	return history, nil
+}
+
+// RevertEvent сохраняет состояние после версии version как новое изменение: проверки
+// и политика пересечений те же, что при обычном изменении. Ответы участников сохраняются,
+// если время события не меняется; иначе восстанавливаются ответы из той версии.
+func (s *eventService) RevertEvent(id, userID, version int) (Event, error) {
+	history, err := s.EventHistory(id, userID)
+	if err != nil {
+		return Event{}, err
+	}
+	i := sort.Search(len(history), func(i int) bool { return history[i].Version >= version })
+	if i == len(history) || history[i].Version != version {
+		// Версии старше historyLimit последних уже не хранятся
+		return Event{}, ErrVersionNotFound
+	}
+	target := history[i].After
+	if target == nil {
+		return Event{}, newValidationError("cannot revert to a deletion, choose an earlier version")
+	}
+	event, err := s.prepare(*target)
+	if err != nil {
+		return Event{}, err
+	}
+
+	current, err := s.repo.Get(id)
+	deleted := errors.Is(err, ErrEventNotFound)
+	if err != nil && !deleted {
+		return Event{}, internalError(err)
+	}
+	write := EventWrite{Op: WriteRestore, Event: event, History: s.change(ChangeCreated, userID)}
+	write.History.RevertedTo = version
+	if !deleted {
+		if current.UserID != userID {
+			return Event{}, ErrEventForbidden
+		}
+		write.Event.Attendees = keepResponses(current, event)
+		write.Op, write.History.Type, write.History.Before = WriteUpdate, ChangeUpdated, versionOf(current)
+	}
+	conflicts, err := s.checkConflicts(write.Event)
+	if err != nil {
+		return Event{}, err
+	}
+	if event, err = writeOne(s.repo, write); err != nil {
+		return Event{}, internalError(err)
+	}
+	event = s.localize(event)
+	event.Conflicts = conflicts
+	var before *Event
+	if !deleted {
+		localized := s.localize(current)
+		before = &localized
+	}
+	s.notify(write.History.Type, before, event)
+	return event, nil
+}
+
+func (s *eventService) FreeBusy(userIDs []int, from, to time.Time) ([]UserBusy, error) {
+	if err := validateRange(userIDs, from, to); err != nil {
+		return nil, err
+	}
+	result := make([]UserBusy, 0, len(userIDs))
+	for _, userID := range userIDs {
+		occurrences, err := s.EventsInRange(userID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, UserBusy{UserID: userID, Busy: busyIntervals(userID, occurrences, from, to)})
+	}
+	return result, nil
+}
+
+func (s *eventService) FindSlots(query SlotQuery) ([]TimeSlot, error) {
+	switch {
+	case query.Duration <= 0:
+		return nil, newValidationError("duration must be positive")
+	case query.WorkStart < 0 || query.WorkEnd > 24*time.Hour || query.WorkStart >= query.WorkEnd:
+		return nil, newValidationError("work_start must be before work_end")
+	case query.Duration > query.WorkEnd-query.WorkStart:
+		return nil, newValidationError("duration exceeds working hours")
+	case query.Limit <= 0:
+		return nil, newValidationError("limit must be positive")
+	}
+	users, err := s.FreeBusy(query.UserIDs, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	var busy []TimeSlot
+	for _, user := range users {
+		busy = append(busy, user.Busy...)
+	}
+	return freeSlots(query, mergeIntervals(busy)), nil
+}
+
+func (s *eventService) Ping(ctx context.Context) error {
+	return s.repo.Ping(ctx)
+}
+
+// change начинает версию события, которую хранилище сохранит вместе с изменением:
+// изменение без версии нельзя было бы откатить
+func (s *eventService) change(changeType string, changedBy int) *HistoryEntry {
+	return &HistoryEntry{Type: changeType, ChangedBy: changedBy, At: time.Now().UTC()}
+}
+
+// notify сообщает подписчикам о сохраненном изменении события; before — событие до изменения
+// или nil (см. EventChange.Before)
+func (s *eventService) notify(changeType string, before *Event, event Event) {
+	change := EventChange{Type: changeType, Event: event, Before: before, At: time.Now().UTC()}
+	for _, listener := range s.listeners {
+		listener.EventChanged(change)
+	}
+}
+
+// searchLess задает порядок выдачи поиска: по началу события, при равенстве — по ID.
+// Порядок строгий: событие не стоит раньше самого себя, иначе курсор повторял бы его.
+func searchLess(a, b Event, descending bool) bool {
+	if !a.Start.Equal(b.Start) {
+		return a.Start.Before(b.Start) != descending
+	}
+	if descending {
+		return a.ID > b.ID
+	}
+	return a.ID < b.ID
+}
+
+// searchWords разбивает поисковый запрос на слова в нижнем регистре
+func searchWords(text string) []string {
+	return strings.Fields(strings.ToLower(text))
+}
+
+// containsWords сообщает, встречаются ли в тексте все слова (слова уже в нижнем регистре)
+func containsWords(text string, words []string) bool {
+	text = strings.ToLower(text)
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// ownedEvent возвращает событие, только если оно принадлежит пользователю
+func (s *eventService) ownedEvent(id, userID int) (Event, error) {
+	event, err := s.repo.Get(id)
+	if err != nil {
+		return Event{}, internalError(err)
+	}
+	if event.UserID != userID {
+		return Event{}, ErrEventForbidden
+	}
+	return event, nil
+}
+
+// prepare проверяет событие перед сохранением и заполняет часовой пояс по умолчанию
+func (s *eventService) prepare(event Event) (Event, error) {
+	if event.TimeZone == "" {
+		event.TimeZone = s.loc.String()
+	}
+	if _, err := loadLocation(event.TimeZone); err != nil {
+		return Event{}, newValidationError(fmt.Sprintf("invalid time zone %q", event.TimeZone))
+	}
+	if err := validateEvent(event); err != nil {
+		return Event{}, err
+	}
+	reminders, err := validateReminders(event.Reminders)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Reminders = reminders
+	if event.Attendees, err = validateAttendees(event.UserID, event.Attendees); err != nil {
+		return Event{}, err
+	}
+	// Пересечения и сводка ответов вычисляются заново при каждом запросе и не хранятся
+	event.Conflicts = nil
+	event.Responses = nil
+	return event, nil
+}
+
+// validateEvent проверяет инварианты события, не зависящие от хранилища
+func validateEvent(event Event) error {
+	if event.Start.IsZero() || event.End.IsZero() {
+		return newValidationError("start and end are required")
+	}
+	if !event.End.After(event.Start) {
+		return newValidationError("end must be after start")
+	}
+	if event.Recurrence != nil {
+		return event.Recurrence.Validate()
+	}
+	return nil
+}
+
+// checkConflicts ищет события пользователя, пересекающиеся с event, и применяет политику сервиса
+func (s *eventService) checkConflicts(event Event) ([]int, error) {
+	if s.conflictPolicy == ConflictIgnore {
+		return nil, nil
+	}
+	conflicts, err := s.overlapping(event)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 && s.conflictPolicy == ConflictReject {
+		return nil, conflictError(conflicts)
+	}
+	return conflicts, nil
+}
+
+// conflictWindow возвращает интервал, в котором проверяются пересечения события.
+// Для повторяющихся событий проверяется не дальше conflictHorizon от начала.
+func conflictWindow(event Event) (time.Time, time.Time) {
+	from, to := event.Start, event.End
+	if event.Recurrence != nil {
+		to = event.Start.Add(conflictHorizon)
+		if event.Recurrence.Until != nil && event.Recurrence.lastEnd(event.Duration()).Before(to) {
+			to = event.Recurrence.lastEnd(event.Duration())
+		}
+	}
+	return from, to
+}
+
+// overlapping возвращает ID сохраненных событий пользователя, пересекающихся с event
+func (s *eventService) overlapping(event Event) ([]int, error) {
+	from, to := conflictWindow(event)
+	others, err := s.EventsInRange(event.UserID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	mine := expandOccurrences([]Event{s.localize(event)}, from, to)
+
+	seen := make(map[int]bool)
+	var conflicts []int
+	for _, other := range others {
+		if other.ID == event.ID || seen[other.ID] {
+			continue
+		}
+		// Отклоненное приглашение не занимает время пользователя
+		if attendee, ok := other.attendee(event.UserID); ok && attendee.Status == RSVPDeclined {
+			continue
+		}
+		for _, occurrence := range mine {
+			if occurrence.Start.Before(other.End) && other.Start.Before(occurrence.End) {
+				seen[other.ID] = true
+				conflicts = append(conflicts, other.ID)
+				break
+			}
+		}
+	}
+	sort.Ints(conflicts)
+	return conflicts, nil
+}
+
+// conflictError — отказ сохранить событие, пересекающееся с событиями ids, по политике reject
+func conflictError(ids []int) error {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return newDomainError("event overlaps with events " + strings.Join(parts, ", "))
+}
+
+// eventsOverlap сообщает, пересекаются ли повторения двух еще не сохраненных событий
+func (s *eventService) eventsOverlap(a, b Event) bool {
+	aFrom, aTo := conflictWindow(a)
+	bFrom, bTo := conflictWindow(b)
+	from, to := aFrom, aTo
+	if bFrom.Before(from) {
+		from = bFrom
+	}
+	if bTo.After(to) {
+		to = bTo
+	}
+	first := expandOccurrences([]Event{s.localize(a)}, from, to)
+	second := expandOccurrences([]Event{s.localize(b)}, from, to)
+	// Повторения отсортированы по началу: повторение, закончившееся раньше начала другого,
+	// не может пересечься ни с одним из следующих
+	for i, j := 0, 0; i < len(first) && j < len(second); {
+		switch {
+		case !first[i].End.After(second[j].Start):
+			i++
+		case !second[j].End.After(first[i].Start):
+			j++
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// locations кэширует загруженные часовые пояса: time.LoadLocation каждый раз читает базу tzdata
+var locations sync.Map
+
+// loadLocation возвращает часовой пояс IANA по имени
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// localize переводит время события в его собственный часовой пояс (или пояс сервиса):
+// хранилища могут возвращать его в UTC, а повторения считаются по местному времени события.
+// Заодно заполняет сводку ответов участников: localize проходят все события, которые выдает сервис.
+func (s *eventService) localize(event Event) Event {
+	loc := s.loc
+	if event.TimeZone != "" {
+		if tz, err := loadLocation(event.TimeZone); err == nil {
+			loc = tz
+		}
+	}
+	// У событий, созданных до появления часовых поясов, пояс не записан
+	event.TimeZone = loc.String()
+	event.Start = event.Start.In(loc)
+	event.End = event.End.In(loc)
+	if event.Recurrence != nil {
+		// Копия, чтобы не менять правило, разделяемое с хранилищем в памяти
+		rec := *event.Recurrence
+		if rec.Until != nil {
+			until := rec.Until.In(loc)
+			rec.Until = &until
+		}
+		rec.Exceptions = make([]time.Time, len(event.Recurrence.Exceptions))
+		for i, ex := range event.Recurrence.Exceptions {
+			rec.Exceptions[i] = ex.In(loc)
+		}
+		event.Recurrence = &rec
+	}
+	event.Responses = summarizeResponses(event.Attendees)
+	return event
+}
+
+// dayRange возвращает границы суток, в которые попадает date
+func dayRange(date time.Time) (time.Time, time.Time) {
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return from, from.AddDate(0, 0, 1)
+}
+
+// weekRange возвращает границы недели (с понедельника по воскресенье), в которую попадает date
+func weekRange(date time.Time) (time.Time, time.Time) {
+	from, _ := dayRange(date)
+	offset := (int(from.Weekday()) + 6) % 7 // понедельник — 0
+	from = from.AddDate(0, 0, -offset)
+	return from, from.AddDate(0, 0, 7)
+}
+
+// monthRange возвращает границы календарного месяца, в который попадает date
+func monthRange(date time.Time) (time.Time, time.Time) {
+	from := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	return from, from.AddDate(0, 1, 0)
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite" // драйвер SQLite на чистом Go, cgo не нужен
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func init() {
+	// Встроенная lower() меняет регистр только у ASCII, а поиск должен находить
+	// заметки на любом языке так же, как хранилище в памяти
+	sqlite.MustRegisterDeterministicScalarFunction("calendar_lower", 1,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			text, _ := args[0].(string)
+			return strings.ToLower(text), nil
+		})
+}
+
+// sqliteMigrations — миграции схемы. Номер примененной миграции хранится в PRAGMA user_version,
+// поэтому новые миграции можно только дописывать в конец списка.
+var sqliteMigrations = []string{
+	`CREATE TABLE events (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    INTEGER NOT NULL,
+		event_date INTEGER NOT NULL, -- unix-время в секундах, UTC
+		note       TEXT    NOT NULL DEFAULT ''
+	);
+	CREATE INDEX idx_events_user_date ON events (user_id, event_date);`,
+
+	// Повторяющиеся события: правило хранится в JSON, а его граница — отдельной колонкой для выборки
+	`ALTER TABLE events ADD COLUMN recurrence TEXT;
+	ALTER TABLE events ADD COLUMN recurrence_until INTEGER; -- unix-время конца последнего дня, NULL — без ограничения`,
+
+	// Начало и конец события, часовой пояс. Старые события становятся событиями на весь день,
+	// а вместо границы правила хранится конец последнего повторения.
+	`ALTER TABLE events RENAME COLUMN event_date TO start_at;
+	ALTER TABLE events ADD COLUMN end_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE events ADD COLUMN time_zone TEXT NOT NULL DEFAULT '';
+	ALTER TABLE events ADD COLUMN all_day INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE events RENAME COLUMN recurrence_until TO recurrence_end;
+	UPDATE events SET end_at = start_at + 86400, all_day = 1, recurrence_end = recurrence_end + 86400;`,
+
+	// Напоминания: список хранится в JSON, состояние доставки — в отдельных таблицах
+	`ALTER TABLE events ADD COLUMN reminders TEXT;
+	CREATE INDEX idx_events_start ON events (start_at) WHERE reminders IS NOT NULL;
+	CREATE TABLE reminder_deliveries (
+		key     TEXT    PRIMARY KEY,
+		fire_at INTEGER NOT NULL -- unix-время срабатывания в наносекундах
+	);
+	CREATE TABLE scheduler_state (
+		name  TEXT    PRIMARY KEY,
+		value INTEGER NOT NULL -- для reminders_watermark: unix-время в наносекундах
+	);`,
+
+	// Участники: список с ответами хранится в JSON, а таблица event_attendees нужна,
+	// чтобы находить приглашения пользователя
+	`ALTER TABLE events ADD COLUMN attendees TEXT;
+	CREATE TABLE event_attendees (
+		event_id INTEGER NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+		user_id  INTEGER NOT NULL,
+		PRIMARY KEY (user_id, event_id)
+	);`,
+
+	// История изменений. Внешнего ключа на events нет: история удаленного события нужна для отката.
+	`CREATE TABLE event_history (
+		event_id    INTEGER NOT NULL,
+		version     INTEGER NOT NULL,
+		type        TEXT    NOT NULL,
+		changed_by  INTEGER NOT NULL,
+		changed_at  INTEGER NOT NULL, -- unix-время в наносекундах
+		before      TEXT,             -- событие в JSON, NULL при создании
+		after       TEXT,             -- событие в JSON, NULL при удалении
+		reverted_to INTEGER,
+		PRIMARY KEY (event_id, version)
+	);`,
+
+	// Идентификатор события из импортированного .ics, уникальный в календаре пользователя
+	`ALTER TABLE events ADD COLUMN uid TEXT;
+	CREATE UNIQUE INDEX idx_events_user_uid ON events (user_id, uid) WHERE uid IS NOT NULL;`,
+}
+
+// sqliteRepository хранит события в базе SQLite
+type sqliteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository открывает базу по пути path и применяет недостающие миграции
+func NewSQLiteRepository(path string) (EventRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	// Транзакции начинаются с BEGIN IMMEDIATE: все они пишут, и блокировка записи, взятая сразу,
+	// ждет по busy_timeout, а не обрывает транзакцию, прочитавшую данные до чужой записи
+	// Путь экранируется как часть URI, чтобы "?" и "#" в имени файла не меняли параметры;
+	// относительный путь без абсолютного префикса был бы разобран как имя хоста
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve sqlite path: %w", err)
+	}
+	dsn := url.URL{Scheme: "file", Path: abs,
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteRepository{db: db}, nil
+}
+
+// migrateSQLite последовательно применяет миграции, которых еще нет в базе
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than supported %d", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA не поддерживает плейсхолдеры, номер версии подставляется напрямую
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// eventColumns — колонки, читаемые scanEvent
+const eventColumns = `id, user_id, start_at, end_at, time_zone, all_day, note, recurrence, reminders, attendees, uid`
+
+func (s *sqliteRepository) Create(event Event) (Event, error) {
+	return writeOne(s, EventWrite{Op: WriteCreate, Event: event})
+}
+
+func (s *sqliteRepository) Restore(event Event) error {
+	_, err := writeOne(s, EventWrite{Op: WriteRestore, Event: event})
+	return err
+}
+
+func (s *sqliteRepository) Update(event Event) error {
+	_, err := writeOne(s, EventWrite{Op: WriteUpdate, Event: event})
+	return err
+}
+
+func (s *sqliteRepository) Delete(id int) error {
+	_, err := writeOne(s, EventWrite{Op: WriteDelete, Event: Event{ID: id}})
+	return err
+}
+
+// Write выполняет все записи и сохраняет их версии в одной транзакции
+func (s *sqliteRepository) Write(writes []EventWrite) ([]Event, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("write events: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := make([]Event, len(writes))
+	for i, w := range writes {
+		event := w.Event
+		switch w.Op {
+		case WriteCreate:
+			event.ID = 0
+			event, err = insertEvent(tx, event)
+		case WriteRestore:
+			event, err = insertEvent(tx, event)
+		case WriteUpdate:
+			err = updateEvent(tx, event)
+		case WriteDelete:
+			event, err = deleteEvent(tx, event.ID)
+		default:
+			err = fmt.Errorf("unknown write %q", w.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if w.History != nil {
+			if err := appendHistory(tx, historyEntry(*w.History, w.Op, event)); err != nil {
+				return nil, err
+			}
+		}
+		result[i] = event
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("write events: %w", err)
+	}
+	return result, nil
+}
+
+// insertEvent сохраняет событие; ID 0 означает, что его присвоит база. Занятый ID — ErrEventExists,
+// остальные нарушения ограничений (например, повтор UID) возвращаются как есть.
+func insertEvent(tx *sql.Tx, event Event) (Event, error) {
+	recurrence, recurrenceEnd, err := encodeRecurrence(event)
+	if err != nil {
+		return Event{}, err
+	}
+	reminders, err := encodeReminders(event.Reminders)
+	if err != nil {
+		return Event{}, err
+	}
+	attendees, err := encodeAttendees(event.Attendees)
+	if err != nil {
+		return Event{}, err
+	}
+	id := sql.NullInt64{Int64: int64(event.ID), Valid: event.ID != 0}
+	res, err := tx.Exec(`INSERT INTO events (id, user_id, start_at, end_at, time_zone, all_day, note, recurrence, recurrence_end, reminders, attendees, uid)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, event.UserID, event.Start.Unix(), event.End.Unix(), event.TimeZone, event.AllDay, event.Note,
+		recurrence, recurrenceEnd, reminders, attendees, encodeUID(event.UID))
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+		return Event{}, ErrEventExists
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("insert event: %w", err)
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return Event{}, fmt.Errorf("insert event: %w", err)
+	}
+	event.ID = int(lastID)
+	if err := saveAttendees(tx, event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+func updateEvent(tx *sql.Tx, event Event) error {
+	recurrence, recurrenceEnd, err := encodeRecurrence(event)
+	if err != nil {
+		return err
+	}
+	reminders, err := encodeReminders(event.Reminders)
+	if err != nil {
+		return err
+	}
+	attendees, err := encodeAttendees(event.Attendees)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE events SET user_id = ?, start_at = ?, end_at = ?, time_zone = ?, all_day = ?, note = ?,
+		recurrence = ?, recurrence_end = ?, reminders = ?, attendees = ?, uid = ? WHERE id = ?`,
+		event.UserID, event.Start.Unix(), event.End.Unix(), event.TimeZone, event.AllDay, event.Note,
+		recurrence, recurrenceEnd, reminders, attendees, encodeUID(event.UID), event.ID)
+	if err != nil {
+		return fmt.Errorf("update event: %w", err)
+	}
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+	return saveAttendees(tx, event)
+}
+
+// deleteEvent удаляет событие и возвращает его
+func deleteEvent(tx *sql.Tx, id int) (Event, error) {
+	event, err := scanEvent(tx.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Event{}, ErrEventNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("delete event: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
+		return Event{}, fmt.Errorf("delete event: %w", err)
+	}
+	return event, nil
+}
+
+// UpdateAttendee меняет только JSON со списком участников: их состав остается прежним.
+// Чтение, запись и версия в истории идут в одной транзакции с блокировкой записи, поэтому
+// одновременные ответы разных участников выполняются по очереди и не затирают друг друга.
+func (s *sqliteRepository) UpdateAttendee(id int, attendee Attendee, history *HistoryEntry) (Event, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Event{}, fmt.Errorf("update attendee: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := scanEvent(tx.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Event{}, ErrEventNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("update attendee: %w", err)
+	}
+	event, err := current.withAttendee(attendee)
+	if err != nil {
+		return Event{}, err
+	}
+	attendees, err := encodeAttendees(event.Attendees)
+	if err != nil {
+		return Event{}, err
+	}
+	if _, err := tx.Exec(`UPDATE events SET attendees = ? WHERE id = ?`, attendees, id); err != nil {
+		return Event{}, fmt.Errorf("update attendee: %w", err)
+	}
+	if history != nil {
+		entry := historyEntry(*history, WriteUpdate, event)
+		entry.Before = versionOf(current)
+		if err := appendHistory(tx, entry); err != nil {
+			return Event{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Event{}, fmt.Errorf("update attendee: %w", err)
+	}
+	return event, nil
+}
+
+func (s *sqliteRepository) Get(id int) (Event, error) {
+	row := s.db.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id)
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Event{}, ErrEventNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("get event: %w", err)
+	}
+	return event, nil
+}
+
+func (s *sqliteRepository) GetByUID(userID int, uid string) (Event, error) {
+	row := s.db.QueryRow(`SELECT `+eventColumns+` FROM events WHERE user_id = ? AND uid = ?`, userID, uid)
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Event{}, ErrEventNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("get event by uid: %w", err)
+	}
+	return event, nil
+}
+
+// overlapCondition отбирает события, которые могут пересечься с [from, to); параметры — to, from, from
+const overlapCondition = `start_at < ? AND (end_at > ?
+	OR (recurrence IS NOT NULL AND (recurrence_end IS NULL OR recurrence_end > ?)))`
+
+func (s *sqliteRepository) ListByUser(userID int, from, to time.Time) ([]Event, error) {
+	return s.listEvents(`(user_id = ? OR id IN (SELECT event_id FROM event_attendees WHERE user_id = ?)) AND `+overlapCondition,
+		userID, userID, to.Unix(), from.Unix(), from.Unix())
+}
+
+func (s *sqliteRepository) ListWithReminders(from, to time.Time) ([]Event, error) {
+	return s.listEvents(`reminders IS NOT NULL AND `+overlapCondition, to.Unix(), from.Unix(), from.Unix())
+}
+
+func (s *sqliteRepository) Search(query SearchQuery) ([]Event, error) {
+	where := `(user_id = ? OR id IN (SELECT event_id FROM event_attendees WHERE user_id = ?)) AND ` + overlapCondition
+	args := []interface{}{query.UserID, query.UserID, query.To.Unix(), query.From.Unix(), query.From.Unix()}
+	for _, word := range searchWords(query.Text) {
+		where += ` AND instr(calendar_lower(note), ?) > 0`
+		args = append(args, word)
+	}
+	order, after := "ASC", ">"
+	if query.Descending {
+		order, after = "DESC", "<"
+	}
+	if query.After != nil {
+		start := query.After.Start.Unix()
+		where += ` AND (start_at ` + after + ` ? OR start_at = ? AND id ` + after + ` ?)`
+		args = append(args, start, start, query.After.ID)
+	}
+	args = append(args, query.Limit)
+	return s.queryEvents(`SELECT `+eventColumns+` FROM events WHERE `+where+
+		` ORDER BY start_at `+order+`, id `+order+` LIMIT ?`, args...)
+}
+
+func (s *sqliteRepository) listEvents(where string, args ...interface{}) ([]Event, error) {
+	return s.queryEvents(`SELECT `+eventColumns+` FROM events WHERE `+where+` ORDER BY start_at, id`, args...)
+}
+
+// queryEvents выполняет запрос, выбирающий колонки eventColumns
+func (s *sqliteRepository) queryEvents(query string, args ...interface{}) ([]Event, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list events: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list events: %w", err)
+		}
+		result = append(result, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list events: %w", err)
+	}
+	return result, nil
+}
+
+func (s *sqliteRepository) Reminders() ReminderStore {
+	return sqliteReminderStore{db: s.db}
+}
+
+func (s *sqliteRepository) History() HistoryStore {
+	return sqliteHistoryStore{db: s.db}
+}
+
+func (s *sqliteRepository) Count() (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count events: %w", err)
+	}
+	return n, nil
+}
+
+func (s *sqliteRepository) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping sqlite: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteRepository) Close() error {
+	return s.db.Close()
+}
+
+// rowScanner — общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row rowScanner) (Event, error) {
+	var event Event
+	var start, end int64
+	var recurrence, reminders, attendees, uid sql.NullString
+	if err := row.Scan(&event.ID, &event.UserID, &start, &end, &event.TimeZone, &event.AllDay, &event.Note,
+		&recurrence, &reminders, &attendees, &uid); err != nil {
+		return Event{}, err
+	}
+	event.UID = uid.String
+	event.Start = time.Unix(start, 0).UTC()
+	event.End = time.Unix(end, 0).UTC()
+	if recurrence.Valid {
+		event.Recurrence = &Recurrence{}
+		if err := json.Unmarshal([]byte(recurrence.String), event.Recurrence); err != nil {
+			return Event{}, fmt.Errorf("decode recurrence of event %d: %w", event.ID, err)
+		}
+	}
+	if reminders.Valid {
+		if err := json.Unmarshal([]byte(reminders.String), &event.Reminders); err != nil {
+			return Event{}, fmt.Errorf("decode reminders of event %d: %w", event.ID, err)
+		}
+	}
+	if attendees.Valid {
+		if err := json.Unmarshal([]byte(attendees.String), &event.Attendees); err != nil {
+			return Event{}, fmt.Errorf("decode attendees of event %d: %w", event.ID, err)
+		}
+	}
+	return event, nil
+}
+
+// encodeRecurrence готовит значения колонок recurrence и recurrence_end
+func encodeRecurrence(event Event) (sql.NullString, sql.NullInt64, error) {
+	rec := event.Recurrence
+	if rec == nil {
+		return sql.NullString{}, sql.NullInt64{}, nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return sql.NullString{}, sql.NullInt64{}, fmt.Errorf("encode recurrence: %w", err)
+	}
+	var end sql.NullInt64
+	if rec.Until != nil {
+		end = sql.NullInt64{Int64: rec.lastEnd(event.Duration()).Unix(), Valid: true}
+	}
+	return sql.NullString{String: string(data), Valid: true}, end, nil
+}
+
+// encodeUID готовит значение колонки uid; NULL, если событие создано не импортом
+func encodeUID(uid string) sql.NullString {
+	return sql.NullString{String: uid, Valid: uid != ""}
+}
+
+// encodeReminders готовит значение колонки reminders; NULL, если напоминаний нет
+func encodeReminders(reminders []Duration) (sql.NullString, error) {
+	if len(reminders) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(reminders)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("encode reminders: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// encodeAttendees готовит значение колонки attendees; NULL, если приглашенных нет
+func encodeAttendees(attendees []Attendee) (sql.NullString, error) {
+	if len(attendees) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(attendees)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("encode attendees: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// saveAttendees заменяет строки event_attendees события на его текущих участников
+func saveAttendees(tx *sql.Tx, event Event) error {
+	if _, err := tx.Exec(`DELETE FROM event_attendees WHERE event_id = ?`, event.ID); err != nil {
+		return fmt.Errorf("save attendees: %w", err)
+	}
+	for _, attendee := range event.Attendees {
+		if _, err := tx.Exec(`INSERT INTO event_attendees (event_id, user_id) VALUES (?, ?)`, event.ID, attendee.UserID); err != nil {
+			return fmt.Errorf("save attendees: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqliteReminderStore хранит состояние доставки напоминаний в таблицах reminder_deliveries
+// и scheduler_state
+type sqliteReminderStore struct {
+	db *sql.DB
+}
+
+func (s sqliteReminderStore) Watermark() (time.Time, error) {
+	var value int64
+	err := s.db.QueryRow(`SELECT value FROM scheduler_state WHERE name = 'reminders_watermark'`).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read reminders watermark: %w", err)
+	}
+	return time.Unix(0, value).UTC(), nil
+}
+
+func (s sqliteReminderStore) Delivered(key string) (bool, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM reminder_deliveries WHERE key = ?`, key).Scan(&n); err != nil {
+		return false, fmt.Errorf("read reminder delivery: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s sqliteReminderStore) MarkDelivered(key string, fireAt time.Time) error {
+	if _, err := s.db.Exec(`INSERT OR REPLACE INTO reminder_deliveries (key, fire_at) VALUES (?, ?)`,
+		key, fireAt.UnixNano()); err != nil {
+		return fmt.Errorf("save reminder delivery: %w", err)
+	}
+	return nil
+}
+
+func (s sqliteReminderStore) Advance(watermark time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("advance reminders watermark: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO scheduler_state (name, value) VALUES ('reminders_watermark', ?)`,
+		watermark.UnixNano()); err != nil {
+		return fmt.Errorf("advance reminders watermark: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM reminder_deliveries WHERE fire_at <= ?`, watermark.UnixNano()); err != nil {
+		return fmt.Errorf("prune reminder deliveries: %w", err)
+	}
+	return tx.Commit()
+}
+
+// sqliteHistoryStore хранит историю изменений в таблице event_history
+type sqliteHistoryStore struct {
+	db *sql.DB
+}
+
+// appendHistory сохраняет версию события в транзакции его изменения. Номер версии вычисляется
+// в том же запросе, что и вставляет запись, а версии сверх historyLimit удаляются.
+func appendHistory(tx *sql.Tx, entry HistoryEntry) error {
+	before, err := encodeVersion(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := encodeVersion(entry.After)
+	if err != nil {
+		return err
+	}
+	revertedTo := sql.NullInt64{Int64: int64(entry.RevertedTo), Valid: entry.RevertedTo != 0}
+
+	err = tx.QueryRow(`INSERT INTO event_history (event_id, version, type, changed_by, changed_at, before, after, reverted_to)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?, ?, ? FROM event_history WHERE event_id = ?
+		RETURNING version`,
+		entry.EventID, entry.Type, entry.ChangedBy, entry.At.UnixNano(), before, after, revertedTo, entry.EventID,
+	).Scan(&entry.Version)
+	if err != nil {
+		return fmt.Errorf("save history entry: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM event_history WHERE event_id = ? AND version <= ?`,
+		entry.EventID, entry.Version-historyLimit); err != nil {
+		return fmt.Errorf("save history entry: %w", err)
+	}
+	return nil
+}
+
+func (s sqliteHistoryStore) List(eventID int) ([]HistoryEntry, error) {
+	rows, err := s.db.Query(`SELECT version, type, changed_by, changed_at, before, after, reverted_to
+		FROM event_history WHERE event_id = ? ORDER BY version`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("list history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		entry := HistoryEntry{EventID: eventID}
+		var (
+			changedAt     int64
+			before, after sql.NullString
+			revertedTo    sql.NullInt64
+		)
+		if err := rows.Scan(&entry.Version, &entry.Type, &entry.ChangedBy, &changedAt, &before, &after, &revertedTo); err != nil {
+			return nil, fmt.Errorf("list history: %w", err)
+		}
+		entry.At = time.Unix(0, changedAt).UTC()
+		entry.RevertedTo = int(revertedTo.Int64)
+		if entry.Before, err = decodeVersion(before); err != nil {
+			return nil, err
+		}
+		if entry.After, err = decodeVersion(after); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list history: %w", err)
+	}
+	return history, nil
+}
+
+// encodeVersion кодирует событие из записи истории в JSON; nil — в NULL
+func encodeVersion(event *Event) (sql.NullString, error) {
+	if event == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("encode history event: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeVersion(data sql.NullString) (*Event, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(data.String), &event); err != nil {
+		return nil, fmt.Errorf("decode history event: %w", err)
+	}
+	return &event, nil
+}
+
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEventNotFound
+	}
+	return nil
+}
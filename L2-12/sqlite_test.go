@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openLegacySQLite создает базу, в которой применены только первые version миграций
+func openLegacySQLite(t *testing.T, path string, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии базы: %v", err)
+	}
+	for i := 0; i < version; i++ {
+		if _, err := db.Exec(sqliteMigrations[i]); err != nil {
+			t.Fatalf("Миграция %d: %v", i+1, err)
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	return db
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	db := openLegacySQLite(t, path, 2)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)
+	// До третьей миграции событие занимало день event_date, а у повторений хранилась дата UNTIL
+	if _, err := db.Exec(`INSERT INTO events (id, user_id, event_date, note) VALUES (1, 1, ?, 'legacy')`, day.Unix()); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO events (id, user_id, event_date, note, recurrence, recurrence_until) VALUES (2, 1, ?, 'weekly', ?, ?)`,
+		day.Unix(), `{"freq":"WEEKLY","until":"2024-03-29T00:00:00Z"}`, until.Unix()); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	db.Close()
+
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при миграции: %v", err)
+	}
+	sqlite := repo.(*sqliteRepository)
+	var version int
+	if err := sqlite.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != len(sqliteMigrations) {
+		t.Errorf("Ожидается версия схемы %d, получено %d, %v", len(sqliteMigrations), version, err)
+	}
+
+	legacy, err := repo.Get(1)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if !legacy.AllDay || !legacy.Start.Equal(day) || !legacy.End.Equal(day.AddDate(0, 0, 1)) || legacy.Note != "legacy" {
+		t.Errorf("Старое событие должно стать событием на весь день, получено %+v", legacy)
+	}
+	// Граница выборки повторений — конец последнего повторения, а не его дата
+	var recurrenceEnd int64
+	if err := sqlite.db.QueryRow(`SELECT recurrence_end FROM events WHERE id = 2`).Scan(&recurrenceEnd); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if want := until.AddDate(0, 0, 1).Unix(); recurrenceEnd != want {
+		t.Errorf("Ожидается recurrence_end %d, получено %d", want, recurrenceEnd)
+	}
+	events, err := repo.ListByUser(1, until, until.AddDate(0, 0, 1))
+	if err != nil || len(events) != 1 || events[0].ID != 2 {
+		t.Errorf("Последнее повторение должно попадать в выборку, получено %+v, %v", events, err)
+	}
+
+	// Новые события получают ID после перенесенных
+	created, err := repo.Create(Event{UserID: 1, Start: day, End: day.Add(time.Hour)})
+	if err != nil || created.ID != 3 {
+		t.Errorf("Ожидается ID 3, получено %d, %v", created.ID, err)
+	}
+	repo.Close()
+
+	// Повторное открытие не применяет миграции заново
+	if repo, err = NewSQLiteRepository(path); err != nil {
+		t.Fatalf("Неожиданная ошибка при повторном открытии: %v", err)
+	}
+	repo.Close()
+}
+
+func TestSQLiteMigrationsFromEmptyAndNewer(t *testing.T) {
+	dir := t.TempDir()
+	openLegacySQLite(t, filepath.Join(dir, "empty.db"), 0).Close()
+	repo, err := NewSQLiteRepository(filepath.Join(dir, "empty.db"))
+	if err != nil {
+		t.Fatalf("Пустая база должна мигрировать, получено %v", err)
+	}
+	repo.Close()
+
+	newer := openLegacySQLite(t, filepath.Join(dir, "newer.db"), 0)
+	newer.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteMigrations)+1))
+	newer.Close()
+	if _, err := NewSQLiteRepository(filepath.Join(dir, "newer.db")); err == nil || !strings.Contains(err.Error(), "newer than supported") {
+		t.Errorf("База новее кода должна отклоняться, получено %v", err)
+	}
+}
+
+func TestSQLiteConcurrentRSVP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	// Два подключения к одной базе — как два процесса сервера
+	first, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	defer first.Close()
+	second, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	defer second.Close()
+
+	const attendees = 20
+	event := Event{UserID: 1, Start: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	event.End = event.Start.Add(time.Hour)
+	for i := 0; i < attendees; i++ {
+		event.Attendees = append(event.Attendees, Attendee{UserID: 100 + i, Status: RSVPNeedsAction})
+	}
+	created, err := first.Create(event)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, attendees)
+	for i := 0; i < attendees; i++ {
+		repo := first
+		if i%2 == 1 {
+			repo = second
+		}
+		wg.Add(1)
+		go func(repo EventRepository, userID int) {
+			defer wg.Done()
+			respondedAt := time.Now().UTC()
+			if _, err := repo.UpdateAttendee(created.ID, Attendee{UserID: userID, Status: RSVPAccepted, RespondedAt: &respondedAt},
+				&HistoryEntry{Type: ChangeUpdated, ChangedBy: userID}); err != nil {
+				errs <- err
+			}
+		}(repo, 100+i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Неожиданная ошибка при ответе: %v", err)
+	}
+
+	// Ни один ответ не затерт параллельной записью
+	stored, err := first.Get(created.ID)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	for _, attendee := range stored.Attendees {
+		if attendee.Status != RSVPAccepted || attendee.RespondedAt == nil {
+			t.Errorf("Ответ участника %d потерян: %+v", attendee.UserID, attendee)
+		}
+	}
+	if len(stored.Attendees) != attendees {
+		t.Errorf("Ожидается %d участников, получено %d", attendees, len(stored.Attendees))
+	}
+	// Версии записаны в транзакциях ответов: каждая начинается с состояния после предыдущей
+	history, err := first.History().List(created.ID)
+	if err != nil || len(history) != attendees {
+		t.Fatalf("Ожидается %d версий, получено %d, %v", attendees, len(history), err)
+	}
+	accepted := func(event *Event) int {
+		n := 0
+		for _, attendee := range event.Attendees {
+			if attendee.Status == RSVPAccepted {
+				n++
+			}
+		}
+		return n
+	}
+	for i, entry := range history {
+		if entry.Version != i+1 || accepted(entry.Before) != i || accepted(entry.After) != i+1 {
+			t.Errorf("Версия %d: ожидается %d ответов до и %d после, получено %d и %d",
+				entry.Version, i, i+1, accepted(entry.Before), accepted(entry.After))
+		}
+	}
+	if _, err := first.UpdateAttendee(created.ID, Attendee{UserID: 999, Status: RSVPAccepted}, nil); !errors.Is(err, ErrNotInvited) {
+		t.Errorf("Ожидается ErrNotInvited, получено %v", err)
+	}
+	if _, err := first.UpdateAttendee(created.ID+1, Attendee{UserID: 100, Status: RSVPAccepted}, nil); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("Ожидается ErrEventNotFound, получено %v", err)
+	}
+}
+
+func TestSQLiteRestoreConflicts(t *testing.T) {
+	// "?" и "#" в пути не должны превращаться в параметры или обрезать имя файла
+	path := filepath.Join(t.TempDir(), "cal?endar#1 100%", "events.db")
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при открытии базы: %v", err)
+	}
+	defer repo.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("База должна лежать по указанному пути: %v", err)
+	}
+
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	event, err := repo.Create(Event{UserID: 1, Start: start, End: start.Add(time.Hour), UID: "team@example.com"})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при создании: %v", err)
+	}
+	if err := repo.Restore(event); !errors.Is(err, ErrEventExists) {
+		t.Errorf("Ожидается ErrEventExists при занятом ID, получено %v", err)
+	}
+	// Повтор UID — не конфликт ID, и выдавать его за ErrEventExists нельзя
+	event.ID++
+	if err := repo.Restore(event); err == nil || errors.Is(err, ErrEventExists) {
+		t.Errorf("Ожидается ошибка ограничения UID, получено %v", err)
+	}
+	event.UID = ""
+	if err := repo.Restore(event); err != nil {
+		t.Errorf("Неожиданная ошибка при восстановлении: %v", err)
+	}
+	if n, _ := repo.Count(); n != 2 {
+		t.Errorf("Ожидается 2 события, получено %d", n)
+	}
+}
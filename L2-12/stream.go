@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Поток изменений календаря в формате Server-Sent Events. Изменения хранятся в ограниченном
+// журнале в памяти, чтобы переподключившийся клиент получил пропущенное по Last-Event-ID.
+
+const contentTypeEventStream = "text/event-stream"
+
+const (
+	// streamRetry — через сколько миллисекунд EventSource переподключается после разрыва
+	streamRetry = 3000
+	// streamWriteTimeout ограничивает запись в поток, чтобы зависший клиент не держал обработчик
+	streamWriteTimeout = 10 * time.Second
+)
+
+// changeEntry — изменение в журнале с порядковым номером
+type changeEntry struct {
+	seq    uint64
+	change EventChange
+}
+
+// streamSubscriber — подключенный поток; notify получает сигнал о новых изменениях
+type streamSubscriber struct {
+	notify chan struct{}
+}
+
+// changeBroker хранит последние изменения событий и будит подключенные потоки.
+// Реализует ChangeListener. ID событий потока имеют вид "<эпоха>-<номер>": эпоха меняется
+// при каждом запуске сервера, поэтому ID из прошлого запуска не путаются с новыми.
+type changeBroker struct {
+	epoch string
+	size  int
+
+	mu          sync.Mutex
+	entries     []changeEntry // от старых к новым, не больше size
+	last        uint64        // номер последнего изменения
+	subscribers map[*streamSubscriber]struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func newChangeBroker(size int) (*changeBroker, error) {
+	epoch, err := randomString(4)
+	if err != nil {
+		return nil, err
+	}
+	return &changeBroker{
+		epoch:       epoch,
+		size:        size,
+		subscribers: make(map[*streamSubscriber]struct{}),
+		closed:      make(chan struct{}),
+	}, nil
+}
+
+func (b *changeBroker) EventChanged(change EventChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last++
+	b.entries = append(b.entries, changeEntry{seq: b.last, change: change})
+	if over := len(b.entries) - b.size; over > 0 {
+		b.entries = append(b.entries[:0:0], b.entries[over:]...)
+	}
+	for sub := range b.subscribers {
+		select {
+		case sub.notify <- struct{}{}:
+		default: // поток еще не забрал прошлый сигнал
+		}
+	}
+}
+
+func (b *changeBroker) subscribe() *streamSubscriber {
+	sub := &streamSubscriber{notify: make(chan struct{}, 1)}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *changeBroker) unsubscribe(sub *streamSubscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// resume возвращает номер, после которого нужно продолжить поток с ID lastID.
+// reset = true, если продолжить нельзя: ID из другого запуска сервера или не разбирается.
+func (b *changeBroker) resume(lastID string) (after uint64, reset bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastID == "" {
+		return b.last, false
+	}
+	epoch, seqStr, _ := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if epoch != b.epoch || err != nil || seq > b.last {
+		return b.last, true
+	}
+	return seq, false
+}
+
+// since возвращает изменения событий пользователя, включая приглашения и события, из которых
+// его убрали, с номерами больше after — так, как их видит пользователь (EventChange.forUser).
+// ok = false, если часть изменений после after уже вытеснена из журнала; тогда last — номер,
+// с которого продолжать.
+func (b *changeBroker) since(userID int, after uint64) (entries []changeEntry, last uint64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) > 0 && after+1 < b.entries[0].seq {
+		return nil, b.last, false
+	}
+	for _, entry := range b.entries {
+		if entry.seq <= after {
+			continue
+		}
+		if change, ok := entry.change.forUser(userID); ok {
+			entries = append(entries, changeEntry{seq: entry.seq, change: change})
+		}
+	}
+	return entries, b.last, true
+}
+
+// id возвращает ID события потока для номера seq
+func (b *changeBroker) id(seq uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// Close завершает все подключенные потоки; вызывается при остановке сервера,
+// иначе открытые потоки не дали бы ему закончить работу
+func (b *changeBroker) Close() {
+	b.closeOnce.Do(func() { close(b.closed) })
+}
+
+// Поток изменений событий пользователя. Продолжение после разрыва — по заголовку Last-Event-ID
+// или параметру last_event_id. Если пропущенные изменения уже вытеснены из журнала,
+// клиент получает событие reset и должен заново загрузить календарь.
+func (s *server) streamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	userID, err := parseUserID(query.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+
+	// Подписка оформляется до чтения журнала, чтобы не пропустить изменение между ними
+	sub := s.changes.subscribe()
+	defer s.changes.unsubscribe(sub)
+	after, reset := s.changes.resume(lastID)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx не должен буферизовать поток
+	w.WriteHeader(http.StatusOK)
+
+	// send пишет в поток и сразу отправляет клиенту
+	send := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	sendReset := func() bool {
+		return send("id: %s\nevent: reset\ndata: {\"reason\":\"changes since last event id are not available\"}\n\n",
+			s.changes.id(after))
+	}
+
+	if !send("retry: %d\n\n", streamRetry) {
+		return
+	}
+	if reset && !sendReset() {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		entries, last, ok := s.changes.since(userID, after)
+		if !ok {
+			after = last
+			if !sendReset() {
+				return
+			}
+		}
+		for _, entry := range entries {
+			data, err := json.Marshal(entry.change)
+			if err != nil {
+				s.logger.Error("encode stream event", "error", err)
+				return
+			}
+			if !send("id: %s\nevent: %s\ndata: %s\n\n", s.changes.id(entry.seq), entry.change.Type, data) {
+				return
+			}
+		}
+		if ok {
+			after = last
+		}
+
+		select {
+		case <-sub.notify:
+		case <-heartbeat.C:
+			// Комментарий не доходит до обработчиков клиента, но не дает прокси закрыть соединение
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.changes.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Исходящие вебхуки: при создании, изменении и удалении события подписки пользователя
+// получают JSON с подписью HMAC-SHA256. Неудачные доставки повторяются с экспоненциальной
+// задержкой. Последние доставки хранятся в памяти и доступны через API.
+
+// Заголовки запроса вебхука
+const (
+	webhookSignatureHeader = "X-Calendar-Signature"
+	webhookTimestampHeader = "X-Calendar-Timestamp"
+	webhookDeliveryHeader  = "X-Calendar-Delivery"
+	webhookEventHeader     = "X-Calendar-Event"
+)
+
+// Состояния доставки
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	// ErrWebhookNotFound возвращается, если подписки с таким ID нет
+	ErrWebhookNotFound = newDomainError("webhook not found")
+	// ErrWebhookForbidden возвращается при обращении к подписке другого пользователя
+	ErrWebhookForbidden = newForbiddenError("webhook belongs to another user")
+)
+
+// Webhook — подписка пользователя на изменения его событий
+type Webhook struct {
+	ID     string `json:"id"`
+	UserID int    `json:"user_id"`
+	URL    string `json:"url"`
+	// Secret — ключ подписи; клиенту отдается только при создании подписки
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery — запись журнала доставки одного изменения в одну подписку
+type WebhookDelivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	UserID    int    `json:"user_id"`
+	EventID   int    `json:"event_id"`
+	Change    string `json:"change"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	// ResponseStatus — HTTP-код последней попытки, 0 — ответа не было
+	ResponseStatus int        `json:"response_status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// webhookPayload — тело запроса вебхука
+type webhookPayload struct {
+	Change     string    `json:"change"`
+	Event      Event     `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// validWebhookURL проверяет, что вебхук можно отправить по адресу: http(s) с хостом
+func validWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// errWebhookTargetBlocked — вебхук ведет во внутреннюю сеть, не входящую в доверенные
+var errWebhookTargetBlocked = errors.New("webhook target address is not allowed")
+
+// parseAllowedNetworks разбирает доверенные сети вебхуков: CIDR или отдельные IP-адреса
+func parseAllowedNetworks(values []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			networks = append(networks, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: must be a CIDR or an IP address", value)
+		}
+		networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return networks, nil
+}
+
+// webhookGuard не дает вебхукам обращаться во внутреннюю сеть сервера: loopback, частные,
+// link-local и неуказанные адреса запрещены, если они не входят в доверенные сети allowed
+type webhookGuard struct {
+	allowed []netip.Prefix
+}
+
+// allow сообщает, можно ли соединяться с адресом addr
+func (g webhookGuard) allow(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, network := range g.allowed {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsUnspecified() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast()
+}
+
+// checkURL проверяет адрес подписки. Адреса, заданные IP или localhost, отклоняются сразу,
+// а имена хостов проверяются при каждом соединении: DNS может вернуть другой адрес позже.
+func (g webhookGuard) checkURL(target string) error {
+	if !validWebhookURL(target) {
+		return newValidationError("url must be an absolute http(s) URL")
+	}
+	u, _ := url.Parse(target)
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		host = "127.0.0.1"
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !g.allow(addr) {
+		return newValidationError("url must not point to a loopback, private, link-local or unspecified address")
+	}
+	return nil
+}
+
+// control вызывается net.Dialer перед каждым соединением, когда имя хоста уже разрешено в IP
+func (g webhookGuard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !g.allow(addr) {
+		return fmt.Errorf("%w: %s", errWebhookTargetBlocked, addr)
+	}
+	return nil
+}
+
+// client создает HTTP-клиент, который соединяется только с разрешенными адресами, в том числе
+// при переходе по редиректам. Прокси из окружения не используется: с ним проверялся бы адрес прокси.
+func (g webhookGuard) client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: g.control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// signWebhook возвращает значение заголовка подписи: HMAC-SHA256 от "<timestamp>.<тело>".
+// Метка времени входит в подпись, чтобы перехваченный запрос нельзя было повторить позже.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookFile — формат файла подписок
+type webhookFile struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// webhookStore хранит подписки в JSON-файле, который перезаписывается после каждого изменения
+type webhookStore struct {
+	mu       sync.Mutex
+	path     string
+	webhooks map[string]Webhook
+}
+
+// openWebhookStore загружает подписки из файла; отсутствующий файл означает пустой список
+func openWebhookStore(path string) (*webhookStore, error) {
+	store := &webhookStore{path: path, webhooks: make(map[string]Webhook)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read webhooks file: %w", err)
+	}
+	var file webhookFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse webhooks file %s: %w", path, err)
+	}
+	for _, webhook := range file.Webhooks {
+		store.webhooks[webhook.ID] = webhook
+	}
+	return store, nil
+}
+
+// Add создает подписку со случайным секретом
+func (s *webhookStore) Add(userID int, target string) (Webhook, error) {
+	id, err := randomString(8)
+	if err != nil {
+		return Webhook{}, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook := Webhook{ID: id, UserID: userID, URL: target, Secret: secret, CreatedAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[id] = webhook
+	if err := s.saveLocked(); err != nil {
+		delete(s.webhooks, id)
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// Remove удаляет подписку пользователя
+func (s *webhookStore) Remove(id string, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	if webhook.UserID != userID {
+		return ErrWebhookForbidden
+	}
+	delete(s.webhooks, id)
+	if err := s.saveLocked(); err != nil {
+		s.webhooks[id] = webhook
+		return err
+	}
+	return nil
+}
+
+// ListByUser возвращает подписки пользователя в порядке создания
+func (s *webhookStore) ListByUser(userID int) []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := []Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			result = append(result, webhook)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result
+}
+
+func (s *webhookStore) saveLocked() error {
+	file := webhookFile{Webhooks: make([]Webhook, 0, len(s.webhooks))}
+	for _, webhook := range s.webhooks {
+		file.Webhooks = append(file.Webhooks, webhook)
+	}
+	sort.Slice(file.Webhooks, func(i, j int) bool {
+		return file.Webhooks[i].CreatedAt.Before(file.Webhooks[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode webhooks: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create webhooks dir: %w", err)
+	}
+	// В файле лежат секреты подписей
+	if err := writeFileSync(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("write webhooks file: %w", err)
+	}
+	return nil
+}
+
+// webhookJob — доставка, ожидающая очередной попытки
+type webhookJob struct {
+	delivery *WebhookDelivery
+	webhook  Webhook
+	body     []byte
+}
+
+// WebhookDispatcher рассылает изменения событий по подпискам. Реализует ChangeListener:
+// изменение ставится в очередь, а запросы выполняют фоновые обработчики, запущенные Run.
+// Доставки, ожидающие повтора, при остановке сервера теряются.
+type WebhookDispatcher struct {
+	store  *webhookStore
+	guard  webhookGuard
+	client *http.Client
+	cfg    WebhooksConfig
+	logger *slog.Logger
+	queue  chan *webhookJob
+
+	mu         sync.Mutex
+	deliveries []*WebhookDelivery // журнал, от старых к новым, не длиннее cfg.LogSize
+}
+
+// NewWebhookDispatcher открывает файл подписок и создает диспетчер
+func NewWebhookDispatcher(cfg WebhooksConfig, logger *slog.Logger) (*WebhookDispatcher, error) {
+	allowed, err := parseAllowedNetworks(cfg.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openWebhookStore(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	guard := webhookGuard{allowed: allowed}
+	return &WebhookDispatcher{
+		store:  store,
+		guard:  guard,
+		client: guard.client(time.Duration(cfg.Timeout)),
+		cfg:    cfg,
+		logger: logger,
+		queue:  make(chan *webhookJob, cfg.QueueSize),
+	}, nil
+}
+
+// EventChanged ставит доставку изменения в очередь для каждой подписки владельца события и приглашенных,
+// а также тех, кого из события убрали: им изменение приходит удалением (EventChange.forUser)
+func (d *WebhookDispatcher) EventChanged(change EventChange) {
+	for _, userID := range change.participants() {
+		if userChange, ok := change.forUser(userID); ok {
+			d.enqueueChange(userID, userChange)
+		}
+	}
+}
+
+// enqueueChange ставит доставку изменения в очередь для каждой подписки пользователя
+func (d *WebhookDispatcher) enqueueChange(userID int, change EventChange) {
+	webhooks := d.store.ListByUser(userID)
+	if len(webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(webhookPayload{Change: change.Type, Event: change.Event, OccurredAt: change.At})
+	if err != nil {
+		d.logger.Error("encode webhook payload", "error", err)
+		return
+	}
+	for _, webhook := range webhooks {
+		id, err := randomString(8)
+		if err != nil {
+			d.logger.Error("create webhook delivery", "error", err)
+			return
+		}
+		delivery := &WebhookDelivery{
+			ID:        id,
+			WebhookID: webhook.ID,
+			UserID:    webhook.UserID,
+			EventID:   change.Event.ID,
+			Change:    change.Type,
+			Status:    DeliveryPending,
+			CreatedAt: time.Now().UTC(),
+		}
+		d.record(delivery)
+		d.enqueue(&webhookJob{delivery: delivery, webhook: webhook, body: body})
+	}
+}
+
+// Run обрабатывает очередь доставок до отмены ctx
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-d.queue:
+					d.attempt(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Subscribe создает подписку пользователя
+func (d *WebhookDispatcher) Subscribe(userID int, target string) (Webhook, error) {
+	if err := d.guard.checkURL(target); err != nil {
+		return Webhook{}, err
+	}
+	webhook, err := d.store.Add(userID, target)
+	return webhook, internalError(err)
+}
+
+// Unsubscribe удаляет подписку пользователя
+func (d *WebhookDispatcher) Unsubscribe(id string, userID int) error {
+	return internalError(d.store.Remove(id, userID))
+}
+
+// Webhooks возвращает подписки пользователя без секретов
+func (d *WebhookDispatcher) Webhooks(userID int) []Webhook {
+	webhooks := d.store.ListByUser(userID)
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks
+}
+
+// Deliveries возвращает последние доставки пользователя, от новых к старым.
+// Пустые webhookID и status не ограничивают выборку.
+func (d *WebhookDispatcher) Deliveries(userID int, webhookID, status string, limit int) []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := []WebhookDelivery{}
+	for i := len(d.deliveries) - 1; i >= 0 && len(result) < limit; i-- {
+		delivery := d.deliveries[i]
+		if delivery.UserID != userID ||
+			(webhookID != "" && delivery.WebhookID != webhookID) ||
+			(status != "" && delivery.Status != status) {
+			continue
+		}
+		result = append(result, *delivery)
+	}
+	return result
+}
+
+// record добавляет доставку в журнал, вытесняя самые старые записи
+func (d *WebhookDispatcher) record(delivery *WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deliveries = append(d.deliveries, delivery)
+	if over := len(d.deliveries) - d.cfg.LogSize; over > 0 {
+		d.deliveries = append(d.deliveries[:0:0], d.deliveries[over:]...)
+	}
+}
+
+// enqueue ставит попытку в очередь; при переполненной очереди доставка считается неудачной
+func (d *WebhookDispatcher) enqueue(job *webhookJob) {
+	select {
+	case d.queue <- job:
+	default:
+		d.mu.Lock()
+		job.delivery.Status = DeliveryFailed
+		job.delivery.Error = "delivery queue is full"
+		job.delivery.NextAttemptAt = nil
+		d.mu.Unlock()
+		d.logger.Warn("webhook queue is full, delivery dropped", "delivery_id", job.delivery.ID, "webhook_id", job.webhook.ID)
+	}
+}
+
+// attempt выполняет одну попытку доставки и при необходимости планирует следующую
+func (d *WebhookDispatcher) attempt(ctx context.Context, job *webhookJob) {
+	status, err := d.send(ctx, job)
+	if err != nil && ctx.Err() != nil {
+		// Сервер останавливается: прерванная попытка не засчитывается
+		return
+	}
+	now := time.Now().UTC()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery := job.delivery
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastAttemptAt = &now
+	delivery.NextAttemptAt = nil
+	if err == nil {
+		delivery.Status = DeliverySucceeded
+		delivery.Error = ""
+		return
+	}
+	delivery.Error = err.Error()
+	if !retryableStatus(status) || delivery.Attempts >= d.cfg.MaxAttempts {
+		delivery.Status = DeliveryFailed
+		d.logger.Warn("webhook delivery failed", "delivery_id", delivery.ID, "webhook_id", delivery.WebhookID,
+			"attempts", delivery.Attempts, "error", err)
+		return
+	}
+	backoff := d.backoff(delivery.Attempts)
+	next := now.Add(backoff)
+	delivery.NextAttemptAt = &next
+	time.AfterFunc(backoff, func() {
+		if ctx.Err() == nil {
+			d.enqueue(job)
+		}
+	})
+}
+
+// send отправляет подписанный запрос и возвращает код ответа (0, если ответа не было)
+func (d *WebhookDispatcher) send(ctx context.Context, job *webhookJob) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.webhook.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(job.webhook.Secret, timestamp, job.body))
+	req.Header.Set(webhookDeliveryHeader, job.delivery.ID)
+	req.Header.Set(webhookEventHeader, job.delivery.Change)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Тело дочитывается, чтобы соединение вернулось в пул
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff возвращает задержку перед попыткой attempt+1: InitialBackoff, удваиваемый
+// после каждой неудачи, но не больше MaxBackoff
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := time.Duration(d.cfg.InitialBackoff)
+	for i := 1; i < attempt && delay < time.Duration(d.cfg.MaxBackoff); i++ {
+		delay *= 2
+	}
+	return min(delay, time.Duration(d.cfg.MaxBackoff))
+}
+
+// retryableStatus сообщает, имеет ли смысл повторять доставку после такого ответа:
+// повторяются сетевые ошибки, 5xx, 408 и 429, остальные ошибки клиента — нет
+func retryableStatus(status int) bool {
+	return status == 0 || status >= 500 ||
+		status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+//HTTP-обработчики
+
+// Создание подписки. Секрет подписи возвращается только в этом ответе.
+func (s *server) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	params, err := readParams(w, r, s.maxBodyBytes, "user_id", "url")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := parseUserID(params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	webhook, err := s.webhooks.Subscribe(userID, params.Get("url"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, webhook)
+}
+
+// Удаление подписки
+func (s *server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	params, err := readParams(w, r, s.maxBodyBytes, "id", "user_id")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := parseUserID(params.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if params.Get("id") == "" {
+		writeError(w, newValidationError("id is required"))
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.webhooks.Unsubscribe(params.Get("id"), userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, "webhook deleted")
+}
+
+// Список подписок пользователя
+func (s *server) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	userID, err := parseUserID(r.URL.Query().Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, s.webhooks.Webhooks(userID))
+}
+
+// Журнал доставок пользователя: фильтры webhook_id и status, не больше limit записей (по умолчанию 100)
+func (s *server) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	userID, err := parseUserID(query.Get("user_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := query.Get("status")
+	if status != "" && status != DeliveryPending && status != DeliverySucceeded && status != DeliveryFailed {
+		writeError(w, newValidationError("status must be pending, succeeded or failed"))
+		return
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, newValidationError("invalid limit"))
+			return
+		}
+	}
+	if err := authorize(r, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	respond(w, r, s.webhooks.Deliveries(userID, query.Get("webhook_id"), status, limit))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedWebhook — запрос, полученный тестовым приемником
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver — httptest-сервер, который отвечает кодами из statuses по очереди
+// (последний повторяется) и запоминает полученные запросы
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	received []receivedWebhook
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	rcv := &webhookReceiver{statuses: statuses}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		status := rcv.statuses[min(len(rcv.received), len(rcv.statuses)-1)]
+		rcv.received = append(rcv.received, receivedWebhook{header: r.Header.Clone(), body: body})
+		rcv.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *webhookReceiver) requests() []receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]receivedWebhook(nil), rcv.received...)
+}
+
+// newTestDispatcher запускает диспетчер с короткими задержками повторов
+func newTestDispatcher(t *testing.T, maxAttempts int) *WebhookDispatcher {
+	d, err := NewWebhookDispatcher(WebhooksConfig{
+		File:           filepath.Join(t.TempDir(), "webhooks.json"),
+		Workers:        2,
+		QueueSize:      16,
+		Timeout:        Duration(time.Second),
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: Duration(10 * time.Millisecond),
+		MaxBackoff:     Duration(40 * time.Millisecond),
+		LogSize:        16,
+		// Тестовые приемники слушают на loopback
+		AllowedNetworks: []string{"127.0.0.0/8", "::1"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при создании диспетчера: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+// waitDelivery ждет, пока доставка выйдет из состояния pending
+func waitDelivery(t *testing.T, d *WebhookDispatcher, userID int) WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := d.Deliveries(userID, "", "", 1); len(deliveries) == 1 && deliveries[0].Status != DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Доставка не завершилась: %+v", d.Deliveries(userID, "", "", 1))
+	return WebhookDelivery{}
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	rcv := newWebhookReceiver(t, http.StatusOK)
+	d := newTestDispatcher(t, 3)
+	webhook, err := d.Subscribe(7, rcv.URL)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при подписке: %v", err)
+	}
+	// Подписка другого пользователя не должна получать чужие изменения
+	other := newWebhookReceiver(t, http.StatusOK)
+	if _, err := d.Subscribe(8, other.URL); err != nil {
+		t.Fatalf("Неожиданная ошибка при подписке: %v", err)
+	}
+
+	service := NewEventService(NewMemoryRepository(), ServiceOptions{Listeners: []ChangeListener{d}})
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	created, err := service.CreateEvent(Event{UserID: 7, Start: start, End: start.Add(time.Hour), Note: "review"})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка при создании события: %v", err)
+	}
+
+	delivery := waitDelivery(t, d, 7)
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusOK {
+		t.Fatalf("Ожидается успешная доставка с первой попытки, получено %+v", delivery)
+	}
+	requests := rcv.requests()
+	if len(requests) != 1 {
+		t.Fatalf("Ожидается 1 запрос, получено %d", len(requests))
+	}
+	req := requests[0]
+	want := signWebhook(webhook.Secret, req.header.Get(webhookTimestampHeader), req.body)
+	if got := req.header.Get(webhookSignatureHeader); got != want {
+		t.Errorf("Неверная подпись: ожидается %s, получено %s", want, got)
+	}
+	if got := req.header.Get(webhookDeliveryHeader); got != delivery.ID {
+		t.Errorf("Заголовок %s = %q, ожидается %q", webhookDeliveryHeader, got, delivery.ID)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("Тело вебхука не разбирается: %v", err)
+	}
+	if payload.Change != ChangeCreated || payload.Event.ID != created.ID || payload.Event.Note != "review" {
+		t.Errorf("Неожиданное тело вебхука: %+v", payload)
+	}
+	if n := len(other.requests()); n != 0 {
+		t.Errorf("Подписка другого пользователя получила %d запросов", n)
+	}
+}
+
+func TestWebhookRetryWithBackoff(t *testing.T) {
+	rcv := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK)
+	d := newTestDispatcher(t, 5)
+	if _, err := d.Subscribe(1, rcv.URL); err != nil {
+		t.Fatalf("Неожиданная ошибка при подписке: %v", err)
+	}
+
+	d.EventChanged(EventChange{Type: ChangeDeleted, Event: Event{ID: 3, UserID: 1}, At: time.Now()})
+	delivery := waitDelivery(t, d, 1)
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 3 {
+		t.Fatalf("Ожидается успешная доставка с третьей попытки, получено %+v", delivery)
+	}
+
+	requests := rcv.requests()
+	if len(requests) != 3 {
+		t.Fatalf("Ожидается 3 запроса, получено %d", len(requests))
+	}
+	for _, req := range requests {
+		if req.header.Get(webhookDeliveryHeader) != delivery.ID {
+			t.Errorf("Повторы должны идти с тем же ID доставки %s, получено %s", delivery.ID, req.header.Get(webhookDeliveryHeader))
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+	}{
+		{"server error retried until max attempts", http.StatusBadGateway, 3},
+		{"client error is not retried", http.StatusGone, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rcv := newWebhookReceiver(t, test.status)
+			d := newTestDispatcher(t, 3)
+			if _, err := d.Subscribe(1, rcv.URL); err != nil {
+				t.Fatalf("Неожиданная ошибка при подписке: %v", err)
+			}
+
+			d.EventChanged(EventChange{Type: ChangeUpdated, Event: Event{ID: 1, UserID: 1}, At: time.Now()})
+			delivery := waitDelivery(t, d, 1)
+			if delivery.Status != DeliveryFailed || delivery.Attempts != test.attempts || delivery.ResponseStatus != test.status {
+				t.Errorf("Ожидается неудачная доставка после %d попыток с кодом %d, получено %+v",
+					test.attempts, test.status, delivery)
+			}
+			if n := len(rcv.requests()); n != test.attempts {
+				t.Errorf("Ожидается %d запросов, получено %d", test.attempts, n)
+			}
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	d := &WebhookDispatcher{cfg: WebhooksConfig{InitialBackoff: Duration(time.Second), MaxBackoff: Duration(5 * time.Second)}}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := d.backoff(i + 1); got != want {
+			t.Errorf("Задержка после попытки %d: ожидается %s, получено %s", i+1, want, got)
+		}
+	}
+}
+
+func TestWebhookRejectsInternalTargets(t *testing.T) {
+	guard := webhookGuard{}
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://api.localhost/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.1.10/hook",
+		"http://[fd00::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1%25eth0]/hook",
+		"http://0.0.0.0/hook",
+		"http://[::]/hook",
+		"ftp://hooks.example.com/hook",
+		"/relative/hook",
+	} {
+		if err := guard.checkURL(target); errorStatus(err) != http.StatusBadRequest {
+			t.Errorf("Адрес %s должен отклоняться при подписке, получено %v", target, err)
+		}
+	}
+	for _, target := range []string{"https://hooks.example.com/calendar", "http://93.184.216.34/hook"} {
+		if err := guard.checkURL(target); err != nil {
+			t.Errorf("Адрес %s должен приниматься, получено %v", target, err)
+		}
+	}
+
+	// Доверенные сети из настроек разрешены
+	allowed, err := parseAllowedNetworks([]string{"10.0.0.0/8", "fd00::1"})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	guard = webhookGuard{allowed: allowed}
+	for _, target := range []string{"http://10.1.2.3/hook", "http://[fd00::1]/hook"} {
+		if err := guard.checkURL(target); err != nil {
+			t.Errorf("Адрес %s из доверенной сети должен приниматься, получено %v", target, err)
+		}
+	}
+	if err := guard.checkURL("http://192.168.1.10/hook"); err == nil {
+		t.Error("Адрес вне доверенных сетей должен отклоняться")
+	}
+	if _, err := parseAllowedNetworks([]string{"intranet"}); err == nil {
+		t.Error("Ожидается ошибка для сети, которая не является CIDR или IP")
+	}
+}
+
+func TestWebhookBlocksInternalTargetsAtDialTime(t *testing.T) {
+	// Имя хоста может указывать на внутренний адрес, а у старых подписок адрес не проверялся,
+	// поэтому адрес проверяется и при каждом соединении
+	rcv := newWebhookReceiver(t, http.StatusOK)
+	d := newTestDispatcher(t, 1)
+	d.guard = webhookGuard{}
+	d.client = d.guard.client(time.Second)
+	if _, err := d.store.Add(1, rcv.URL); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	d.EventChanged(EventChange{Type: ChangeUpdated, Event: Event{ID: 1, UserID: 1}, At: time.Now()})
+	delivery := waitDelivery(t, d, 1)
+	if delivery.Status != DeliveryFailed || !strings.Contains(delivery.Error, errWebhookTargetBlocked.Error()) {
+		t.Errorf("Доставка на loopback должна быть заблокирована, получено %+v", delivery)
+	}
+	if n := len(rcv.requests()); n != 0 {
+		t.Errorf("Приемник не должен получать запросы, получено %d", n)
+	}
+}